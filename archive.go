@@ -0,0 +1,175 @@
+package conflate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	gocontext "context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	pkgurl "net/url"
+	"path"
+	"strings"
+)
+
+// archiveSeparator separates the url of an archive from the path of a file within it, e.g. config.tar.gz!/main.json.
+const archiveSeparator = "!/"
+
+var (
+	archiveExtensions = []string{".zip", ".tar", ".tar.gz", ".tgz"}
+
+	errArchiveFormat = errors.New("the archive is not a tar, tar.gz or zip file")
+)
+
+// archiveEntries holds the contents of each regular file in an archive, keyed by its path within the archive.
+type archiveEntries map[string][]byte
+
+// splitArchiveURL returns the url of the archive and the path of the file within it, for a url that refers to a file
+// within an archive, e.g. https://host/config.zip!/app/main.json.
+func splitArchiveURL(url *pkgurl.URL) (*pkgurl.URL, string, bool) {
+	i := strings.Index(url.Path, archiveSeparator)
+	if i < 0 || !isArchive(url.Path[:i]) {
+		return nil, "", false
+	}
+
+	archive := *url
+	archive.Path = url.Path[:i]
+	archive.RawPath = ""
+	archive.Fragment = ""
+	archive.RawFragment = ""
+
+	return &archive, archiveEntryPath(url.Path[i+len(archiveSeparator):]), true
+}
+
+func isArchive(name string) bool {
+	name = strings.ToLower(name)
+
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func archiveEntryPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// loadArchiveEntry loads the file within an archive, where each archive is loaded once per run.
+func (l *loader) loadArchiveEntry(ctx gocontext.Context, archiveURL *pkgurl.URL, name string) ([]byte, error) {
+	key := archiveURL.String()
+
+	entries, ok := l.archives[key]
+	if !ok {
+		data, err := l.loadURL(ctx, archiveURL)
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err = l.readArchive(data)
+		if err != nil {
+			return nil, fmt.Errorf("could not read archive %v: %w", redact(archiveURL), err)
+		}
+
+		if l.archives == nil {
+			l.archives = map[string]archiveEntries{}
+		}
+
+		l.archives[key] = entries
+	}
+
+	data, ok := entries[name]
+	if !ok {
+		return nil, fmt.Errorf("%w : %v in %v", fs.ErrNotExist, name, redact(archiveURL))
+	}
+
+	return data, nil
+}
+
+// readArchive reads the files in a zip archive, or a tar archive that may be compressed with gzip.
+func (l *loader) readArchive(data []byte) (archiveEntries, error) {
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		return l.readZip(data)
+	}
+
+	var r io.Reader = bytes.NewReader(data)
+
+	if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		defer gz.Close()
+
+		r = gz
+	}
+
+	return l.readTar(tar.NewReader(r))
+}
+
+func (l *loader) readTar(tr *tar.Reader) (archiveEntries, error) {
+	entries := archiveEntries{}
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+
+		if err != nil {
+			if len(entries) == 0 {
+				return nil, fmt.Errorf("%w: %v", errArchiveFormat, err)
+			}
+
+			return nil, err
+		}
+
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		entries[archiveEntryPath(hdr.Name)], err = l.readAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", hdr.Name, err)
+		}
+	}
+}
+
+func (l *loader) readZip(data []byte) (archiveEntries, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errArchiveFormat, err)
+	}
+
+	entries := archiveEntries{}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		entries[archiveEntryPath(f.Name)], err = l.readZipFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", f.Name, err)
+		}
+	}
+
+	return entries, nil
+}
+
+func (l *loader) readZipFile(f *zip.File) ([]byte, error) {
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return l.readAll(r)
+}
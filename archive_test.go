@@ -0,0 +1,174 @@
+package conflate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testArchiveFiles = map[string]string{
+	"./main.json":   `{"includes": ["app/app.yaml", "?missing.json"], "main": 1}`,
+	"app/app.yaml":  "includes:\n  - ../common.json\napp: 1\n",
+	"common.json":   `{"common": 1}`,
+	"large.json":    `{"large": "` + string(bytes.Repeat([]byte("x"), 100)) + `"}`,
+	"app/notes.txt": "notes",
+}
+
+func testTar(t *testing.T, compress bool) []byte {
+	var (
+		buf bytes.Buffer
+		w   io.Writer = &buf
+	)
+
+	gz := gzip.NewWriter(&buf)
+	if compress {
+		w = gz
+	}
+
+	tw := tar.NewWriter(w)
+
+	assert.Nil(t, tw.WriteHeader(&tar.Header{Name: "app/", Typeflag: tar.TypeDir, Mode: 0o755}))
+
+	for name, data := range testArchiveFiles {
+		assert.Nil(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(data))}))
+
+		_, err := tw.Write([]byte(data))
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, tw.Close())
+
+	if compress {
+		assert.Nil(t, gz.Close())
+	}
+
+	return buf.Bytes()
+}
+
+func testZip(t *testing.T) []byte {
+	var buf bytes.Buffer
+
+	zw := zip.NewWriter(&buf)
+
+	_, err := zw.Create("app/")
+	assert.Nil(t, err)
+
+	for name, data := range testArchiveFiles {
+		w, err := zw.Create(name)
+		assert.Nil(t, err)
+
+		_, err = w.Write([]byte(data))
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, zw.Close())
+
+	return buf.Bytes()
+}
+
+func TestConflate_AddFilesArchive(t *testing.T) {
+	dir := t.TempDir()
+
+	for name, data := range map[string][]byte{
+		"config.tar":    testTar(t, false),
+		"config.tar.gz": testTar(t, true),
+		"config.TGZ":    testTar(t, true),
+		"config.zip":    testZip(t),
+	} {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(path, data, 0o600))
+
+		c := New()
+		err := c.AddFiles(path + "!/main.json")
+		assert.Nil(t, err, name)
+		assert.Equal(t, map[string]interface{}{"main": 1.0, "app": 1.0, "common": 1.0}, c.Data(), name)
+
+		var paths []string
+		for _, u := range c.Sources() {
+			paths = append(paths, filepath.Base(u.Path))
+		}
+
+		assert.Equal(t, []string{"main.json", "app.yaml", "common.json"}, paths, name)
+
+		c.MaxBytes(50)
+		err = c.AddFiles(path + "!/large.json")
+		assert.ErrorIs(t, err, errMaxBytes, name)
+	}
+}
+
+func TestConflate_AddURLsArchive(t *testing.T) {
+	archive := testZip(t)
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write(archive)
+	}))
+
+	defer server.Close()
+
+	u, err := url.Parse(server.URL + "/config.zip!/main.json")
+	assert.Nil(t, err)
+
+	c, err := FromURLs(u)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"main": 1.0, "app": 1.0, "common": 1.0}, c.Data())
+	assert.Equal(t, 1, requests)
+}
+
+func TestLoader_LoadArchiveErrors(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "bad.zip"), []byte("not an archive"), 0o600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "bad.tar"), []byte("not an archive"), 0o600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "config.zip"), testZip(t), 0o600))
+
+	c := New()
+
+	err := c.AddFiles(filepath.Join(dir, "config.zip") + "!/missing.json")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Contains(t, err.Error(), "missing.json in file://")
+
+	for _, name := range []string{"bad.zip", "bad.tar"} {
+		err = c.AddFiles(filepath.Join(dir, name) + "!/main.json")
+		assert.ErrorIs(t, err, errArchiveFormat, name)
+		assert.Contains(t, err.Error(), "could not read archive", name)
+	}
+
+	err = c.AddFiles(filepath.Join(dir, "missing.zip") + "!/main.json")
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, errArchiveFormat)
+}
+
+func TestSplitArchiveURL(t *testing.T) {
+	for raw, want := range map[string][2]string{
+		"file:///conf/config.tar.gz!/main.json":            {"file:///conf/config.tar.gz", "main.json"},
+		"https://host/config.zip!/app/../a/./b.json?x=1#f": {"https://host/config.zip?x=1", "a/b.json"},
+		"file:///conf/config.tgz!/../../etc/passwd":        {"file:///conf/config.tgz", "etc/passwd"},
+	} {
+		u, err := url.Parse(raw)
+		assert.Nil(t, err)
+
+		archive, name, ok := splitArchiveURL(u)
+		assert.True(t, ok, raw)
+		assert.Equal(t, want, [2]string{archive.String(), name}, raw)
+	}
+
+	for _, raw := range []string{"file:///conf/main.json", "file:///conf/wow!/main.json", "file:///conf/config.zip"} {
+		u, err := url.Parse(raw)
+		assert.Nil(t, err)
+
+		_, _, ok := splitArchiveURL(u)
+		assert.False(t, ok, raw)
+	}
+}
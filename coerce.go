@@ -0,0 +1,55 @@
+package conflate
+
+import "time"
+
+// Coercer converts a string value of the data to a value of another type, e.g. a time.Duration, reporting whether
+// the string is one that it converts.
+type Coercer func(s string) (interface{}, bool)
+
+// CoerceDuration converts a string that time.ParseDuration accepts, e.g. "30s" or "1h30m", to a time.Duration.
+// A lone zero is left as it is, as it has no unit.
+func CoerceDuration(s string) (interface{}, bool) {
+	if s == "0" || s == "+0" || s == "-0" {
+		return nil, false
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return nil, false
+	}
+
+	return d, true
+}
+
+// CoerceTime converts an RFC 3339 timestamp, e.g. "2023-01-01T00:00:00Z", to a time.Time.
+func CoerceTime(s string) (interface{}, bool) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, false
+	}
+
+	return t, true
+}
+
+// coerce replaces each string value within the data that one of the coercers converts, trying them in order,
+// returning the value to replace the data with.
+func coerce(data interface{}, coercers []Coercer) interface{} {
+	switch node := data.(type) {
+	case string:
+		for _, fn := range coercers {
+			if val, ok := fn(node); ok {
+				return val
+			}
+		}
+	case map[string]interface{}:
+		for name, prop := range node {
+			node[name] = coerce(prop, coercers)
+		}
+	case []interface{}:
+		for n, item := range node {
+			node[n] = coerce(item, coercers)
+		}
+	}
+
+	return data
+}
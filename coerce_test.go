@@ -0,0 +1,114 @@
+package conflate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoerceDuration(t *testing.T) {
+	val, ok := CoerceDuration("1h30m")
+	assert.True(t, ok)
+	assert.Equal(t, 90*time.Minute, val)
+
+	for _, s := range []string{"0", "-0", "30", "soon", ""} {
+		_, ok = CoerceDuration(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestCoerceTime(t *testing.T) {
+	val, ok := CoerceTime("2023-01-01T00:00:00.5+01:00")
+	assert.True(t, ok)
+	assert.True(t, time.Date(2022, 12, 31, 23, 0, 0, 5e8, time.UTC).Equal(val.(time.Time)))
+
+	for _, s := range []string{"2023-01-01", "30s", ""} {
+		_, ok = CoerceTime(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestConflate_Coerce(t *testing.T) {
+	c, err := FromData([]byte(`{
+		"timeout": "30s",
+		"name": "app",
+		"port": 0,
+		"start": "2023-01-01T00:00:00Z",
+		"retries": ["1s", "2s", "later"],
+		"url": "http://${/name}/${/timeout}"
+	}`))
+	assert.Nil(t, err)
+
+	data := c.Data()
+
+	c.Coerce()
+	assert.Equal(t, data, c.Data())
+	assert.Nil(t, c.Interpolate())
+
+	var out struct {
+		Timeout time.Duration
+		Start   time.Time
+		Retries []interface{}
+		URL     string
+	}
+
+	assert.Nil(t, c.Unmarshal(&out))
+	assert.Equal(t, 30*time.Second, out.Timeout)
+	assert.True(t, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Equal(out.Start))
+	assert.Equal(t, []interface{}{float64(time.Second), float64(2 * time.Second), "later"}, out.Retries)
+	assert.Equal(t, "http://app/30s", out.URL)
+	assert.Equal(t, "30s", c.Data()["timeout"])
+
+	var timeout time.Duration
+
+	assert.Nil(t, c.UnmarshalPointer(&timeout, "/timeout"))
+	assert.Equal(t, 30*time.Second, timeout)
+
+	c, err = FromData([]byte(`{"size": "10MB", "timeout": "30s"}`))
+	assert.Nil(t, err)
+
+	size := func(s string) (interface{}, bool) {
+		if s == "10MB" {
+			return 10 << 20, true
+		}
+
+		return nil, false
+	}
+
+	var sized struct {
+		Size    int
+		Timeout string
+	}
+
+	c.Coerce(size)
+	assert.Nil(t, c.Unmarshal(&sized))
+	assert.Equal(t, 10<<20, sized.Size)
+	assert.Equal(t, "30s", sized.Timeout)
+}
+
+func TestConflate_CoerceValidate(t *testing.T) {
+	c, err := FromData([]byte(`{"timeout": "30s"}`))
+	assert.Nil(t, err)
+
+	s, err := NewSchemaData([]byte(`{"properties": {"timeout": {"type": "string"}}}`))
+	assert.Nil(t, err)
+
+	c.Coerce()
+	assert.Nil(t, c.Validate(s))
+}
+
+func TestConflate_CoerceAddData(t *testing.T) {
+	c, err := FromData([]byte(`{"timeout": "30s"}`))
+	assert.Nil(t, err)
+
+	c.Coerce()
+	assert.Nil(t, c.AddData([]byte(`{"timeout": "45s"}`)))
+
+	var out struct {
+		Timeout time.Duration
+	}
+
+	assert.Nil(t, c.Unmarshal(&out))
+	assert.Equal(t, 45*time.Second, out.Timeout)
+}
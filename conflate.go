@@ -1,27 +1,74 @@
 package conflate
 
 import (
+	gocontext "context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"net/url"
+	"sort"
+	"sync"
+
+	"google.golang.org/api/option"
 )
 
 // Includes is used to specify the top level key that holds the includes array.
+// A blank key turns off includes, unless a key is set on the Conflate instance with IncludesKey.
 var Includes = "includes"
 
+var errFrozen = errors.New("the data is frozen")
+
 // Conflate contains a 'working' merged data set and optionally a JSON v4 schema.
+// Its methods are safe for concurrent use, except for those setting options, which should be set before
+// the instance is shared. Freeze stops the data changing once loading is complete.
 type Conflate struct {
+	mu     sync.RWMutex
+	frozen bool
 	data   interface{}
-	loader loader
+	// base is the data of the first document merged, which Patch compares the data to
+	base       interface{}
+	loader     loader
+	mergeOpts  MergeOptions
+	provenance map[string]*url.URL
+	positions  map[string]Position
+	jsonOpts   JSONOptions
+	yamlOpts   YAMLOptions
+	fileOpts   FileOptions
+	schema     *Schema
+	// schemaKey is the top level key of a schema embedded in the data, which is moved to embeddedSchema
+	schemaKey        string
+	schemaPrecedence SchemaPrecedence
+	embeddedSchema   interface{}
+	// embeddedCompiled is the schema created from the embedded schema with the JSON content embeddedJSON, which keeps
+	// the schemas it compiles between calls
+	embeddedCompiled *Schema
+	embeddedJSON     string
+	// coercers convert the string values of the data as it is unmarshalled, see Coerce
+	coercers []Coercer
+	ctx      gocontext.Context
+	// ignoreGoIncludes merges the includes of golang objects as data rather than loading them
+	ignoreGoIncludes bool
 }
 
-// New constructs a new empty Conflate instance.
-func New() *Conflate {
+// New constructs a new empty Conflate instance, configured by the given options.
+func New(opts ...Option) *Conflate {
 	initFormatCheckers()
 
-	return &Conflate{
+	c := &Conflate{
 		loader: loader{
 			newFiledata: newFiledata,
 		},
+		jsonOpts: defaultJSONOptions,
+		ctx:      gocontext.Background(),
+	}
+
+	for _, opt := range opts {
+		opt(c)
 	}
+
+	return c
 }
 
 // FromFiles constructs a new Conflate instance populated with the data from the given files.
@@ -36,11 +83,29 @@ func FromFiles(paths ...string) (*Conflate, error) {
 	return c, nil
 }
 
+// FromDir constructs a new Conflate instance populated with the data from the files in the directory, see AddDir.
+func FromDir(dir string) (*Conflate, error) {
+	c := New()
+
+	err := c.AddDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
 // FromURLs constructs a new Conflate instance populated with the data from the given URLs.
 func FromURLs(urls ...*url.URL) (*Conflate, error) {
+	return FromURLsContext(gocontext.Background(), urls...)
+}
+
+// FromURLsContext constructs a new Conflate instance populated with the data from the given URLs.
+// Remote fetches are aborted when the context is done.
+func FromURLsContext(ctx gocontext.Context, urls ...*url.URL) (*Conflate, error) {
 	c := New()
 
-	err := c.AddURLs(urls...)
+	err := c.AddURLsContext(ctx, urls...)
 	if err != nil {
 		return nil, err
 	}
@@ -81,9 +146,436 @@ func (c *Conflate) Expand(expand bool) {
 	}
 }
 
+// TransportOptions sets the timeouts and connection limits used when loading remote urls.
+func (c *Conflate) TransportOptions(opts TransportOptions) {
+	c.loader.transport = opts
+}
+
+// HTTPClient sets the client used when loading remote urls, in place of the one built from the transport options.
+func (c *Conflate) HTTPClient(client *http.Client) {
+	c.loader.httpClient = client
+}
+
+// GCSOptions sets the options used to create the client that loads gs urls, e.g. option.WithCredentialsFile,
+// or option.WithEndpoint to use an emulator. The ambient credentials are used when no options are given.
+func (c *Conflate) GCSOptions(opts ...option.ClientOption) {
+	c.loader.gcsOptions = opts
+}
+
+// RetryPolicy sets how remote urls are retried after transient failures, by default a single attempt is made.
+func (c *Conflate) RetryPolicy(policy RetryPolicy) {
+	c.loader.retry = policy
+}
+
+// Headers sets headers that are sent with every request for an http or https url.
+func (c *Conflate) Headers(headers http.Header) {
+	c.loader.headers = headers
+}
+
+// HostHeaders sets headers that are only sent with requests to the given host, including any port.
+// They override any headers of the same name set with Headers.
+func (c *Conflate) HostHeaders(host string, headers http.Header) {
+	if c.loader.hostHeaders == nil {
+		c.loader.hostHeaders = map[string]http.Header{}
+	}
+
+	c.loader.hostHeaders[host] = headers
+}
+
+// MaxBytes limits the size of data read from a remote url, zero means unlimited.
+func (c *Conflate) MaxBytes(n int64) {
+	c.loader.maxBytes = n
+}
+
+// Cache enables fetching each url only once while loading, e.g. when several files include the same file.
+// The cache only lasts for a single call, so later calls see any changes to the data.
+func (c *Conflate) Cache(enabled bool) {
+	c.loader.useCache = enabled
+}
+
+// MaxRedirects sets the number of redirects followed when loading a remote url, by default 10.
+// It has no effect when a custom http client is set.
+func (c *Conflate) MaxRedirects(n int) {
+	c.loader.maxRedirects = n
+}
+
+// IncludesKey sets the top level key that holds the includes array, e.g. "$include", so that data can use the
+// "includes" key for its own values. A blank key uses the Includes variable, which is "includes" by default.
+func (c *Conflate) IncludesKey(key string) {
+	c.loader.includes = key
+}
+
+// DisableIncludes is an option to merge the includes key of every document as ordinary data, rather than loading
+// the includes it lists, whatever the key is. Includes are loaded by default.
+func (c *Conflate) DisableIncludes(disable bool) {
+	c.loader.disableIncludes = disable
+}
+
+// IncludesOverride is an option to merge the data of each document before the data of its includes, so that values
+// in an included file override those in the file that includes it, rather than the other way round. The includes
+// are still merged in the order of the includes list, so a later include overrides an earlier one.
+func (c *Conflate) IncludesOverride(override bool) {
+	c.loader.includesOverride = override
+}
+
+// FileRoot restricts the files loaded from the local file system to those within the directory, including files
+// and includes added by absolute path or url, so that an include such as ../../etc/passwd cannot escape it.
+// Symbolic links are resolved before checking, so a link within the root cannot point outside it.
+// It does not apply to a file system set with FS.
+func (c *Conflate) FileRoot(dir string) {
+	c.loader.fileRoot = dir
+}
+
+// AllowSchemes restricts loading to urls with the given schemes, e.g. "https", "file" for file paths or "stdin" for
+// standard input, including the urls of includes and schemas, and any redirects. Any scheme can be loaded when none are given.
+func (c *Conflate) AllowSchemes(schemes ...string) {
+	c.loader.schemes = schemes
+}
+
+// BaseURL sets the url that relative includes are resolved against in data that is not loaded from a url, e.g. by
+// AddData or AddGo, rather than the working directory, so that data received over the wire can include files
+// relative to where it logically came from. As for any url, it should end in '/' to resolve includes within
+// a directory, e.g. https://host/config/ or file:///etc/app/.
+func (c *Conflate) BaseURL(base *url.URL) {
+	c.loader.baseURL = base
+}
+
+// IncludeRoot restricts includes to urls within the root, e.g. file:///etc/app/ or https://host/config/,
+// so that relative includes using '..' cannot escape it. The urls of the data added directly are not restricted.
+func (c *Conflate) IncludeRoot(root *url.URL) {
+	c.loader.includeRoot = root
+}
+
+// MaxIncludeDepth limits how deeply includes can be nested, by default 64, so that a long chain of includes
+// in untrusted data fails with an error rather than exhausting resources.
+func (c *Conflate) MaxIncludeDepth(n int) {
+	c.loader.maxDepth = n
+}
+
+// GoIncludes is an option to load the includes in golang objects added with AddGo, it is on by default.
+// Turn it off to merge the includes key of the objects as ordinary data.
+func (c *Conflate) GoIncludes(load bool) {
+	c.ignoreGoIncludes = !load
+}
+
+// ExpandIncludes is an option to expand environment variables in file paths and includes, e.g.
+// "${CONFIG_ROOT}/base.json", it is off by default. The includes of data loaded from a url that is not a file are
+// never expanded, so that remote data cannot send the values of environment variables elsewhere.
+func (c *Conflate) ExpandIncludes(expand bool) {
+	c.loader.expandPaths = expand
+}
+
+// ExpandValues is an option to expand environment variables in the string values of the data once it is loaded,
+// before it is merged, e.g. to keep secrets out of files. $VAR and ${VAR} are replaced, and $$ is a literal '$'.
+// ${VAR:-default} uses the default when the variable is unset or empty, and ${VAR:?message} fails with the message.
+// Unlike Expand, object keys and the structure of the data are never changed.
+func (c *Conflate) ExpandValues(expand bool) {
+	c.loader.expandValues = expand
+}
+
+// ExpandLookup sets the function used to look up variables when expanding paths and values, by default
+// os.LookupEnv. A variable is undefined when the function returns false.
+func (c *Conflate) ExpandLookup(lookup func(name string) (string, bool)) {
+	c.loader.lookup = lookup
+}
+
+// StrictExpand is an option to make references to undefined environment variables an error when expanding
+// paths and values, rather than replacing them with an empty string.
+func (c *Conflate) StrictExpand(strict bool) {
+	c.loader.strictExpand = strict
+}
+
+// MergeOptions sets how the data from each source is merged together.
+func (c *Conflate) MergeOptions(opts MergeOptions) {
+	c.mergeOpts = opts
+}
+
+// JSONOptions sets how the data is formatted by MarshalJSON and WriteJSON.
+// By default it is indented by two spaces, without HTML escaping.
+func (c *Conflate) JSONOptions(opts JSONOptions) {
+	c.jsonOpts = opts
+}
+
+// YAMLOptions sets how the data is formatted by MarshalYAML and WriteYAML.
+// By default objects and arrays are written in block style, indented by two spaces.
+func (c *Conflate) YAMLOptions(opts YAMLOptions) {
+	c.yamlOpts = opts
+}
+
+// FileOptions sets the permissions of the files written by WriteFile, and whether it creates their directories.
+func (c *Conflate) FileOptions(opts FileOptions) {
+	c.fileOpts = opts
+}
+
+// TrackProvenance is an option to record the source of each merged value, which can be retrieved with Provenance,
+// or with its position in the source with Positions.
+// It applies to data added after it is turned on.
+func (c *Conflate) TrackProvenance(track bool) {
+	if !track {
+		c.provenance = nil
+		c.positions = nil
+	} else if c.provenance == nil {
+		c.provenance = map[string]*url.URL{}
+		c.positions = map[string]Position{}
+	}
+}
+
+// Provenance returns the url of the source that last set each value, keyed by JSON pointer.
+// Arrays are reported as a whole, and the url is nil for data not loaded from a url.
+// It is nil unless TrackProvenance is on.
+func (c *Conflate) Provenance() map[string]*url.URL {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return copyProvenance(c.provenance)
+}
+
+// Positions returns the source of each value as for Provenance, along with the line and column of the value within
+// the source when it is JSON or YAML, so that an editor can go to where a value was set.
+// It is nil unless TrackProvenance is on.
+func (c *Conflate) Positions() map[string]Position {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return copyPositions(c.positions)
+}
+
+// DecodeUTF16 is an option to decode data that starts with a UTF-16 byte order mark, little or big endian, as is
+// common for files saved on Windows, rather than failing to parse it. A UTF-8 byte order mark is always ignored.
+func (c *Conflate) DecodeUTF16(decode bool) {
+	c.loader.decodeUTF16 = decode
+}
+
+// FailDuplicateKeys is an option to make loading fail when a key appears more than once in the same object of
+// a single JSON, JSONC or YAML document, rather than the last value being used, naming the key and its line.
+// Keys in different files or documents are merged as usual.
+func (c *Conflate) FailDuplicateKeys(fail bool) {
+	c.loader.failDuplicateKeys = fail
+}
+
+// CheckIncludes is an option to add warnings, see Result, for top level keys that look like a misspelling of
+// the includes key, e.g. "inclde", which are otherwise silently merged as data, and for includes that match no
+// files or add no data. Includes whose values are all replaced by the data merged after them in the same call,
+// e.g. by the file that includes them, are also reported, as they could be removed.
+func (c *Conflate) CheckIncludes(check bool) {
+	c.loader.checkIncludes = check
+}
+
+// FailUnknownFiles is an option to make AddDir fail when the directory has files with an extension that is not of
+// a known format, rather than skipping them.
+func (c *Conflate) FailUnknownFiles(fail bool) {
+	c.loader.failUnknownFiles = fail
+}
+
+// FS sets a file system, e.g. an embed.FS, to read file paths and urls from in place of the operating system's.
+// Relative paths are then relative to the root of the file system rather than the working directory.
+func (c *Conflate) FS(fsys fs.FS) {
+	c.loader.fsys = fsys
+}
+
+// Observer sets an observer to be notified as each url is fetched and as the data is merged, e.g. to record metrics
+// or tracing spans. There is no overhead when none is set.
+func (c *Conflate) Observer(o Observer) {
+	c.loader.observer = o
+}
+
+// OnLoad sets a function that is called as each file or url is loaded, including includes, with the data loaded
+// or the error loading it. Returning an error rejects the source and stops loading, e.g. to only allow known hosts.
+func (c *Conflate) OnLoad(hook func(url *url.URL, data []byte, err error) error) {
+	c.loader.onLoad = hook
+}
+
+// Sources returns the url of every file and url loaded so far, including those loaded as includes,
+// in the order they were loaded. Each url is only listed once.
+func (c *Conflate) Sources() []*url.URL {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]*url.URL(nil), c.loader.sources...)
+}
+
+// Clone returns a copy of the Conflate instance with its own copy of the merged data and the same options,
+// so that more data can be added to it without changing the original.
+// Cloning only reads the original, so many clones can be made concurrently from a base that is no longer changed.
+func (c *Conflate) Clone() *Conflate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return &Conflate{
+		data:             copyValue(c.data, true),
+		base:             copyValue(c.base, true),
+		loader:           c.loader.clone(),
+		mergeOpts:        c.mergeOpts,
+		provenance:       copyProvenance(c.provenance),
+		positions:        copyPositions(c.positions),
+		jsonOpts:         c.jsonOpts,
+		yamlOpts:         c.yamlOpts,
+		fileOpts:         c.fileOpts,
+		schema:           c.schema,
+		schemaKey:        c.schemaKey,
+		schemaPrecedence: c.schemaPrecedence,
+		embeddedSchema:   copyValue(c.embeddedSchema, true),
+		embeddedCompiled: c.embeddedCompiled,
+		embeddedJSON:     c.embeddedJSON,
+		coercers:         c.coercers,
+		ctx:              c.ctx,
+		ignoreGoIncludes: c.ignoreGoIncludes,
+	}
+}
+
+func copyPositions(positions map[string]Position) map[string]Position {
+	if positions == nil {
+		return nil
+	}
+
+	cp := make(map[string]Position, len(positions))
+	for pointer, pos := range positions {
+		cp[pointer] = pos
+	}
+
+	return cp
+}
+
+func copyProvenance(provenance map[string]*url.URL) map[string]*url.URL {
+	if provenance == nil {
+		return nil
+	}
+
+	cp := make(map[string]*url.URL, len(provenance))
+	for pointer, source := range provenance {
+		cp[pointer] = source
+	}
+
+	return cp
+}
+
+// Freeze stops any more data being added or merged into the instance, or defaults being applied, which then fail
+// with an error. The data can still be read, and Clone returns a copy that is not frozen.
+func (c *Conflate) Freeze() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.frozen = true
+}
+
+// Reset removes the data, along with the sources, provenance and warnings recorded as it was added, so that
+// the instance can be reused as if it had just been constructed with the same options. A frozen instance is no longer
+// frozen once it is reset.
+func (c *Conflate) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.data = nil
+	c.base = nil
+	c.embeddedSchema = nil
+	c.frozen = false
+
+	if c.provenance != nil {
+		c.provenance = map[string]*url.URL{}
+		c.positions = map[string]Position{}
+	}
+
+	c.loader.reset()
+}
+
+// lockUnfrozen takes the lock to change the data, unless the data is frozen.
+func (c *Conflate) lockUnfrozen() error {
+	c.mu.Lock()
+
+	if c.frozen {
+		c.mu.Unlock()
+
+		return errFrozen
+	}
+
+	return nil
+}
+
+// Merge merges the data of the other instances into this one in turn, as if their data had been added to it,
+// without loading anything again. The other instances are only read and do not share any data with this one
+// afterwards. The provenance of the merged values is taken from the other instances when they track it.
+// Two instances must not be merged into each other concurrently.
+func (c *Conflate) Merge(others ...*Conflate) error {
+	if err := c.lockUnfrozen(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	for _, other := range others {
+		if err := c.mergeFrom(other); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Conflate) mergeFrom(other *Conflate) error {
+	if other != c {
+		other.mu.RLock()
+		defer other.mu.RUnlock()
+	}
+
+	m := merger{opts: c.mergeOpts, provenance: c.provenance, positions: c.positions}
+
+	if c.data == nil && c.base == nil {
+		c.base = copyValue(other.base, true)
+	}
+
+	err := m.merge(&c.data, other.data)
+	if err != nil {
+		return err
+	}
+
+	err = merge(&c.embeddedSchema, other.embeddedSchema)
+	if err != nil {
+		return fmt.Errorf("the embedded schema could not be merged: %w", err)
+	}
+
+	err = c.extractSchema()
+	if err != nil {
+		return err
+	}
+
+	if c.provenance != nil {
+		for pointer, source := range other.provenance {
+			c.provenance[pointer] = source
+		}
+
+		for pointer, pos := range other.positions {
+			c.positions[pointer] = pos
+		}
+	}
+
+	for _, source := range other.loader.sources {
+		if !containsURL(source, c.loader.sources) {
+			c.loader.sources = append(c.loader.sources, source)
+		}
+	}
+
+	for _, w := range other.loader.warnings {
+		c.loader.warn(w.Code, w.Source, "%v", w.Message)
+	}
+
+	return nil
+}
+
 // AddFiles recursively merges the data from the given files into the Conflate instance.
 func (c *Conflate) AddFiles(paths ...string) error {
-	urls, err := toURLs(nil, paths...)
+	urls, err := c.loader.toURLs(nil, paths...)
+	if err != nil {
+		return err
+	}
+
+	return c.AddURLs(urls...)
+}
+
+// AddDir recursively merges the data from each file in the directory and its subdirectories that has the extension
+// of a known format. The files in a directory are merged in lexical order of their names, and the files in
+// a subdirectory are merged in the place of its name, e.g. 10-base.json, 20-app/a.json, 20-app/b.json, 30-local.json.
+// Files with other extensions are skipped, unless FailUnknownFiles is on.
+func (c *Conflate) AddDir(dir string) error {
+	urls, err := c.loader.dirURLs(dir)
 	if err != nil {
 		return err
 	}
@@ -93,22 +585,51 @@ func (c *Conflate) AddFiles(paths ...string) error {
 
 // AddURLs recursively merges the data from the given urls into the Conflate instance.
 func (c *Conflate) AddURLs(urls ...*url.URL) error {
-	data, err := c.loader.loadURLsRecursive(nil, urls...)
+	return c.AddURLsContext(c.ctx, urls...)
+}
+
+// AddURLsContext recursively merges the data from the given urls into the Conflate instance.
+// Remote fetches are aborted when the context is done.
+func (c *Conflate) AddURLsContext(ctx gocontext.Context, urls ...*url.URL) error {
+	if err := c.lockUnfrozen(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+	defer c.loader.close()
+
+	data, err := c.loader.loadURLsRecursive(ctx, nil, urls...)
 	if err != nil {
 		return err
 	}
 
-	return c.mergeData(data...)
+	return c.observeMerge(ctx, data...)
 }
 
 // AddGo recursively merges the given (json-serializable) golang objects into the Conflate instance.
+// Any includes in the objects are loaded, unless GoIncludes is turned off.
 func (c *Conflate) AddGo(objs ...interface{}) error {
 	data, err := jsonMarshalAll(objs...)
 	if err != nil {
 		return err
 	}
 
-	return c.AddData(data...)
+	if !c.ignoreGoIncludes {
+		return c.AddData(data...)
+	}
+
+	fdata := make(filedatas, 0, len(data))
+
+	for _, b := range data {
+		// a blank includes key leaves the includes in the data
+		fd, err := c.loader.newFiledata(b, &emptyURL, "", "")
+		if err != nil {
+			return err
+		}
+
+		fdata = append(fdata, fd)
+	}
+
+	return c.addData(fdata...)
 }
 
 // AddData recursively merges the given data into the Conflate instance.
@@ -121,47 +642,392 @@ func (c *Conflate) AddData(data ...[]byte) error {
 	return c.addData(fdata...)
 }
 
+// MergePreview reports the changes that adding the given data would make, without changing the Conflate instance.
+// The changes are grouped by source in the order the sources are merged, and sorted by pointer within each source.
+func (c *Conflate) MergePreview(data ...[]byte) ([]MergeChange, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// loading changes the state of the loader, so the includes are loaded by a copy that is then discarded, and the
+	// hooks are not called for sources that are not added
+	l := c.loader.clone()
+	l.observer = nil
+	l.onLoad = nil
+
+	defer l.close()
+
+	fdata, err := l.wrapFiledatas(data...)
+	if err != nil {
+		return nil, err
+	}
+
+	fdata, err = l.loadDataRecursive(c.ctx, nil, fdata...)
+	if err != nil {
+		return nil, err
+	}
+
+	var preview interface{}
+	if c.data != nil {
+		err = jsonMarshalUnmarshal(c.data, &preview)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes := []MergeChange{}
+
+	for _, fd := range fdata {
+		m := merger{opts: c.mergeOpts, source: fd.source(), changes: &changes}
+		start := len(changes)
+
+		err = m.merge(&preview, fd.value())
+		if err != nil {
+			return nil, err
+		}
+
+		added := changes[start:]
+		sort.SliceStable(added, func(i, j int) bool { return added[i].Pointer < added[j].Pointer })
+	}
+
+	return changes, nil
+}
+
 // ApplyDefaults sets any nil or missing values in the data, to the default values defined in the JSON v4 schema.
+// The schema set by WithSchema is used when s is nil, or the embedded schema, see EmbeddedSchema.
 func (c *Conflate) ApplyDefaults(s *Schema) error {
+	if err := c.lockUnfrozen(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	s, err := c.schemaOr(s)
+	if err != nil {
+		return err
+	}
+
 	return s.ApplyDefaults(&c.data)
 }
 
+// Interpolate replaces references to other values of the merged data within its string values, e.g. the value
+// "postgres://${db/host}:5432" with "postgres://db:5432" where the data has {"db": {"host": "db"}}. A reference
+// is a JSON pointer, with or without the leading '/', and a value that is only a reference is replaced by the value
+// referenced, keeping its type. $${ is a literal ${, and references to themselves, or to values that are not in
+// the data, are errors, which leave the data unchanged. As ExpandValues expands ${VAR} before merging, references
+// are written as $${pointer} when both are used.
+func (c *Conflate) Interpolate() error {
+	if err := c.lockUnfrozen(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+
+	data := copyValue(c.data, true)
+
+	err := interpolate(data)
+	if err != nil {
+		return fmt.Errorf("the data could not be interpolated: %w", err)
+	}
+
+	c.data = data
+
+	return nil
+}
+
+// Coerce sets coercers that Unmarshal and UnmarshalPointer use to convert the string values of the data they
+// accept, trying them in order, so that e.g. "30s" is decoded as a time.Duration rather than a string.
+// CoerceDuration and CoerceTime are used when no coercers are given. The merged data itself keeps its strings, so
+// that it is still validated, merged and exported as it was loaded.
+func (c *Conflate) Coerce(coercers ...Coercer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(coercers) == 0 {
+		coercers = []Coercer{CoerceDuration, CoerceTime}
+	}
+
+	c.coercers = coercers
+}
+
 // Validate checks the data against the JSON v4 schema.
+// The schema set by WithSchema is used when s is nil, or the embedded schema, see EmbeddedSchema.
 func (c *Conflate) Validate(s *Schema) error {
+	return c.ValidateDraft(s, DraftAuto)
+}
+
+// ValidateDraft checks the data against the schema, interpreting the schema as the given draft, and adds a warning,
+// see Result, when the schema declares another draft.
+// The schema set by WithSchema is used when s is nil, or the embedded schema, see EmbeddedSchema.
+func (c *Conflate) ValidateDraft(s *Schema, draft SchemaDraft) error {
+	// the lock is also needed to add any warnings about the schema
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s, err := c.schemaOr(s)
+	if err != nil {
+		return err
+	}
+
+	c.loader.warnSchemaFormats(s)
+
+	if declared, ok := s.mismatchedDraft(draft); ok {
+		c.loader.warn(WarnSchemaDraftMismatch, nil, "the schema declared as %v is validated as %v", declared, draft)
+	}
+
+	return s.ValidateDraft(c.data, draft)
+}
+
+// ValidateWithSchemaURL checks the data against the JSON v4 schema at the given path or url, unless the embedded
+// schema takes precedence over it, see EmbeddedSchema.
+// The schema, and any documents it references relative to its own url, are loaded in the same way as the data.
+func (c *Conflate) ValidateWithSchemaURL(schemaURL string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.loader.close()
+
+	urls, err := c.loader.toURLs(nil, schemaURL)
+	if err != nil {
+		return fmt.Errorf("failed to obtain url to schema: %w", err)
+	}
+
+	if len(urls) == 0 {
+		return fmt.Errorf("%w : no file matches %v", errNotSetSchema, schemaURL)
+	}
+
+	s, err := c.loader.loadSchema(c.ctx, urls[0])
+	if err != nil {
+		return err
+	}
+
+	s, err = c.schemaOr(s)
+	if err != nil {
+		return err
+	}
+
+	c.loader.warnSchemaFormats(s)
+
 	return s.Validate(c.data)
 }
 
+// Data returns a copy of the current merged data, including any defaults that have been applied,
+// so it can be inspected without changing the Conflate instance. It is nil when no data has been added, or when
+// the data is a top level array, which can be retrieved with Unmarshal or GetPointer("") instead.
+func (c *Conflate) Data() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, _ := copyValue(c.data, true).(map[string]interface{})
+
+	return data
+}
+
+// GetPointer returns a copy of the value at the RFC 6901 JSON pointer in the merged data, e.g. "/db/pool/size" or
+// "/hosts/0/name", and whether there is a value there. The blank pointer refers to the whole document.
+func (c *Conflate) GetPointer(pointer string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	val, ok := lookupPointer(c.data, pointer)
+	if !ok {
+		return nil, false
+	}
+
+	return copyValue(val, true), true
+}
+
+// Result returns a copy of the current merged data, as for Data, along with the warnings about problems found so far
+// when loading and validating the data that did not stop it, e.g. optional includes that were not found.
+func (c *Conflate) Result() Result {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	data, _ := copyValue(c.data, true).(map[string]interface{})
+
+	return Result{Data: data, Warnings: append([]Warning(nil), c.loader.warnings...)}
+}
+
 // Unmarshal extracts the data as a Golang object.
+// Only the properties present in the merged data are set, so the existing values of any other fields are kept,
+// while a property explicitly set to a zero value in a source overwrites the field.
 func (c *Conflate) Unmarshal(out interface{}) error {
-	return jsonMarshalUnmarshal(c.data, out)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return jsonMarshalUnmarshal(c.coerced(c.data), out)
+}
+
+// UnmarshalPointer extracts the value at the RFC 6901 JSON pointer in the merged data as a Golang object, e.g. only
+// the "/db" section into the Database field of a larger config. It is as for Unmarshal, except that a pointer with
+// no value is not an error, and is decoded as null, so that out keeps its zero value.
+func (c *Conflate) UnmarshalPointer(out interface{}, pointer string) error {
+	if !validPointer(pointer) {
+		return fmt.Errorf("%w : %q", errInvalidPointer, pointer)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	val, _ := lookupPointer(c.data, pointer)
+
+	return jsonMarshalUnmarshal(c.coerced(val), out)
+}
+
+// coerced returns a copy of the value with the coercers set by Coerce applied, or the value itself without them.
+func (c *Conflate) coerced(val interface{}) interface{} {
+	if len(c.coercers) == 0 {
+		return val
+	}
+
+	return coerce(copyValue(val, true), c.coercers)
 }
 
 // MarshalJSON exports the data as JSON.
+// Object keys are always written in sorted order, at every level, so the output is stable between runs.
 func (c *Conflate) MarshalJSON() ([]byte, error) {
-	return jsonMarshal(c.data)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return jsonMarshalWith(c.jsonOpts, c.data)
 }
 
-// MarshalYAML exports the data as YAML.
+// MarshalYAML exports the data as YAML, with object keys sorted as for MarshalJSON.
 func (c *Conflate) MarshalYAML() ([]byte, error) {
-	return yamlMarshal(c.data)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return yamlMarshalWith(c.yamlOpts, c.data)
 }
 
-// MarshalTOML exports the data as TOML.
+// MarshalTOML exports the data as TOML, with keys sorted within each table.
 func (c *Conflate) MarshalTOML() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	return tomlMarshal(c.data)
 }
 
+// WriteJSON encodes the data as JSON to the writer in the same format as MarshalJSON. The objects and arrays of the
+// data are written value by value through a small buffer, rather than marshalling the whole output first.
+func (c *Conflate) WriteJSON(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return jsonWrite(w, c.jsonOpts, c.data)
+}
+
+// WriteYAML writes the data as YAML to the writer.
+// The YAML encoder works on the whole document, so unlike WriteJSON the output is buffered before it is written.
+func (c *Conflate) WriteYAML(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return yamlWrite(w, c.yamlOpts, c.data)
+}
+
+// WriteTOML encodes the data as TOML directly to the writer.
+func (c *Conflate) WriteTOML(w io.Writer) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return tomlWrite(w, c.data)
+}
+
+// WriteFile writes the data to the file at the path in the format, or in the format given by the extension of the path
+// for FormatAuto. The data is written to a temporary file which then replaces the file at the path, so the file
+// is never left partly written.
+func (c *Conflate) WriteFile(path string, format Format) error {
+	format, err := formatOf(path, format)
+	if err != nil {
+		return err
+	}
+
+	data, err := c.marshal(format)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(path, data, c.fileOpts)
+	if err != nil {
+		return fmt.Errorf("could not write file %v: %w", path, err)
+	}
+
+	return nil
+}
+
 func (c *Conflate) addData(fdata ...filedata) error {
-	fdata, err := c.loader.loadDataRecursive(nil, fdata...)
+	if err := c.lockUnfrozen(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+	defer c.loader.close()
+
+	fdata, err := c.loader.loadDataRecursive(c.ctx, nil, fdata...)
 	if err != nil {
 		return err
 	}
 
-	return c.mergeData(fdata...)
+	return c.observeMerge(c.ctx, fdata...)
 }
 
 func (c *Conflate) mergeData(fdata ...filedata) error {
-	doms := filedatas(fdata).objs()
+	if c.data == nil && c.base == nil && len(fdata) > 0 {
+		c.base = merger{opts: c.mergeOpts}.newValue(fdata[0].value())
+	}
+
+	// the provenance of the values is needed to find the includes that were overridden, even when it is not kept
+	provenance := c.provenance
+	if provenance == nil && c.loader.checkIncludes {
+		provenance = map[string]*url.URL{}
+	}
+
+	if provenance == nil {
+		doms := filedatas(fdata).objs()
+
+		err := merger{opts: c.mergeOpts}.mergeTo(&c.data, doms...)
+		if err != nil {
+			return err
+		}
+
+		return c.extractSchema()
+	}
+
+	for _, fd := range fdata {
+		m := merger{
+			opts:       c.mergeOpts,
+			source:     fd.source(),
+			provenance: provenance,
+			positions:  c.positions,
+		}
+
+		if c.positions != nil {
+			m.sourcePositions = fd.positions()
+		}
 
-	return mergeTo(&c.data, doms...)
+		err := m.merge(&c.data, fd.value())
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.loader.checkIncludes {
+		c.warnOverridden(fdata, provenance)
+	}
+
+	return c.extractSchema()
+}
+
+// warnOverridden adds a warning for each include with data that is not the source of any value in the provenance.
+func (c *Conflate) warnOverridden(fdata filedatas, provenance map[string]*url.URL) {
+	sources := map[string]bool{}
+
+	for _, source := range provenance {
+		if source != nil {
+			sources[source.String()] = true
+		}
+	}
+
+	for i := range fdata {
+		source := fdata[i].source()
+		if fdata[i].included && source != nil && !noData(fdata[i:i+1]) && !sources[source.String()] {
+			c.loader.warn(WarnOverriddenInclude, source, "the values of the include are all overridden")
+		}
+	}
 }
@@ -1,14 +1,22 @@
 package conflate
 
 import (
+	"bytes"
 	gocontext "context"
+	"fmt"
+	"io/fs"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
 	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
 )
 
 type TestData struct {
@@ -40,6 +48,654 @@ func TestFromFiles(t *testing.T) {
 	assert.Equal(t, "parent", testData.All)
 }
 
+func TestFromFiles_Stdin(t *testing.T) {
+	testStdin(t, `{"x": 1}`)
+
+	c, err := FromFiles("testdata/valid_child.json", "-")
+	assert.Nil(t, err)
+
+	var out map[string]interface{}
+
+	err = c.Unmarshal(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, 1.0, out["x"])
+	assert.Equal(t, "child", out["child_only"])
+}
+
+func TestAddFiles_ExpandIncludes(t *testing.T) {
+	t.Setenv("TESTDATA", "testdata")
+
+	c := New()
+	c.StrictExpand(true)
+
+	err := c.AddFiles("$TESTDATA/valid_sibling.json")
+	assert.NotNil(t, err)
+
+	c.ExpandIncludes(true)
+
+	err = c.AddData([]byte(`{"includes": ["${TESTDATA}/valid_child.json"]}`))
+	assert.Nil(t, err)
+
+	err = c.AddFiles("$TESTDATA/valid_sibling.json")
+	assert.Nil(t, err)
+}
+
+func TestAddFiles_ExpandIncludesRemote(t *testing.T) {
+	t.Setenv("CONFLATE_TEST_SECRET", "secret")
+
+	var paths []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+
+		if r.URL.Path == "/base.json" {
+			_, _ = w.Write([]byte(`{"includes": ["child-${CONFLATE_TEST_SECRET}.json"]}`))
+		} else {
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+
+	defer server.Close()
+
+	c := New()
+	c.ExpandIncludes(true)
+
+	err := c.AddFiles(server.URL + "/base.json")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"/base.json", "/child-$%7BCONFLATE_TEST_SECRET%7D.json"}, paths)
+}
+
+func TestConflate_ExpandValues(t *testing.T) {
+	t.Setenv("CONFLATE_TEST_SECRET", "secret")
+
+	c := New()
+
+	err := c.AddData([]byte(`{"password": "$CONFLATE_TEST_SECRET"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "$CONFLATE_TEST_SECRET", c.Data()["password"])
+
+	c.ExpandValues(true)
+
+	err = c.AddData([]byte(`{"password": "$CONFLATE_TEST_SECRET", "includes": ["testdata/valid_child.json"]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", c.Data()["password"])
+
+	c.ExpandLookup(func(name string) (string, bool) { return "", false })
+	c.StrictExpand(true)
+
+	err = c.AddData([]byte(`{"password": "$CONFLATE_TEST_SECRET"}`))
+	assert.ErrorIs(t, err, errUndefinedVar)
+}
+
+func TestConflate_ConditionalIncludes(t *testing.T) {
+	data := []byte(`{"includes": [{"url": "testdata/valid_child.json", "when": "ENV == prod"}], "x": 1}`)
+
+	c := New()
+	c.ExpandLookup(func(name string) (string, bool) { return "dev", true })
+
+	err := c.AddData(data)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 1.0}, c.Data())
+
+	c.ExpandLookup(func(name string) (string, bool) { return "prod", true })
+
+	err = c.AddData(data)
+	assert.Nil(t, err)
+	assert.Equal(t, "child", c.Data()["child_only"])
+
+	err = c.AddData([]byte(`{"includes": [{"url": "testdata/valid_child.json", "if": "ENV == prod"}]}`))
+	assert.NotNil(t, err)
+}
+
+func TestConflate_Sources(t *testing.T) {
+	c := New()
+	assert.Empty(t, c.Sources())
+
+	err := c.AddFiles("testdata/valid_parent.json")
+	assert.Nil(t, err)
+
+	err = c.AddData([]byte(`{"includes": ["testdata/valid_child.json"]}`))
+	assert.Nil(t, err)
+
+	var names []string
+	for _, u := range c.Sources() {
+		names = append(names, path.Base(u.Path))
+	}
+
+	assert.Equal(t, []string{"valid_parent.json", "valid_child.json", "valid_sibling.json"}, names)
+
+	err = c.AddFiles("testdata/missing.json")
+	assert.NotNil(t, err)
+	assert.Len(t, c.Sources(), 3)
+
+	c.Sources()[0] = nil
+	assert.NotNil(t, c.Sources()[0])
+}
+
+func TestConflate_OnLoad(t *testing.T) {
+	var loaded []string
+
+	c := New()
+	c.OnLoad(func(u *url.URL, data []byte, err error) error {
+		loaded = append(loaded, path.Base(u.Path))
+
+		if path.Base(u.Path) == "valid_sibling.json" {
+			assert.Nil(t, err)
+			assert.Contains(t, string(data), "sibling")
+
+			return errTest
+		}
+
+		return nil
+	})
+
+	err := c.AddFiles("testdata/valid_parent.json")
+	assert.ErrorIs(t, err, errTest)
+	assert.Contains(t, err.Error(), "valid_sibling.json was rejected")
+	assert.Equal(t, []string{"valid_parent.json", "valid_child.json", "valid_sibling.json"}, loaded)
+	assert.Nil(t, c.Data())
+	assert.Len(t, c.Sources(), 2)
+
+	loaded = nil
+
+	err = c.AddFiles("testdata/missing.json")
+	assert.NotNil(t, err)
+	assert.NotErrorIs(t, err, errTest)
+	assert.Equal(t, []string{"missing.json"}, loaded)
+}
+
+func TestConflate_Clone(t *testing.T) {
+	base, err := FromData([]byte(`{"x": 1, "obj": {"a": [1, 2]}}`))
+	assert.Nil(t, err)
+
+	clone := base.Clone()
+
+	err = clone.AddData([]byte(`{"x": 2, "obj": {"b": "b"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 2.0, "obj": map[string]interface{}{"a": []interface{}{1.0, 2.0}, "b": "b"}}, clone.Data())
+	assert.Equal(t, map[string]interface{}{"x": 1.0, "obj": map[string]interface{}{"a": []interface{}{1.0, 2.0}}}, base.Data())
+
+	assert.Nil(t, New().Clone().Data())
+}
+
+func TestConflate_Merge(t *testing.T) {
+	other, err := FromData([]byte(`{"x": 2, "obj": {"b": "b"}}`))
+	assert.Nil(t, err)
+
+	c, err := FromData([]byte(`{"x": 1, "obj": {"a": "a"}}`))
+	assert.Nil(t, err)
+
+	err = c.Merge(other, New())
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 2.0, "obj": map[string]interface{}{"a": "a", "b": "b"}}, c.Data())
+
+	// the data of the other instance is copied, not shared
+	err = c.AddData([]byte(`{"obj": {"b": "c"}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 2.0, "obj": map[string]interface{}{"b": "b"}}, other.Data())
+
+	other, err = FromData([]byte(`{"x": "text"}`))
+	assert.Nil(t, err)
+
+	err = c.Merge(other)
+	assert.NotNil(t, err)
+}
+
+func TestConflate_MergeProvenance(t *testing.T) {
+	dir := t.TempDir()
+	file := path.Join(dir, "other.json")
+	assert.Nil(t, os.WriteFile(file, []byte(`{"x": 2}`), 0o600))
+
+	other := New()
+	other.TrackProvenance(true)
+	assert.Nil(t, other.AddFiles(file))
+
+	c := New()
+	c.TrackProvenance(true)
+	assert.Nil(t, c.AddData([]byte(`{"x": 1, "z": 1}`)))
+	assert.Nil(t, c.Merge(other))
+
+	assert.Equal(t, "other.json", path.Base(c.Provenance()["/x"].Path))
+	assert.Nil(t, c.Provenance()["/z"])
+	assert.Equal(t, other.Sources(), c.Sources())
+}
+
+func TestConflate_CloneConcurrently(t *testing.T) {
+	base, err := FromData([]byte(`{"x": 1, "obj": {"a": "a"}}`))
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			clone := base.Clone()
+			assert.Nil(t, clone.AddGo(map[string]interface{}{"x": i, "obj": map[string]interface{}{"b": i}}))
+			assert.Nil(t, New().Merge(base))
+		}(i)
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, map[string]interface{}{"x": 1.0, "obj": map[string]interface{}{"a": "a"}}, base.Data())
+}
+
+func TestConflate_Freeze(t *testing.T) {
+	c, err := FromData([]byte(`{"x": 1}`))
+	assert.Nil(t, err)
+
+	c.Freeze()
+
+	assert.ErrorIs(t, c.AddData([]byte(`{"x": 2}`)), errFrozen)
+	assert.ErrorIs(t, c.AddGo(map[string]interface{}{"x": 2}), errFrozen)
+	assert.ErrorIs(t, c.AddFiles("testdata/valid_parent.json"), errFrozen)
+	assert.ErrorIs(t, c.Merge(New()), errFrozen)
+	assert.ErrorIs(t, c.ApplyDefaults(&Schema{}), errFrozen)
+	assert.Equal(t, map[string]interface{}{"x": 1.0}, c.Data())
+
+	_, err = c.MergePreview([]byte(`{"x": 2}`))
+	assert.Nil(t, err)
+
+	clone := c.Clone()
+	assert.Nil(t, clone.AddData([]byte(`{"x": 2}`)))
+	assert.Equal(t, map[string]interface{}{"x": 2.0}, clone.Data())
+	assert.Equal(t, map[string]interface{}{"x": 1.0}, c.Data())
+}
+
+func TestConflate_Reset(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.json": {Data: []byte(`{"includes": ["?missing.json"], "a": {"x": 1}}`)},
+		"b.json": {Data: []byte(`{"a": {"y": 2}, "b": [1]}`)},
+	}
+
+	newConflate := func() *Conflate {
+		c := New(WithFS(fsys), WithMergeStrategy(MergeOptions{ArrayStrategy: ArrayAppend}))
+		c.TrackProvenance(true)
+
+		return c
+	}
+
+	add := func(c *Conflate) []byte {
+		assert.Nil(t, c.AddFiles("a.json", "b.json"))
+		assert.Nil(t, c.AddData([]byte(`{"b": [2]}`)))
+
+		out, err := c.MarshalJSON()
+		assert.Nil(t, err)
+
+		return out
+	}
+
+	fresh := newConflate()
+	want := add(fresh)
+
+	c := newConflate()
+	assert.Nil(t, c.AddFiles("b.json"))
+	assert.Nil(t, c.AddData([]byte(`{"c": 1}`)))
+	c.Freeze()
+
+	c.Reset()
+	assert.Nil(t, c.Data())
+	assert.Empty(t, c.Sources())
+	assert.Empty(t, c.Provenance())
+	assert.Empty(t, c.Result().Warnings)
+
+	assert.Equal(t, string(want), string(add(c)))
+	assert.Equal(t, fresh.Sources(), c.Sources())
+	assert.Equal(t, fresh.Provenance(), c.Provenance())
+	assert.Equal(t, fresh.Result(), c.Result())
+
+	// without provenance, none is recorded after a reset
+	c = New()
+	c.Reset()
+	assert.Nil(t, c.AddData([]byte(`{"a": 1}`)))
+	assert.Nil(t, c.Provenance())
+}
+
+func TestConflate_ConcurrentReads(t *testing.T) {
+	c, err := FromFiles("testdata/valid_parent.json")
+	assert.Nil(t, err)
+
+	c.Freeze()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			var out TestData
+
+			assert.Nil(t, c.Unmarshal(&out))
+			assert.NotNil(t, c.Data())
+			assert.Nil(t, c.WriteJSON(&bytes.Buffer{}))
+
+			_, err := c.MarshalYAML()
+			assert.Nil(t, err)
+
+			_, err = c.MarshalTOML()
+			assert.Nil(t, err)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestConflate_GCSOptions(t *testing.T) {
+	var (
+		paths []string
+		query string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		query = r.URL.RawQuery
+		_, _ = w.Write([]byte(`{"x": 1}`))
+	}))
+	defer server.Close()
+
+	c := New(WithGCSOptions(option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication()))
+
+	u, err := url.Parse("gs://bucket/conf/base.json")
+	assert.Nil(t, err)
+
+	err = c.AddURLs(u)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 1.0}, c.Data())
+	assert.Equal(t, []string{"/bucket/conf/base.json"}, paths)
+
+	u, err = url.Parse("gs://bucket/conf/base.json?generation=12345")
+	assert.Nil(t, err)
+
+	err = c.AddURLs(u)
+	assert.Nil(t, err)
+	assert.Equal(t, "generation=12345", query)
+}
+
+func TestConflate_YAMLAnchors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.yaml":     {Data: []byte("defaults: &defaults\n  pool: 5\n  timeout: 1\ndb:\n  <<: *defaults\n  timeout: 2\n")},
+		"override.yaml": {Data: []byte("defaults:\n  pool: 10\n")},
+		"alias.yaml":    {Data: []byte("db:\n  <<: *defaults\n")},
+	}
+
+	// the merge key is resolved when the file is decoded, so later changes to the anchored values do not apply to it
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddFiles("base.yaml", "override.yaml"))
+	assert.Equal(t, map[string]interface{}{
+		"defaults": map[string]interface{}{"pool": 10.0, "timeout": 1.0},
+		"db":       map[string]interface{}{"pool": 5.0, "timeout": 2.0},
+	}, c.Data())
+
+	c = New(WithFS(fsys))
+	err := c.AddFiles("base.yaml", "alias.yaml")
+	assert.ErrorIs(t, err, errYAMLAnchor)
+	assert.Contains(t, err.Error(), "alias.yaml")
+}
+
+func TestConflate_UseNumberYAML(t *testing.T) {
+	UseNumber = true
+
+	defer func() { UseNumber = false }()
+
+	fsys := fstest.MapFS{
+		"base.yaml":  {Data: []byte("id: 9007199254740993\nsize: 10\n")},
+		"local.yaml": {Data: []byte("size: 9007199254740995\n")},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddFiles("base.yaml", "local.yaml"))
+
+	out, err := c.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Contains(t, string(out), `"id": 9007199254740993`)
+	assert.Contains(t, string(out), `"size": 9007199254740995`)
+}
+
+func TestConflate_YAMLDocumentsMergeOptions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"stream.yaml": {Data: []byte("a: 1\nb: 2\nlist: [1]\n---\nb: null\nlist: [2]\n")},
+	}
+
+	c := New(WithFS(fsys), WithMergeStrategy(MergeOptions{ArrayStrategy: ArrayReplace, NullDelete: true}))
+	assert.Nil(t, c.AddFiles("stream.yaml"))
+	assert.Equal(t, map[string]interface{}{"a": 1.0, "list": []interface{}{2.0}}, c.Data())
+
+	c = New(WithMergeStrategy(MergeOptions{ArrayStrategy: ArrayReplace, NullDelete: true}))
+	assert.Nil(t, c.AddData([]byte("---\na: 1\nlist: [1]\n---\na: null\nlist: [2]\n")))
+	assert.Equal(t, map[string]interface{}{"list": []interface{}{2.0}}, c.Data())
+}
+
+func TestConflate_UseNumber(t *testing.T) {
+	UseNumber = true
+
+	defer func() { UseNumber = false }()
+
+	fsys := fstest.MapFS{
+		"base.json":  {Data: []byte(`{"id": 9007199254740993, "size": 10, "ratio": 0.5}`)},
+		"local.toml": {Data: []byte("size = 20\n")},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddFiles("base.json", "local.toml"))
+
+	out, err := c.MarshalJSON()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"id": 9007199254740993, "size": 20, "ratio": 0.5}`, string(out))
+	assert.Contains(t, string(out), "9007199254740993")
+
+	var cfg struct {
+		ID   int64
+		Size int
+	}
+
+	assert.Nil(t, c.Unmarshal(&cfg))
+	assert.Equal(t, int64(9007199254740993), cfg.ID)
+	assert.Equal(t, 20, cfg.Size)
+}
+
+func TestConflate_FS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base/app.json":   {Data: []byte(`{"includes": ["../common/*.json", "local.yaml"], "name": "app"}`)},
+		"base/local.yaml": {Data: []byte("local: true\n")},
+		"common/a.json":   {Data: []byte(`{"name": "a", "a": 1}`)},
+		"common/b.json":   {Data: []byte(`{"name": "b", "b": 1}`)},
+	}
+
+	c := New(WithFS(fsys))
+
+	err := c.AddFiles("base/app.json")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "app", "a": 1.0, "b": 1.0, "local": true}, c.Data())
+
+	err = c.AddFiles("/common/a.json")
+	assert.Nil(t, err)
+
+	err = c.AddFiles("testdata/valid_parent.json")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestConflate_GoIncludes(t *testing.T) {
+	type config struct {
+		Includes []string `json:"includes"`
+		Name     string   `json:"name"`
+	}
+
+	obj := config{Includes: []string{"testdata/valid_child.json"}, Name: "go"}
+
+	c := New()
+
+	err := c.AddGo(obj)
+	assert.Nil(t, err)
+	assert.Equal(t, "child", c.Data()["child_only"])
+	assert.NotContains(t, c.Data(), "includes")
+
+	c = New()
+	c.GoIncludes(false)
+
+	err = c.AddGo(obj, map[string]interface{}{"x": 1})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"includes": []interface{}{"testdata/valid_child.json"},
+		"name":     "go",
+		"x":        1.0,
+	}, c.Data())
+
+	err = c.AddGo(map[string]interface{}{"x": "y"})
+	assert.NotNil(t, err)
+}
+
+func TestFromFiles_EmptyFile(t *testing.T) {
+	c, err := FromFiles("testdata/valid_child.json", "testdata/blank.json", "testdata/blank.toml", "testdata/blank.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, "child", c.Data()["child_only"])
+}
+
+func TestFromFiles_SchemeLoader(t *testing.T) {
+	SchemeLoaders["consul"] = func(ctx gocontext.Context, u *url.URL) ([]byte, error) {
+		if u.Path == "/base" {
+			return []byte(`{"includes": ["child"], "x": 1}`), nil
+		}
+
+		return []byte(`{"y": 2}`), nil
+	}
+
+	defer delete(SchemeLoaders, "consul")
+
+	c, err := FromFiles("consul://kv/base")
+	assert.Nil(t, err)
+
+	var out map[string]interface{}
+
+	err = c.Unmarshal(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 1.0, "y": 2.0}, out)
+}
+
+func TestConflate_IncludesKey(t *testing.T) {
+	c := New()
+	c.IncludesKey("x-conflate-include")
+
+	err := c.AddData([]byte(`{"x-conflate-include": ["testdata/valid_child.json"], "includes": ["data"]}`))
+	assert.Nil(t, err)
+
+	data := c.Data()
+	assert.Equal(t, []interface{}{"data"}, data["includes"])
+	assert.NotContains(t, data, "x-conflate-include")
+	assert.Equal(t, "child", data["child_only"])
+}
+
+func TestConflate_TopLevelArrays(t *testing.T) {
+	type rule struct {
+		Name  string `json:"name"`
+		Allow bool   `json:"allow"`
+	}
+
+	fsys := fstest.MapFS{
+		"a.json": {Data: []byte(`[{"name": "a", "allow": true}, {"name": "b"}]`)},
+		"b.yaml": {Data: []byte("- name: b\n  allow: true\n- name: c\n")},
+		"c.json": {Data: []byte(`{"name": "d"}`)},
+	}
+
+	tests := []struct {
+		strategy ArrayStrategy
+		rules    []rule
+	}{
+		{strategy: ArrayUnion, rules: []rule{{"a", true}, {"b", true}, {"c", false}}},
+		{strategy: ArrayReplace, rules: []rule{{"b", true}, {"c", false}}},
+		{strategy: ArrayAppend, rules: []rule{{"a", true}, {"b", false}, {"b", true}, {"c", false}}},
+	}
+
+	for _, tt := range tests {
+		c := New(WithFS(fsys), WithMergeStrategy(MergeOptions{ArrayStrategy: tt.strategy}))
+
+		err := c.AddFiles("a.json", "b.yaml")
+		assert.Nil(t, err)
+
+		var rules []rule
+
+		err = c.Unmarshal(&rules)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.rules, rules)
+		assert.Nil(t, c.Data())
+
+		err = c.AddFiles("c.json")
+		assert.ErrorIs(t, err, errTopLevelConflict)
+	}
+
+	c := New(WithFS(fsys))
+	c.TrackProvenance(true)
+
+	err := c.AddFiles("c.json")
+	assert.Nil(t, err)
+
+	err = c.AddFiles("a.json")
+	assert.ErrorIs(t, err, errTopLevelConflict)
+}
+
+func TestConflate_DisableIncludes(t *testing.T) {
+	c := New()
+	c.DisableIncludes(true)
+	c.CheckIncludes(true)
+
+	err := c.AddFiles("testdata/valid_parent.json")
+	assert.Nil(t, err)
+
+	data := c.Data()
+	assert.Equal(t, []interface{}{"valid_child.json", "valid_sibling.json"}, data["includes"])
+	assert.NotContains(t, data, "child_only")
+	assert.Len(t, c.Sources(), 1)
+	assert.Empty(t, c.Result().Warnings)
+
+	c.IncludesKey("x-include")
+
+	err = c.AddData([]byte(`{"x-include": ["testdata/valid_child.json"]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{"testdata/valid_child.json"}, c.Data()["x-include"])
+
+	c.DisableIncludes(false)
+
+	err = c.AddData([]byte(`{"x-include": ["testdata/valid_child.json"]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, "child", c.Data()["child_only"])
+}
+
+func TestConflate_IncludesOverride(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json":    {Data: []byte(`{"includes": ["a.json", "b.json"], "name": "base", "base": true}`)},
+		"a.json":       {Data: []byte(`{"includes": ["a-1.json"], "name": "a", "a": "a"}`)},
+		"a-1.json":     {Data: []byte(`{"name": "a-1", "a": "a-1"}`)},
+		"b.json":       {Data: []byte(`{"name": "b"}`)},
+		"recurse.json": {Data: []byte(`{"includes": ["recurse.json"]}`)},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddFiles("base.json"))
+	assert.Equal(t, map[string]interface{}{"name": "base", "base": true, "a": "a"}, c.Data())
+
+	c = New(WithFS(fsys))
+	c.IncludesOverride(true)
+	assert.Nil(t, c.AddFiles("base.json"))
+	assert.Equal(t, map[string]interface{}{"name": "b", "base": true, "a": "a-1"}, c.Data())
+
+	err := c.AddFiles("recurse.json")
+	assert.ErrorIs(t, err, errRecursiveURL)
+}
+
+func TestFromFiles_Glob(t *testing.T) {
+	c, err := FromFiles("testdata/glob_include.json")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "override", "base": 1.0, "own": true}, c.Data())
+
+	c, err = FromFiles("testdata/conf.d/*.json")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "override", "base": 1.0}, c.Data())
+}
+
 func TestFromFiles_IncludesRemoved(t *testing.T) {
 	c, err := FromFiles("testdata/valid_parent.json")
 	assert.Nil(t, err)
@@ -162,6 +818,19 @@ func TestFromURLs(t *testing.T) {
 	assert.NotNil(t, c)
 }
 
+func TestFromURLsContext_Cancelled(t *testing.T) {
+	u, err := toURL(nil, "http://0.0.0.0:9999/valid_parent.json")
+	assert.Nil(t, err)
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	cancel()
+
+	c, err := FromURLsContext(ctx, u)
+	assert.NotNil(t, err)
+	assert.ErrorIs(t, err, gocontext.Canceled)
+	assert.Nil(t, c)
+}
+
 func TestFromURLs_Error(t *testing.T) {
 	url, err := toURL(nil, "missing file")
 	assert.Nil(t, err)
@@ -300,6 +969,255 @@ func TestFromData_MergeToError(t *testing.T) {
 	assert.Contains(t, err.Error(), "failed to merge object property")
 }
 
+func TestConflate_MergeOptionsArrayKeys(t *testing.T) {
+	c := New()
+	c.MergeOptions(MergeOptions{ArrayKeys: map[string]string{"/list": "key"}})
+
+	err := c.AddData([]byte(`{"list": [{"key": "a", "x": 1}]}`), []byte(`{"list": [{"key": "a", "y": 2}]}`))
+	assert.Nil(t, err)
+
+	var out map[string]interface{}
+
+	err = c.Unmarshal(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"key": "a", "x": 1.0, "y": 2.0}}, out["list"])
+}
+
+func TestConflate_MergeOptionsArrayStrategy(t *testing.T) {
+	c := New()
+	c.MergeOptions(MergeOptions{ArrayStrategy: ArrayReplace})
+
+	err := c.AddData([]byte(`{"list": [1, 2]}`), []byte(`{"list": [3]}`))
+	assert.Nil(t, err)
+
+	var out map[string]interface{}
+
+	err = c.Unmarshal(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{3.0}, out["list"])
+}
+
+func TestConflate_Provenance(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.Provenance())
+
+	c.TrackProvenance(true)
+
+	err := c.AddFiles("testdata/valid_parent.json")
+	assert.Nil(t, err)
+
+	sources := map[string]string{}
+	for pointer, url := range c.Provenance() {
+		sources[pointer] = path.Base(url.Path)
+	}
+
+	assert.Equal(t, map[string]string{
+		"/child_only":     "valid_child.json",
+		"/sibling_only":   "valid_sibling.json",
+		"/sibling_child":  "valid_sibling.json",
+		"/parent_only":    "valid_parent.json",
+		"/parent_child":   "valid_parent.json",
+		"/parent_sibling": "valid_parent.json",
+		"/all":            "valid_parent.json",
+	}, sources)
+
+	err = c.AddData([]byte(`{"all": "data"}`))
+	assert.Nil(t, err)
+	assert.Nil(t, c.Provenance()["/all"])
+	assert.Contains(t, c.Provenance(), "/all")
+}
+
+func TestConflate_Positions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json": {Data: []byte("{\n  \"includes\": [\"app.yaml\"],\n  \"db\": {\"host\": \"a\", \"port\": 1}\n}")},
+		"app.yaml":  {Data: []byte("db:\n  host: b\nlist: [1, 2]\n")},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.Positions())
+
+	c.TrackProvenance(true)
+	assert.Nil(t, c.AddFiles("base.json"))
+	assert.Nil(t, c.AddData([]byte(`{"extra": true}`)))
+
+	positions := map[string]string{}
+	for pointer, pos := range c.Positions() {
+		source := "data"
+		if pos.URL != nil {
+			source = path.Base(pos.URL.Path)
+		}
+
+		positions[pointer] = fmt.Sprintf("%v:%v:%v", source, pos.Line, pos.Col)
+	}
+
+	assert.Equal(t, map[string]string{
+		"/db/host": "base.json:3:18",
+		"/db/port": "base.json:3:31",
+		"/list":    "app.yaml:3:7",
+		"/extra":   "data:1:11",
+	}, positions)
+
+	assert.Equal(t, c.Positions(), c.Clone().Positions())
+
+	c.Reset()
+	assert.Empty(t, c.Positions())
+}
+
+func TestConflate_MergePreview(t *testing.T) {
+	c := New()
+	c.MergeOptions(MergeOptions{NullDelete: true})
+
+	err := c.AddData([]byte(`{"a": 1, "b": "x", "c": true, "list": [1]}`))
+	assert.Nil(t, err)
+
+	changes, err := c.MergePreview([]byte(`{"a": 2, "b": "x", "c": null, "d": {"e": 1}, "list": [2]}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []MergeChange{
+		{Pointer: "/a", Action: MergeOverwrite, OldValue: 1.0, NewValue: 2.0},
+		{Pointer: "/c", Action: MergeDelete, OldValue: true},
+		{Pointer: "/d", Action: MergeAdd, NewValue: map[string]interface{}{"e": 1.0}},
+		{Pointer: "/list", Action: MergeAppend, OldValue: []interface{}{1.0}, NewValue: []interface{}{1.0, 2.0}},
+	}, changes)
+
+	var out map[string]interface{}
+
+	err = c.Unmarshal(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1.0, "b": "x", "c": true, "list": []interface{}{1.0}}, out)
+}
+
+func TestConflate_MergePreviewIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json":  {Data: []byte(`{"a": 1}`)},
+		"other.json": {Data: []byte(`{"a": 2}`)},
+	}
+
+	var loads int
+
+	c := New(WithFS(fsys))
+	c.OnLoad(func(*url.URL, []byte, error) error {
+		loads++
+
+		return nil
+	})
+	assert.Nil(t, c.AddFiles("base.json"))
+
+	sources := c.Sources()
+	result := c.Result()
+
+	changes, err := c.MergePreview([]byte(`{"includes": ["other.json", "?missing.json"]}`))
+	assert.Nil(t, err)
+	assert.Len(t, changes, 1)
+	assert.Equal(t, "/other.json", changes[0].Source.Path)
+
+	assert.Equal(t, sources, c.Sources())
+	assert.Equal(t, result, c.Result())
+	assert.Equal(t, 1, loads)
+}
+
+func TestConflate_MergePreviewEmpty(t *testing.T) {
+	c := New()
+
+	changes, err := c.MergePreview([]byte(`{"a": 1}`), []byte(`{"a": 1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, []MergeChange{{Pointer: "", Action: MergeAdd, NewValue: map[string]interface{}{"a": 1.0}}}, changes)
+}
+
+func TestConflate_MergePreviewError(t *testing.T) {
+	c, err := FromData([]byte(`{"a": 1}`))
+	assert.Nil(t, err)
+
+	_, err = c.MergePreview([]byte(`{"a": {}}`))
+	assert.NotNil(t, err)
+}
+
+func TestConflate_Data(t *testing.T) {
+	c := New()
+	assert.Nil(t, c.Data())
+
+	err := c.AddData([]byte(`{"obj": {"list": [1]}}`))
+	assert.Nil(t, err)
+
+	s, err := NewSchemaData([]byte(`{"type": "object", "properties": {"name": {"type": "string", "default": "x"}}}`))
+	assert.Nil(t, err)
+
+	err = c.ApplyDefaults(s)
+	assert.Nil(t, err)
+
+	data := c.Data()
+	assert.Equal(t, map[string]interface{}{"name": "x", "obj": map[string]interface{}{"list": []interface{}{1.0}}}, data)
+
+	data["name"] = "y"
+	data["obj"].(map[string]interface{})["list"].([]interface{})[0] = 2.0
+	assert.Equal(t, map[string]interface{}{"name": "x", "obj": map[string]interface{}{"list": []interface{}{1.0}}}, c.Data())
+}
+
+func TestConflate_UnmarshalKeepsDefaults(t *testing.T) {
+	type server struct {
+		Host  string `json:"host"`
+		Port  int    `json:"port"`
+		Debug bool   `json:"debug"`
+		TLS   struct {
+			Enabled bool   `json:"enabled"`
+			Cert    string `json:"cert"`
+		} `json:"tls"`
+	}
+
+	out := server{Host: "localhost", Port: 8080, Debug: true}
+	out.TLS.Enabled = true
+	out.TLS.Cert = "default.pem"
+
+	c, err := FromData([]byte(`{"host": "example.com"}`), []byte(`{"debug": false, "tls": {"cert": "x.pem"}}`))
+	assert.Nil(t, err)
+
+	err = c.Unmarshal(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, "example.com", out.Host)
+	assert.Equal(t, 8080, out.Port)
+	assert.False(t, out.Debug)
+	assert.True(t, out.TLS.Enabled)
+	assert.Equal(t, "x.pem", out.TLS.Cert)
+}
+
+func TestConflate_ValidateWithSchemaURL(t *testing.T) {
+	c, err := FromData([]byte(`{"port": 8080, "name": "app"}`))
+	assert.Nil(t, err)
+
+	err = c.ValidateWithSchemaURL("testdata/schema_refs/main.schema.json")
+	assert.Nil(t, err)
+
+	c, err = FromData([]byte(`{"port": 0}`))
+	assert.Nil(t, err)
+
+	err = c.ValidateWithSchemaURL("testdata/schema_refs/main.schema.json")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "(#/port)")
+}
+
+func TestConflate_ValidateWithSchemaURLRemote(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./testdata/schema_refs")))
+	defer server.Close()
+
+	c, err := FromData([]byte(`{"port": "x"}`))
+	assert.Nil(t, err)
+
+	err = c.ValidateWithSchemaURL(server.URL + "/main.schema.json")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Invalid type. Expected: integer, given: string")
+}
+
+func TestConflate_ValidateWithSchemaURLError(t *testing.T) {
+	c := New()
+
+	err := c.ValidateWithSchemaURL("")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to obtain url to schema")
+
+	err = c.ValidateWithSchemaURL("testdata/missing.schema.json")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "failed to load schema url")
+}
+
 func TestFromGo(t *testing.T) {
 	x := struct{ X []int }{X: []int{1}}
 	y := struct{ X []int }{X: []int{2}}
@@ -344,6 +1262,40 @@ func TestConflate_MarshalYAML(t *testing.T) {
 	assert.Equal(t, testMarshalYAML, data)
 }
 
+func TestConflate_YAMLOptions(t *testing.T) {
+	in := []byte(`{"server": {"host": "a", "ports": [80, 443], "tls": {"cert": "x.pem"}}, "on": "yes", "empty": []}`)
+
+	for _, opts := range []YAMLOptions{{}, {Indent: 4}, {Flow: true}, {Indent: 3, Flow: true}} {
+		c, err := FromData(in)
+		assert.Nil(t, err)
+
+		c.YAMLOptions(opts)
+
+		out, err := c.MarshalYAML()
+		assert.Nil(t, err)
+
+		var buf bytes.Buffer
+
+		assert.Nil(t, c.WriteYAML(&buf))
+		assert.Equal(t, string(out), buf.String())
+
+		// the output is parsed back to the same data
+		c2, err := FromData(out)
+		assert.Nil(t, err)
+		assert.Equal(t, c.Data(), c2.Data(), string(out))
+	}
+
+	c, err := FromData(in)
+	assert.Nil(t, err)
+
+	c.YAMLOptions(YAMLOptions{Indent: 4})
+
+	out, err := c.Clone().MarshalYAML()
+	assert.Nil(t, err)
+	assert.Equal(t, "empty: []\n\"on\": \"yes\"\nserver:\n    host: a\n    ports:\n      - 80\n      - 443\n"+
+		"    tls:\n        cert: x.pem\n", string(out))
+}
+
 func TestConflate_MarshalTOML(t *testing.T) {
 	c, err := FromData(testMarshalTOML)
 	assert.Nil(t, err)
@@ -353,6 +1305,164 @@ func TestConflate_MarshalTOML(t *testing.T) {
 	assert.Equal(t, testMarshalTOML, data)
 }
 
+func TestConflate_WriteJSON(t *testing.T) {
+	c, err := FromData(testMarshalJSON)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+
+	err = c.WriteJSON(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(testMarshalJSON), buf.String())
+}
+
+func TestConflate_JSONOptions(t *testing.T) {
+	c, err := FromData([]byte(`{"html": "<a&b>", "list": [1]}`))
+	assert.Nil(t, err)
+
+	out, err := c.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, "{\n  \"html\": \"<a&b>\",\n  \"list\": [\n    1\n  ]\n}\n", string(out))
+
+	c.JSONOptions(JSONOptions{Prefix: "> ", Indent: "\t", EscapeHTML: true})
+
+	out, err = c.MarshalJSON()
+	assert.Nil(t, err)
+	assert.Equal(t, "{\n> \t\"html\": \"\\u003ca\\u0026b\\u003e\",\n> \t\"list\": [\n> \t\t1\n> \t]\n> }\n", string(out))
+
+	c.JSONOptions(JSONOptions{})
+
+	var buf bytes.Buffer
+
+	err = c.WriteJSON(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"html\":\"<a&b>\",\"list\":[1]}\n", buf.String())
+}
+
+func TestConflate_WriteYAML(t *testing.T) {
+	c, err := FromData(testMarshalYAML)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+
+	err = c.WriteYAML(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(testMarshalYAML), buf.String())
+}
+
+func TestConflate_WriteTOML(t *testing.T) {
+	c, err := FromData(testMarshalTOML)
+	assert.Nil(t, err)
+
+	var buf bytes.Buffer
+
+	err = c.WriteTOML(&buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(testMarshalTOML), buf.String())
+}
+
+func TestConflate_WriteError(t *testing.T) {
+	c, err := FromData(testMarshalJSON)
+	assert.Nil(t, err)
+
+	w := testFailingWriter{}
+
+	assert.ErrorIs(t, c.WriteJSON(w), errTest)
+	assert.ErrorIs(t, c.WriteYAML(w), errTest)
+	assert.ErrorIs(t, c.WriteTOML(w), errTest)
+}
+
+func TestConflate_WriteJSONStreamed(t *testing.T) {
+	list := make([]interface{}, 10000)
+	for i := range list {
+		list[i] = map[string]interface{}{"index": float64(i)}
+	}
+
+	c, err := FromGo(map[string]interface{}{"list": list})
+	assert.Nil(t, err)
+
+	out, err := c.MarshalJSON()
+	assert.Nil(t, err)
+
+	w := testCountingWriter{}
+
+	err = c.WriteJSON(&w)
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), w.String())
+	assert.Greater(t, w.writes, 1)
+}
+
+type testCountingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *testCountingWriter) Write(p []byte) (int, error) {
+	w.writes++
+
+	return w.Buffer.Write(p)
+}
+
+type testFailingWriter struct{}
+
+func (testFailingWriter) Write([]byte) (int, error) {
+	return 0, errTest
+}
+
+func TestConflate_MarshalSortedKeys(t *testing.T) {
+	data := []byte(`{"r": {"b": 1, "a": 2}, "q": [{"d": 1, "c": 2}], "p": 1}`)
+
+	for i := 0; i < 10; i++ {
+		c, err := FromData(data)
+		assert.Nil(t, err)
+
+		out, err := c.MarshalJSON()
+		assert.Nil(t, err)
+		assert.Equal(t, `{
+  "p": 1,
+  "q": [
+    {
+      "c": 2,
+      "d": 1
+    }
+  ],
+  "r": {
+    "a": 2,
+    "b": 1
+  }
+}
+`, string(out))
+
+		out, err = c.MarshalYAML()
+		assert.Nil(t, err)
+		assert.Equal(t, "p: 1\nq:\n- c: 2\n  d: 1\nr:\n  a: 2\n  b: 1\n", string(out))
+
+		out, err = c.MarshalTOML()
+		assert.Nil(t, err)
+		assert.Equal(t, "p = 1.0\n\n[[q]]\n  c = 2.0\n  d = 1.0\n\n[r]\n  a = 2.0\n  b = 1.0\n", string(out))
+	}
+}
+
+func TestConflate_MarshalTOMLRoundTrip(t *testing.T) {
+	c, err := FromData([]byte(`{"server": {"host": "a", "tls": {"cert": "x.pem"}}, "users": [{"name": "a"}, {"name": "b"}]}`))
+	assert.Nil(t, err)
+
+	data, err := c.MarshalTOML()
+	assert.Nil(t, err)
+
+	c2, err := FromData(data)
+	assert.Nil(t, err)
+
+	var expected, actual map[string]interface{}
+
+	err = c.Unmarshal(&expected)
+	assert.Nil(t, err)
+
+	err = c2.Unmarshal(&actual)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, actual)
+}
+
 func TestConflate_addDataError(t *testing.T) {
 	c := New()
 	err := c.AddData([]byte(`{"includes": ["missing"]}`))
@@ -366,3 +1476,131 @@ func TestConflate_mergeDataError(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to merge")
 }
+
+func TestConflate_AllowSchemes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/base.json":
+			_, _ = w.Write([]byte(`{"includes": ["child.json", "file:///etc/passwd"]}`))
+		case "/redirect.json":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		default:
+			_, _ = w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	var loaded []string
+
+	c := New()
+	c.AllowSchemes("HTTP")
+	c.OnLoad(func(u *url.URL, data []byte, err error) error {
+		loaded = append(loaded, u.Path)
+
+		return nil
+	})
+
+	err := c.AddFiles(server.URL + "/base.json")
+	assert.ErrorIs(t, err, errSchemeDenied)
+	assert.Contains(t, err.Error(), `"file"`)
+	// the includes are checked before any of them is loaded
+	assert.Equal(t, []string{"/base.json"}, loaded)
+
+	err = c.AddFiles(server.URL + "/redirect.json")
+	assert.ErrorIs(t, err, errSchemeDenied)
+
+	err = c.AddFiles("testdata/valid_parent.json")
+	assert.ErrorIs(t, err, errSchemeDenied)
+
+	c.AllowSchemes()
+	assert.Nil(t, c.AddFiles("testdata/valid_parent.json"))
+}
+
+func TestConflate_FileRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := path.Join(dir, "conf")
+	assert.Nil(t, os.Mkdir(root, 0o700))
+	assert.Nil(t, os.WriteFile(path.Join(root, "base.json"), []byte(`{"includes": ["../secret.json"]}`), 0o600))
+	assert.Nil(t, os.WriteFile(path.Join(root, "ok.json"), []byte(`{"x": 1}`), 0o600))
+	assert.Nil(t, os.WriteFile(path.Join(dir, "secret.json"), []byte(`{"secret": 1}`), 0o600))
+
+	c := New()
+	c.FileRoot(root)
+
+	err := c.AddFiles(path.Join(root, "base.json"))
+	assert.ErrorIs(t, err, errOutsideFiles)
+
+	err = c.AddFiles(path.Join(root, "ok.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 1.0}, c.Data())
+}
+
+func TestConflate_DisableFile(t *testing.T) {
+	c := New()
+	c.TransportOptions(TransportOptions{DisableFile: true})
+
+	err := c.AddData([]byte(`{"includes": ["file:///etc/passwd"]}`))
+	assert.ErrorIs(t, err, errFileDisabled)
+
+	err = c.AddFiles("testdata/valid_parent.json")
+	assert.ErrorIs(t, err, errFileDisabled)
+}
+
+func TestConflate_TransportOptions(t *testing.T) {
+	opts := TransportOptions{
+		DialTimeout:         3 * time.Second,
+		TLSHandshakeTimeout: 4 * time.Second,
+		IdleConnTimeout:     5 * time.Second,
+		MaxIdleConns:        6,
+	}
+
+	c := New()
+	c.TransportOptions(opts)
+
+	transport, ok := c.loader.client().Transport.(*http.Transport)
+	assert.True(t, ok)
+	assert.Equal(t, 4*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 5*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, 6, transport.MaxIdleConns)
+	assert.NotNil(t, transport.DialContext)
+	assert.Equal(t, 3*time.Second, newDialer(opts.withDefaults()).Timeout)
+
+	// zero values are replaced by the defaults
+	transport = newTransport(TransportOptions{})
+	assert.Equal(t, 10*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 90*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, 100, transport.MaxIdleConns)
+	assert.NotNil(t, transport.Proxy)
+	assert.Equal(t, 30*time.Second, newDialer(TransportOptions{}.withDefaults()).Timeout)
+}
+
+func TestConflate_HTTPClient(t *testing.T) {
+	c := New()
+	c.HTTPClient(&http.Client{Transport: testRoundTripper{
+		"http://config.test/app.json":   `{"includes": ["child.json"], "x": 1}`,
+		"http://config.test/child.json": `{"y": 2}`,
+	}})
+
+	err := c.AddFiles("http://config.test/app.json")
+	assert.Nil(t, err)
+
+	var out map[string]interface{}
+
+	err = c.Unmarshal(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 1.0, "y": 2.0}, out)
+}
+
+func TestConflate_RetryPolicy(t *testing.T) {
+	c := New()
+	c.RetryPolicy(RetryPolicy{MaxAttempts: 3})
+	assert.Equal(t, 3, c.loader.retry.attempts())
+}
+
+func TestConflate_HostHeaders(t *testing.T) {
+	c := New()
+	c.Headers(http.Header{"X-Tenant": {"acme"}})
+	c.HostHeaders("config.test", http.Header{"Authorization": {"Bearer token"}})
+	assert.Equal(t, "acme", c.loader.headers.Get("X-Tenant"))
+	assert.Equal(t, "Bearer token", c.loader.hostHeaders["config.test"].Get("Authorization"))
+}
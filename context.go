@@ -1,8 +1,8 @@
 package conflate
 
 import (
-	"fmt"
-	"path"
+	"strconv"
+	"strings"
 )
 
 func rootContext() context {
@@ -13,10 +13,26 @@ func (c context) String() string {
 	return string(c)
 }
 
-func (c context) add(s ...string) context {
-	return context(path.Join(c.String(), path.Join(s...)))
+// add returns the context of the value at the names within the value at the context, each escaped as a JSON
+// pointer token, so that a name containing '/' or '~' is a single token.
+func (c context) add(names ...string) context {
+	var sb strings.Builder
+
+	sb.WriteString(c.String())
+
+	for _, name := range names {
+		sb.WriteByte('/')
+		sb.WriteString(escapePointerToken(name))
+	}
+
+	return context(sb.String())
 }
 
 func (c context) addInt(i int) context {
-	return context(fmt.Sprintf("%v[%v]", c.String(), i))
+	return c.add(strconv.Itoa(i))
+}
+
+// pointer returns the context as a JSON pointer, which is blank for the root.
+func (c context) pointer() string {
+	return strings.TrimPrefix(c.String(), rootContext().String())
 }
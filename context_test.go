@@ -22,5 +22,11 @@ func TestMakeContext_AddInt(t *testing.T) {
 	ctx := rootContext()
 	ctx2 := ctx.add("parent").addInt(3)
 	assert.Equal(t, "#", ctx.String())
-	assert.Equal(t, "#/parent[3]", ctx2.String())
+	assert.Equal(t, "#/parent/3", ctx2.String())
+}
+
+func TestMakeContext_Pointer(t *testing.T) {
+	ctx := rootContext()
+	assert.Equal(t, "", ctx.pointer())
+	assert.Equal(t, "/parent/child", ctx.add("parent", "child").pointer())
 }
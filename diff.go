@@ -0,0 +1,231 @@
+package conflate
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ChangeOp is the operation of a Change, named as in an RFC 6902 JSON Patch.
+type ChangeOp string
+
+const (
+	// ChangeAdd is a value that is only in the second data.
+	ChangeAdd ChangeOp = "add"
+	// ChangeRemove is a value that is only in the first data.
+	ChangeRemove ChangeOp = "remove"
+	// ChangeReplace is a value that differs between the two, other than an object or an array compared in turn.
+	ChangeReplace ChangeOp = "replace"
+)
+
+// Change is a difference between the merged data of two Conflate instances, as reported by Diff.
+// Old is nil for an add, and New is nil for a remove.
+type Change struct {
+	Pointer string
+	Op      ChangeOp
+	Old     interface{}
+	New     interface{}
+}
+
+// Diff returns the changes that turn the merged data of a into the merged data of b, which applied in order as
+// a JSON Patch give the data of b. Objects are compared key by key in sorted order. Arrays are compared item by item,
+// with the items beyond the end of the shorter array added in order, or removed from the last, so that the index of
+// each change is that of the array once the changes before it are made. Numbers of different types are equal when
+// they have the same value.
+func Diff(a, b *Conflate) ([]Change, error) {
+	from, err := a.normalized()
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := b.normalized()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	diffValue("", from, to, &changes)
+
+	return changes, nil
+}
+
+// Patch returns an RFC 6902 JSON Patch of the changes that turn the data of the first document merged, i.e. the
+// first source, or the first include of the first source, into the merged data, as for Diff. It is an empty patch
+// when no data has been added.
+func (c *Conflate) Patch() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var base, data interface{}
+
+	err := normalize(c.base, &base)
+	if err == nil {
+		err = normalize(c.data, &data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []Change{}
+	diffValue("", base, data, &changes)
+
+	return MarshalPatch(changes)
+}
+
+// patchOp is an operation of a JSON Patch, where only an add or a replace has a value, which may be null.
+type patchOp struct {
+	Op    ChangeOp     `json:"op"`
+	Path  string       `json:"path"`
+	Value *interface{} `json:"value,omitempty"`
+}
+
+// MarshalPatch writes the changes as an RFC 6902 JSON Patch.
+func MarshalPatch(changes []Change) ([]byte, error) {
+	ops := make([]patchOp, 0, len(changes))
+
+	for i := range changes {
+		op := patchOp{Op: changes[i].Op, Path: changes[i].Pointer}
+		if op.Op != ChangeRemove {
+			op.Value = &changes[i].New
+		}
+
+		ops = append(ops, op)
+	}
+
+	return jsonMarshal(ops)
+}
+
+// normalized returns a copy of the data with the types of JSON, so that values of different formats can be compared.
+func (c *Conflate) normalized() (interface{}, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var data interface{}
+
+	err := normalize(c.data, &data)
+
+	return data, err
+}
+
+func normalize(in interface{}, out *interface{}) error {
+	if in == nil {
+		return nil
+	}
+
+	return jsonMarshalUnmarshal(in, out)
+}
+
+func diffValue(pointer string, from, to interface{}, changes *[]Change) {
+	switch {
+	case from == nil && to == nil:
+		return
+	case from == nil:
+		*changes = append(*changes, Change{Pointer: pointer, Op: ChangeAdd, New: to})
+
+		return
+	case to == nil:
+		*changes = append(*changes, Change{Pointer: pointer, Op: ChangeRemove, Old: from})
+
+		return
+	}
+
+	fromProps, fromObject := from.(map[string]interface{})
+	toProps, toObject := to.(map[string]interface{})
+
+	if fromObject && toObject {
+		diffObject(pointer, fromProps, toProps, changes)
+
+		return
+	}
+
+	fromItems, fromArray := from.([]interface{})
+	toItems, toArray := to.([]interface{})
+
+	if fromArray && toArray {
+		diffArray(pointer, fromItems, toItems, changes)
+
+		return
+	}
+
+	if !equalValues(from, to) {
+		*changes = append(*changes, Change{Pointer: pointer, Op: ChangeReplace, Old: from, New: to})
+	}
+}
+
+func diffObject(pointer string, from, to map[string]interface{}, changes *[]Change) {
+	names := make([]string, 0, len(from)+len(to))
+
+	for name := range from {
+		names = append(names, name)
+	}
+
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := pointer + "/" + escapePointerToken(name)
+		fromVal, inFrom := from[name]
+		toVal, inTo := to[name]
+
+		// an explicit null is a value of its own, unlike a missing key
+		switch {
+		case !inTo:
+			*changes = append(*changes, Change{Pointer: child, Op: ChangeRemove, Old: fromVal})
+		case !inFrom:
+			*changes = append(*changes, Change{Pointer: child, Op: ChangeAdd, New: toVal})
+		case fromVal == nil || toVal == nil:
+			if fromVal != nil || toVal != nil {
+				*changes = append(*changes, Change{Pointer: child, Op: ChangeReplace, Old: fromVal, New: toVal})
+			}
+		default:
+			diffValue(child, fromVal, toVal, changes)
+		}
+	}
+}
+
+func diffArray(pointer string, from, to []interface{}, changes *[]Change) {
+	common := len(from)
+	if len(to) < common {
+		common = len(to)
+	}
+
+	for i := 0; i < common; i++ {
+		child := pointer + "/" + strconv.Itoa(i)
+
+		if from[i] == nil || to[i] == nil {
+			if from[i] != nil || to[i] != nil {
+				*changes = append(*changes, Change{Pointer: child, Op: ChangeReplace, Old: from[i], New: to[i]})
+			}
+
+			continue
+		}
+
+		diffValue(child, from[i], to[i], changes)
+	}
+
+	for i := common; i < len(to); i++ {
+		*changes = append(*changes, Change{Pointer: pointer + "/" + strconv.Itoa(i), Op: ChangeAdd, New: to[i]})
+	}
+
+	for i := len(from) - 1; i >= common; i-- {
+		*changes = append(*changes, Change{Pointer: pointer + "/" + strconv.Itoa(i), Op: ChangeRemove, Old: from[i]})
+	}
+}
+
+// equalValues reports whether the values are the same, where numbers of different types are equal when they have
+// the same value.
+func equalValues(a, b interface{}) bool {
+	if aNum, ok := numberValue(a); ok {
+		if bNum, ok := numberValue(b); ok {
+			return aNum.Cmp(bNum) == 0
+		}
+	}
+
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,133 @@
+package conflate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	a, err := FromData([]byte(`{
+		"name": "app",
+		"port": 80,
+		"db": {"host": "a", "pool": 10, "user": null},
+		"hosts": ["a", "b", "c"],
+		"tags": ["x"],
+		"old": {"x": 1}
+	}`))
+	assert.Nil(t, err)
+
+	b, err := FromData([]byte(`
+name = "app"
+port = 80
+hosts = ["a", "d"]
+tags = ["x", "y", "z"]
+new = true
+
+[db]
+host = "b"
+pool = 10
+`))
+	assert.Nil(t, err)
+
+	changes, err := Diff(a, b)
+	assert.Nil(t, err)
+	assert.Equal(t, []Change{
+		{Pointer: "/db/host", Op: ChangeReplace, Old: "a", New: "b"},
+		{Pointer: "/db/user", Op: ChangeRemove},
+		{Pointer: "/hosts/1", Op: ChangeReplace, Old: "b", New: "d"},
+		{Pointer: "/hosts/2", Op: ChangeRemove, Old: "c"},
+		{Pointer: "/new", Op: ChangeAdd, New: true},
+		{Pointer: "/old", Op: ChangeRemove, Old: map[string]interface{}{"x": 1.0}},
+		{Pointer: "/tags/1", Op: ChangeAdd, New: "y"},
+		{Pointer: "/tags/2", Op: ChangeAdd, New: "z"},
+	}, changes)
+
+	changes, err = Diff(a, a)
+	assert.Nil(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestDiff_Whole(t *testing.T) {
+	a := New()
+
+	b, err := FromData([]byte(`{"a": 1}`))
+	assert.Nil(t, err)
+
+	changes, err := Diff(a, b)
+	assert.Nil(t, err)
+	assert.Equal(t, []Change{{Pointer: "", Op: ChangeAdd, New: map[string]interface{}{"a": 1.0}}}, changes)
+
+	changes, err = Diff(b, a)
+	assert.Nil(t, err)
+	assert.Equal(t, []Change{{Pointer: "", Op: ChangeRemove, Old: map[string]interface{}{"a": 1.0}}}, changes)
+
+	c, err := FromData([]byte(`[1, 2]`))
+	assert.Nil(t, err)
+
+	changes, err = Diff(b, c)
+	assert.Nil(t, err)
+	assert.Equal(t, []Change{{Pointer: "", Op: ChangeReplace, Old: map[string]interface{}{"a": 1.0}, New: []interface{}{1.0, 2.0}}}, changes)
+}
+
+func TestDiff_Error(t *testing.T) {
+	a := New()
+
+	b := New()
+	b.data = map[string]interface{}{"f": func() {}}
+
+	_, err := Diff(a, b)
+	assert.NotNil(t, err)
+}
+
+func TestConflate_Patch(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json": {Data: []byte(`{"db": {"host": "a", "user": "x"}, "hosts": ["a"], "ports": [80, 443]}`)},
+		"prod.yaml": {Data: []byte("db:\n  host: b\n  user: null\n  pool: {size: 10}\nhosts: [b]\nports: [8080]\n")},
+	}
+
+	c := New(WithFS(fsys), WithMergeStrategy(MergeOptions{
+		NullDelete:      true,
+		ArrayStrategies: map[string]ArrayStrategy{"/ports": ArrayReplace},
+	}))
+
+	out, err := c.Patch()
+	assert.Nil(t, err)
+	assert.Equal(t, "[]\n", string(out))
+
+	err = c.AddFiles("base.json", "prod.yaml")
+	assert.Nil(t, err)
+
+	out, err = c.Patch()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[
+		{"op": "replace", "path": "/db/host", "value": "b"},
+		{"op": "add", "path": "/db/pool", "value": {"size": 10}},
+		{"op": "remove", "path": "/db/user"},
+		{"op": "add", "path": "/hosts/1", "value": "b"},
+		{"op": "replace", "path": "/ports/0", "value": 8080},
+		{"op": "remove", "path": "/ports/1"}
+	]`, string(out))
+
+	// the base is kept by a clone, and forgotten on a reset
+	out2, err := c.Clone().Patch()
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), string(out2))
+
+	c.Reset()
+	assert.Nil(t, c.AddData([]byte(`{"a": 1}`), []byte(`{"a": null, "b": 1}`)))
+
+	out, err = c.Patch()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"op": "remove", "path": "/a"}, {"op": "add", "path": "/b", "value": 1}]`, string(out))
+}
+
+func TestMarshalPatch(t *testing.T) {
+	out, err := MarshalPatch([]Change{
+		{Pointer: "/a", Op: ChangeReplace, Old: 1.0, New: nil},
+		{Pointer: "", Op: ChangeAdd, New: []interface{}{}},
+	})
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"op": "replace", "path": "/a", "value": null}, {"op": "add", "path": "", "value": []}]`, string(out))
+}
@@ -0,0 +1,94 @@
+package conflate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	pkgurl "net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errDirScheme        = errors.New("only file paths can be loaded as a directory")
+	errUnknownExtension = errors.New("the file does not have the extension of a known format")
+)
+
+// dirURLs returns the url of each file in the directory and its subdirectories with the extension of a known format.
+// The entries of each directory are in lexical order of their names, with the files of a subdirectory in the place
+// of its name, e.g. a/b.json comes before a-c.json. Files with other extensions are skipped, unless failUnknownFiles
+// is set.
+func (l *loader) dirURLs(dir string) ([]*pkgurl.URL, error) {
+	roots, err := l.toURLs(nil, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var urls []*pkgurl.URL
+
+	for _, root := range roots {
+		if root.Scheme != "file" {
+			return nil, fmt.Errorf("%w : %v", errDirScheme, redact(root))
+		}
+
+		files, err := l.walkDir(root.Path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory %v: %w", root.Path, err)
+		}
+
+		for _, file := range files {
+			u := *root
+			u.Path = file
+			u.RawPath = ""
+
+			if knownExtension(file) {
+				urls = append(urls, &u)
+
+				continue
+			}
+
+			if l.failUnknownFiles {
+				return nil, fmt.Errorf("%w : %v", errUnknownExtension, file)
+			}
+
+			l.warn(WarnUnknownFileSkipped, &u, "the file was skipped as it is not of a known format")
+		}
+	}
+
+	return urls, nil
+}
+
+// walkDir returns the url paths of the files in the directory, which is a url path, and its subdirectories.
+func (l *loader) walkDir(dir string) ([]string, error) {
+	var files []string
+
+	if l.fsys != nil {
+		err := fs.WalkDir(l.fsys, fsPath(dir), func(name string, d fs.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				files = append(files, "/"+name)
+			}
+
+			return err
+		})
+
+		return files, err
+	}
+
+	err := filepath.WalkDir(getPath(dir), func(name string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			files = append(files, setPath(filepath.ToSlash(name)))
+		}
+
+		return err
+	})
+
+	return files, err
+}
+
+func knownExtension(file string) bool {
+	ext := strings.ToLower(path.Ext(file))
+	_, ok := Unmarshallers[ext]
+
+	return ok && ext != ""
+}
@@ -0,0 +1,99 @@
+package conflate
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDir(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		assert.Nil(t, os.MkdirAll(filepath.Dir(file), 0o700))
+		assert.Nil(t, os.WriteFile(file, []byte(data), 0o600))
+	}
+
+	return dir
+}
+
+func TestLoader_DirURLs(t *testing.T) {
+	dir := testDir(t, map[string]string{
+		"10-base.json":  `{}`,
+		"a/b.yaml":      ``,
+		"a/sub/c.TOML":  ``,
+		"a-c.json":      `{}`,
+		"README.md":     `# notes`,
+		"z/.editor.swp": ``,
+	})
+
+	l := loader{}
+
+	urls, err := l.dirURLs(dir)
+	assert.Nil(t, err)
+
+	var names []string
+	for _, u := range urls {
+		assert.Equal(t, "file", u.Scheme)
+		names = append(names, path.Base(u.Path))
+	}
+
+	assert.Equal(t, []string{"10-base.json", "b.yaml", "c.TOML", "a-c.json"}, names)
+
+	l.failUnknownFiles = true
+
+	_, err = l.dirURLs(dir)
+	assert.ErrorIs(t, err, errUnknownExtension)
+	assert.Contains(t, err.Error(), "README.md")
+}
+
+func TestLoader_DirURLsErrors(t *testing.T) {
+	l := loader{}
+
+	_, err := l.dirURLs("https://config.test/conf.d")
+	assert.ErrorIs(t, err, errDirScheme)
+
+	_, err = l.dirURLs(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestConflate_AddDir(t *testing.T) {
+	dir := testDir(t, map[string]string{
+		"10-base.json":     `{"x": "1", "y": "1", "z": "1"}`,
+		"20-app/a.yaml":    `x: "2"`,
+		"20-app/b.toml":    `y = "2"`,
+		"30-local.json":    `{"y": "3"}`,
+		"40-ignored.notes": `not config`,
+	})
+
+	c, err := FromDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": "2", "y": "3", "z": "1"}, c.Data())
+	assert.Len(t, c.Sources(), 4)
+
+	c = New()
+	c.FailUnknownFiles(true)
+	assert.ErrorIs(t, c.AddDir(dir), errUnknownExtension)
+	assert.Nil(t, c.Data())
+}
+
+func TestConflate_AddDirFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"conf.d/10-base.json":  {Data: []byte(`{"x": 1, "y": 1}`)},
+		"conf.d/20-over.yaml":  {Data: []byte(`x: 2`)},
+		"other/30-other.json":  {Data: []byte(`{"y": 3}`)},
+		"conf.d/sub/30-y.json": {Data: []byte(`{"y": 2}`)},
+	}
+
+	c := New(WithFS(fsys))
+
+	err := c.AddDir("conf.d")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 2.0, "y": 2.0}, c.Data())
+	assert.Equal(t, "/conf.d/sub/30-y.json", c.Sources()[2].Path)
+}
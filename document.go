@@ -0,0 +1,57 @@
+package conflate
+
+import (
+	gocontext "context"
+	"net/url"
+)
+
+// Document is the data decoded from a single source, before it is merged with any other.
+type Document struct {
+	// Source is the url the data was loaded from, which is nil for data that was not loaded from a url.
+	Source *url.URL
+	// Data is the decoded data, without its includes.
+	Data map[string]interface{}
+	// Items is the decoded data of a document that is a top level array, in place of Data.
+	Items []interface{}
+}
+
+// LoadDocuments loads the data from the given files and their includes in the same way as AddFiles, but returns
+// the data of each source separately rather than merging it, in the order it would be merged.
+// The data and sources of the Conflate instance are not changed, though any warnings from loading are added to it.
+func (c *Conflate) LoadDocuments(paths ...string) ([]Document, error) {
+	urls, err := c.loader.toURLs(nil, paths...)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.LoadDocumentURLs(c.ctx, urls...)
+}
+
+// LoadDocumentURLs loads the data from the given urls and their includes, returning the data of each source
+// separately as for LoadDocuments. Remote fetches are aborted when the context is done.
+func (c *Conflate) LoadDocumentURLs(ctx gocontext.Context, urls ...*url.URL) ([]Document, error) {
+	// loading changes the state of the loader, even though the data is unchanged
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.loader.close()
+
+	sources := c.loader.sources
+	defer func() { c.loader.sources = sources }()
+
+	fdata, err := c.loader.loadURLsRecursive(ctx, nil, urls...)
+	if err != nil {
+		return nil, err
+	}
+
+	return fdata.documents(), nil
+}
+
+func (fds filedatas) documents() []Document {
+	docs := make([]Document, 0, len(fds))
+
+	for i := range fds {
+		docs = append(docs, Document{Source: fds[i].source(), Data: fds[i].obj, Items: fds[i].items})
+	}
+
+	return docs
+}
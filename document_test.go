@@ -0,0 +1,62 @@
+package conflate
+
+import (
+	gocontext "context"
+	"net/url"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflate_LoadDocuments(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json":  {Data: []byte(`{"includes": ["child.yaml"], "a": 1}`)},
+		"child.yaml": {Data: []byte("a: 2\nb: 2\n")},
+		"other.toml": {Data: []byte("a = 3\n")},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddData([]byte(`{"x": 1}`)))
+
+	docs, err := c.LoadDocuments("base.json", "other.toml")
+	assert.Nil(t, err)
+	assert.Len(t, docs, 3)
+
+	var paths []string
+	for _, doc := range docs {
+		paths = append(paths, doc.Source.Path)
+	}
+
+	assert.Equal(t, []string{"/child.yaml", "/base.json", "/other.toml"}, paths)
+	assert.Equal(t, map[string]interface{}{"a": 2.0, "b": 2.0}, docs[0].Data)
+	assert.Equal(t, map[string]interface{}{"a": 1.0}, docs[1].Data)
+	assert.Equal(t, map[string]interface{}{"a": int64(3)}, docs[2].Data)
+
+	// the data is not merged
+	assert.Equal(t, map[string]interface{}{"x": 1.0}, c.Data())
+	assert.Empty(t, c.Sources())
+
+	_, err = c.LoadDocuments("missing.json")
+	assert.NotNil(t, err)
+}
+
+func TestConflate_LoadDocumentURLs(t *testing.T) {
+	c := New(WithFS(fstest.MapFS{"a.json": {Data: []byte(`{"a": 1}`)}}))
+
+	u, err := url.Parse("file:///a.json")
+	assert.Nil(t, err)
+
+	docs, err := c.LoadDocumentURLs(gocontext.Background(), u)
+	assert.Nil(t, err)
+	assert.Equal(t, []Document{{Source: u, Data: map[string]interface{}{"a": 1.0}}}, docs)
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	cancel()
+
+	u, err = url.Parse("http://config.test/a.json")
+	assert.Nil(t, err)
+
+	_, err = c.LoadDocumentURLs(ctx, u)
+	assert.ErrorIs(t, err, gocontext.Canceled)
+}
@@ -0,0 +1,159 @@
+package conflate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DotenvDelimiter is used to split the keys of dotenv data into nested objects, e.g. "__" makes DB__HOST=x into
+// {"DB": {"HOST": "x"}}. Keys are not split when it is blank, which is the default.
+var DotenvDelimiter = ""
+
+// DotenvInferScalars decodes the unquoted values of dotenv data that are JSON numbers or booleans, e.g. 10 or true,
+// as such, as is always done for properties data, so that e.g. PORT=10 can override {"PORT": 8080} of another source.
+// Quoted values are always strings.
+var DotenvInferScalars = false
+
+var (
+	errDotenvLine  = errors.New("expected KEY=VALUE")
+	errDotenvQuote = errors.New("unterminated quoted value")
+	errKeyConflict = errors.New("key conflicts with another key")
+)
+
+// DotenvUnmarshal unmarshals the data as KEY=VALUE lines, as found in .env files.
+// Lines may start with 'export', values may be single or double quoted, and '#' starts a comment.
+// All values are strings unless DotenvInferScalars is set, so they can only override the string values of other
+// sources, as merging a string over a number or boolean is an error.
+func DotenvUnmarshal(data []byte, out interface{}) error {
+	obj, err := dotenvParse(data)
+	if err != nil {
+		return fmt.Errorf("the data could not be unmarshalled as dotenv: %w", err)
+	}
+
+	return jsonMarshalUnmarshal(obj, out)
+}
+
+func dotenvParse(data []byte) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		text = strings.TrimSpace(strings.TrimPrefix(text, "export "))
+
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%w : line %v", errDotenvLine, line)
+		}
+
+		value = strings.TrimSpace(value)
+		quoted := value != "" && (value[0] == '"' || value[0] == '\'')
+
+		value, err := dotenvValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w : line %v", err, line)
+		}
+
+		var scalar interface{} = value
+		if DotenvInferScalars && !quoted {
+			scalar = inferScalar(value)
+		}
+
+		names := []string{key}
+		if DotenvDelimiter != "" {
+			names = strings.Split(key, DotenvDelimiter)
+		}
+
+		err = setNested(obj, names, scalar)
+		if err != nil {
+			return nil, fmt.Errorf("%w : line %v : %v", err, line, key)
+		}
+	}
+
+	return obj, scanner.Err()
+}
+
+func dotenvValue(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+
+	switch quote := value[0]; quote {
+	case '\'':
+		end := strings.IndexByte(value[1:], quote)
+		if end < 0 {
+			return "", errDotenvQuote
+		}
+
+		return value[1 : end+1], nil
+	case '"':
+		var sb strings.Builder
+
+		for i := 1; i < len(value); i++ {
+			switch c := value[i]; {
+			case c == quote:
+				return sb.String(), nil
+			case c == '\\' && i+1 < len(value):
+				i++
+				sb.WriteString(dotenvEscape(value[i]))
+			default:
+				sb.WriteByte(c)
+			}
+		}
+
+		return "", errDotenvQuote
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+
+		return strings.TrimSpace(value), nil
+	}
+}
+
+func dotenvEscape(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	default:
+		return string(c)
+	}
+}
+
+// setNested sets the value in the object at the path given by the names, creating any intermediate objects.
+func setNested(obj map[string]interface{}, names []string, value interface{}) error {
+	for _, name := range names[:len(names)-1] {
+		if obj[name] == nil {
+			obj[name] = map[string]interface{}{}
+		}
+
+		child, ok := obj[name].(map[string]interface{})
+		if !ok {
+			return errKeyConflict
+		}
+
+		obj = child
+	}
+
+	name := names[len(names)-1]
+	if _, ok := obj[name].(map[string]interface{}); ok {
+		return errKeyConflict
+	}
+
+	obj[name] = value
+
+	return nil
+}
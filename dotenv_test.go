@@ -0,0 +1,91 @@
+package conflate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDotenvUnmarshal(t *testing.T) {
+	var out interface{}
+
+	err := DotenvUnmarshal([]byte(`
+# comment
+HOST=example.com
+export PORT = 8080
+EMPTY=
+DOUBLE="a \"quoted\" value\n" # comment
+SINGLE='no $escapes\n'
+UNQUOTED=some value # comment
+`), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"HOST":     "example.com",
+		"PORT":     "8080",
+		"EMPTY":    "",
+		"DOUBLE":   "a \"quoted\" value\n",
+		"SINGLE":   `no $escapes\n`,
+		"UNQUOTED": "some value",
+	}, out)
+}
+
+func TestDotenvUnmarshal_Delimiter(t *testing.T) {
+	DotenvDelimiter = "__"
+	defer func() { DotenvDelimiter = "" }()
+
+	var out interface{}
+
+	err := DotenvUnmarshal([]byte("DB__HOST=a\nDB__PORT=1\nNAME=x\n"), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"DB":   map[string]interface{}{"HOST": "a", "PORT": "1"},
+		"NAME": "x",
+	}, out)
+}
+
+func TestDotenvUnmarshal_InferScalars(t *testing.T) {
+	DotenvInferScalars = true
+	defer func() { DotenvInferScalars = false }()
+
+	fsys := fstest.MapFS{
+		"base.json": {Data: []byte(`{"PORT": 8080, "DEBUG": false, "NAME": "app", "VERSION": "1"}`)},
+		"local.env": {Data: []byte("PORT=10\nDEBUG=true\nNAME=01\nVERSION=\"2\"\n")},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddFiles("base.json", "local.env"))
+	assert.Equal(t, map[string]interface{}{"PORT": 10.0, "DEBUG": true, "NAME": "01", "VERSION": "2"}, c.Data())
+
+	DotenvInferScalars = false
+
+	c = New(WithFS(fsys))
+	err := c.AddFiles("base.json", "local.env")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "must be the same as the source type (string)")
+}
+
+func TestDotenvUnmarshal_Errors(t *testing.T) {
+	DotenvDelimiter = "__"
+	defer func() { DotenvDelimiter = "" }()
+
+	tests := []struct {
+		data, msg string
+	}{
+		{"A=1\nNOEQUALS\n", "expected KEY=VALUE : line 2"},
+		{"=1", "expected KEY=VALUE : line 1"},
+		{`A="open`, "unterminated quoted value : line 1"},
+		{`A='open`, "unterminated quoted value : line 1"},
+		{"A=1\nA__B=2", "key conflicts with another key : line 2 : A__B"},
+		{"A__B=2\nA=1", "key conflicts with another key : line 2 : A"},
+	}
+
+	for _, tt := range tests {
+		var out interface{}
+
+		err := DotenvUnmarshal([]byte(tt.data), &out)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "could not be unmarshalled as dotenv")
+		assert.Contains(t, err.Error(), tt.msg)
+	}
+}
@@ -0,0 +1,222 @@
+package conflate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// modernSchemaURL is the url the schema is compiled at by jsonschema, which resolves the references within it.
+// The documents it references have absolute urls, so it is never used to resolve them.
+const modernSchemaURL = "file:///conflate-schema.json"
+
+// compiledSchema is a schema compiled as a draft. Drafts 4, 6 and 7 are compiled by gojsonschema, while 2019-09 and
+// 2020-12, which it does not support, are compiled by jsonschema.
+type compiledSchema interface {
+	validate(data interface{}, schema interface{}, refs map[string]interface{}) error
+}
+
+type legacyCompiled struct {
+	s *gojsonschema.Schema
+}
+
+func (c *legacyCompiled) validate(data, _ interface{}, _ map[string]interface{}) error {
+	formatErrs.begin()
+	defer formatErrs.end()
+
+	result, err := c.s.Validate(gojsonschema.NewGoLoader(data))
+	if err != nil {
+		return fmt.Errorf("an error occurred during validation: %w", err)
+	}
+
+	return processResult(result)
+}
+
+type modernCompiled struct {
+	s *jsonschema.Schema
+}
+
+func (c *modernCompiled) validate(data, schema interface{}, refs map[string]interface{}) error {
+	// the validator only accepts the types that JSON is decoded to
+	var doc interface{}
+
+	err := jsonMarshalUnmarshal(data, &doc)
+	if err != nil {
+		return fmt.Errorf("an error occurred during validation: %w", err)
+	}
+
+	formatErrs.begin()
+	defer formatErrs.end()
+
+	err = c.s.Validate(doc)
+	if err == nil {
+		return nil
+	}
+
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return fmt.Errorf("an error occurred during validation: %w", err)
+	}
+
+	return processModernResult(verr, doc, schema, refs)
+}
+
+// declaredDraft returns the draft declared by the $schema of the schema, or DraftAuto when it is not one
+// of the drafts.
+func declaredDraft(schema interface{}) SchemaDraft {
+	m, ok := schema.(map[string]interface{})
+	if !ok {
+		return DraftAuto
+	}
+
+	declared, ok := m[keySchema].(string)
+	if !ok {
+		return DraftAuto
+	}
+
+	for d, draft := range schemaDrafts {
+		if strings.TrimSuffix(declared, "#") == strings.TrimSuffix(draft.url, "#") {
+			return d
+		}
+	}
+
+	return DraftAuto
+}
+
+// mismatchedDraft returns the $schema of the schema when it declares a draft other than the draft chosen.
+func (s *Schema) mismatchedDraft(draft SchemaDraft) (string, bool) {
+	chosen, ok := schemaDrafts[draft]
+	if s == nil || !ok {
+		return "", false
+	}
+
+	m, _ := s.s.(map[string]interface{})
+
+	declared, ok := m[keySchema].(string)
+	if !ok || strings.TrimSuffix(declared, "#") == strings.TrimSuffix(chosen.url, "#") {
+		return "", false
+	}
+
+	return declared, true
+}
+
+// isModern reports whether the draft is compiled by jsonschema.
+func (d SchemaDraft) isModern() bool {
+	return schemaDrafts[d].modern != nil
+}
+
+// compileModern compiles the schema as the draft with jsonschema, which validates it against the meta-schema of
+// the draft, whatever its $schema.
+func compileModern(schema interface{}, refs map[string]interface{}, d SchemaDraft) (*jsonschema.Schema, error) {
+	draft := schemaDrafts[d]
+
+	if m, ok := schema.(map[string]interface{}); ok {
+		forced := make(map[string]interface{}, len(m))
+		for key, val := range m {
+			forced[key] = val
+		}
+
+		forced[keySchema] = draft.url
+		schema = forced
+	}
+
+	c := jsonschema.NewCompiler()
+	c.Draft = draft.modern
+	c.AssertFormat = true
+
+	resources := map[string]interface{}{modernSchemaURL: schema}
+	for u, doc := range refs {
+		resources[u] = doc
+	}
+
+	for u, doc := range resources {
+		addFormatCheckers(c, doc)
+
+		data, err := jsonMarshal(doc)
+		if err == nil {
+			err = c.AddResource(u, bytes.NewReader(data))
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("could not add schema %v: %w", u, err)
+		}
+	}
+
+	compiled, err := c.Compile(modernSchemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("the schema could not be compiled as %v: %w", d, err)
+	}
+
+	return compiled, nil
+}
+
+// addFormatCheckers makes the compiler check the formats used by the schema that have a checker in the same way as
+// for the earlier drafts.
+func addFormatCheckers(c *jsonschema.Compiler, schema interface{}) {
+	for _, name := range schemaFormats(schema) {
+		if hasFormatChecker(name) {
+			name := name
+			c.Formats[name] = func(v interface{}) bool { return gojsonschema.FormatCheckers.IsFormat(name, v) }
+		}
+	}
+}
+
+// processModernResult converts each failed check of the error from jsonschema to a SchemaError.
+func processModernResult(result *jsonschema.ValidationError, data, schema interface{}, refs map[string]interface{}) error {
+	verr := &ValidationError{}
+
+	for _, leaf := range validationLeaves(result, nil) {
+		ctx := context(rootContext().String() + leaf.InstanceLocation)
+		value, _ := lookupPointer(data, leaf.InstanceLocation)
+
+		constraint := leaf.KeywordLocation[strings.LastIndexByte(leaf.KeywordLocation, '/')+1:]
+		constraint, _ = unescapePointerToken(constraint)
+
+		serr := &SchemaError{
+			Pointer:     ctx.pointer(),
+			Constraint:  constraint,
+			Value:       value,
+			Description: leaf.Message,
+			context:     ctx,
+		}
+
+		if constraint == "format" {
+			serr.cause = formatErrs.get(keywordValue(leaf.AbsoluteKeywordLocation, schema, refs), value)
+		}
+
+		verr.errs = append(verr.errs, serr)
+	}
+
+	return verr
+}
+
+// validationLeaves returns the errors of the failed checks, which are those without causes.
+func validationLeaves(err *jsonschema.ValidationError, leaves []*jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return append(leaves, err)
+	}
+
+	for _, cause := range err.Causes {
+		leaves = validationLeaves(cause, leaves)
+	}
+
+	return leaves
+}
+
+// keywordValue returns the value of the keyword at the absolute location within the schema or its refs.
+func keywordValue(location string, schema interface{}, refs map[string]interface{}) interface{} {
+	doc, pointer, _ := strings.Cut(location, "#")
+
+	root := schema
+	if doc != modernSchemaURL {
+		root = refs[doc]
+	}
+
+	val, _ := lookupPointer(root, pointer)
+
+	return val
+}
@@ -0,0 +1,210 @@
+package conflate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+var errDuplicateKey = errors.New("duplicate key")
+
+// duplicate is an object key that appears more than once in the same object, with the position of its second use
+// where it is known.
+type duplicate struct {
+	pointer string
+	line    int
+	col     int
+}
+
+// checkDuplicateKeys returns an error for the first object key in the data that appears more than once in the same
+// object. Only JSON, JSONC and YAML are checked, as the other formats either reject duplicate keys themselves,
+// or have no nesting of objects. Data that the format cannot scan has already failed to unmarshal, so is not checked.
+func (fd *filedata) checkDuplicateKeys() error {
+	ext := fd.format()
+	if _, ok := Unmarshallers[ext]; !ok || ext == "" {
+		ext = sniffExtension(fd.data)
+	}
+
+	var (
+		dup *duplicate
+		err error
+	)
+
+	switch ext {
+	case ".json", ".jsn":
+		dup, err = jsonDuplicateKey(fd.data)
+	case ".jsonc", ".json5":
+		dup, err = jsoncDuplicateKey(fd.data)
+	case ".yaml", ".yml":
+		dup, err = yamlDuplicateKey(fd.data)
+	}
+
+	if err != nil || dup == nil {
+		return nil
+	}
+
+	return &ParseError{URL: fd.source(), Line: dup.line, Col: dup.col, err: fmt.Errorf("%w %v", errDuplicateKey, dup.pointer)}
+}
+
+func jsonDuplicateKey(data []byte) (*duplicate, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	return jsonDuplicateValue(dec, data, "")
+}
+
+// jsoncDuplicateKey checks the data once it is rewritten as standard JSON, where the positions no longer match
+// the original data, so none are given.
+func jsoncDuplicateKey(data []byte) (*duplicate, error) {
+	stripped, err := jsoncStripComments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := jsoncNormalize(stripped)
+	if err != nil {
+		return nil, err
+	}
+
+	dup, err := jsonDuplicateKey(normalized)
+	if dup != nil {
+		dup.line, dup.col = 0, 0
+	}
+
+	return dup, err
+}
+
+// jsonDuplicateValue scans the next value from the decoder for duplicate keys.
+func jsonDuplicateValue(dec *json.Decoder, data []byte, pointer string) (*duplicate, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		return jsonDuplicateObject(dec, data, pointer)
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			dup, err := jsonDuplicateValue(dec, data, pointer+"/"+strconv.Itoa(i))
+			if dup != nil || err != nil {
+				return dup, err
+			}
+		}
+
+		_, err = dec.Token()
+
+		return nil, err
+	default:
+		return nil, nil
+	}
+}
+
+func jsonDuplicateObject(dec *json.Decoder, data []byte, pointer string) (*duplicate, error) {
+	seen := map[string]bool{}
+
+	for dec.More() {
+		offset := dec.InputOffset()
+
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		key, _ := tok.(string)
+		child := pointer + "/" + escapePointerToken(key)
+
+		if seen[key] {
+			// the offset is before any separator and whitespace preceding the key
+			for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,"), data[offset]) >= 0 {
+				offset++
+			}
+
+			line, col := offsetPosition(data, offset)
+
+			return &duplicate{pointer: child, line: line, col: col}, nil
+		}
+
+		seen[key] = true
+
+		dup, err := jsonDuplicateValue(dec, data, child)
+		if dup != nil || err != nil {
+			return dup, err
+		}
+	}
+
+	_, err := dec.Token()
+
+	return nil, err
+}
+
+// yamlDuplicateKey checks each document in the YAML stream in turn, as keys are only duplicates within a document.
+func yamlDuplicateKey(data []byte) (*duplicate, error) {
+	dec := yaml3.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var doc yaml3.Node
+
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if dup := yamlDuplicateNode(&doc, ""); dup != nil {
+			return dup, nil
+		}
+	}
+}
+
+func yamlDuplicateNode(node *yaml3.Node, pointer string) *duplicate {
+	//nolint:exhaustive // scalars and aliases have no keys of their own
+	switch node.Kind {
+	case yaml3.DocumentNode:
+		for _, child := range node.Content {
+			if dup := yamlDuplicateNode(child, pointer); dup != nil {
+				return dup
+			}
+		}
+	case yaml3.SequenceNode:
+		for i, child := range node.Content {
+			if dup := yamlDuplicateNode(child, pointer+"/"+strconv.Itoa(i)); dup != nil {
+				return dup
+			}
+		}
+	case yaml3.MappingNode:
+		return yamlDuplicateMapping(node, pointer)
+	}
+
+	return nil
+}
+
+func yamlDuplicateMapping(node *yaml3.Node, pointer string) *duplicate {
+	seen := map[string]bool{}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, val := node.Content[i], node.Content[i+1]
+		child := pointer + "/" + escapePointerToken(key.Value)
+
+		// merge keys can be repeated to merge several mappings
+		if seen[key.Value] && key.Value != "<<" {
+			return &duplicate{pointer: child, line: key.Line, col: key.Column}
+		}
+
+		seen[key.Value] = true
+
+		if dup := yamlDuplicateNode(val, child); dup != nil {
+			return dup
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package conflate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFiledata_CheckDuplicateKeys(t *testing.T) {
+	for path, tc := range map[string]struct {
+		data      string
+		pointer   string
+		line, col int
+	}{
+		"dup.json":   {data: "{\n  \"db\": {\n    \"host\": \"a\",\n    \"host\": \"b\"\n  }\n}", pointer: "/db/host", line: 4, col: 5},
+		"array.json": {data: `{"a": [{"b": 1}, {"c/d": 1, "c/d": 2}]}`, pointer: "/a/1/c~1d", line: 1, col: 29},
+		"dup.yaml":   {data: "db:\n  host: a\n  port: 1\n  host: b\n", pointer: "/db/host", line: 4, col: 3},
+		"list.yml":   {data: "items:\n- a: 1\n- a: 1\n  a: 2\n", pointer: "/items/1/a", line: 4, col: 3},
+		"dup.jsonc":  {data: "{\n  // comment\n  \"a\": 1,\n  \"a\": 2,\n}", pointer: "/a"},
+	} {
+		fd := testFiledataNewAssert(t, []byte(tc.data), "file:///conf/"+path)
+		err := fd.checkDuplicateKeys()
+
+		var perr *ParseError
+
+		assert.ErrorIs(t, err, errDuplicateKey, path)
+		assert.ErrorAs(t, err, &perr, path)
+		assert.Equal(t, "/conf/"+path, perr.URL.Path, path)
+		assert.Equal(t, tc.line, perr.Line, path)
+		assert.Equal(t, tc.col, perr.Col, path)
+		assert.Contains(t, err.Error(), "duplicate key "+tc.pointer, path)
+	}
+}
+
+func TestFiledata_CheckDuplicateKeysNone(t *testing.T) {
+	for path, data := range map[string]string{
+		"unique.json": `{"a": {"b": 1}, "b": {"b": 2}, "c": [{"a": 1}, {"a": 2}]}`,
+		"unique.yaml": "a:\n  b: 1\nb:\n  b: 2\n",
+		"merge.yaml":  "x: &x {a: 1}\ny: &y {b: 1}\nz:\n  <<: *x\n  <<: *y\n",
+		"docs.yaml":   "a: 1\n---\na: 2\n",
+		"keys.toml":   "a = 1\n[b]\na = 2\n",
+		"keys.env":    "A=1\nA=2\n",
+		"values.json": `{"a": ["a", "a"]}`,
+	} {
+		fd := testFiledataNewAssert(t, []byte(data), "file:///conf/"+path)
+		assert.Nil(t, fd.checkDuplicateKeys(), path)
+	}
+}
+
+func TestConflate_FailDuplicateKeys(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json":     {Data: []byte(`{"includes": ["override.yaml"], "b": "base"}`)},
+		"override.yaml": {Data: []byte("a: one\na: two\n")},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddFiles("base.json"))
+	assert.Equal(t, map[string]interface{}{"a": "two", "b": "base"}, c.data)
+
+	c = New(WithFS(fsys))
+	c.FailDuplicateKeys(true)
+
+	err := c.AddFiles("base.json")
+
+	var perr *ParseError
+
+	assert.ErrorIs(t, err, errDuplicateKey)
+	assert.ErrorAs(t, err, &perr)
+	assert.Equal(t, "/override.yaml", perr.URL.Path)
+	assert.Equal(t, 2, perr.Line)
+
+	// the same key in different sources is merged as usual
+	c = New(WithFS(fsys))
+	c.FailDuplicateKeys(true)
+	assert.Nil(t, c.AddData([]byte(`{"a": 1}`), []byte(`{"a": 2}`)))
+
+	err = c.AddData([]byte(`{"a": 1, "a": 2}`))
+	assert.ErrorIs(t, err, errDuplicateKey)
+}
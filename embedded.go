@@ -0,0 +1,89 @@
+package conflate
+
+import "fmt"
+
+// SchemaPrecedence decides which schema is used when the data embeds a schema, see EmbeddedSchema, and another
+// schema is given to Validate or set by WithSchema.
+type SchemaPrecedence int
+
+const (
+	// PreferExternalSchema uses the schema given to Validate or set by WithSchema, and the embedded schema only when
+	// there is no other.
+	PreferExternalSchema SchemaPrecedence = iota
+	// PreferEmbeddedSchema uses the embedded schema, and the other schema only when the data embeds none.
+	PreferEmbeddedSchema
+)
+
+// EmbeddedSchema is an option to take a schema embedded in the data under the top level key, e.g. "$config-schema",
+// which is then used by Validate and ApplyDefaults along with the schema given to them, as decided by the precedence.
+// The key is removed from the merged data as each source is merged, so it is not validated or exported, and
+// the schemas embedded by each source are merged in turn, as the data is. It applies to data added after it is set.
+func (c *Conflate) EmbeddedSchema(key string, precedence SchemaPrecedence) {
+	c.schemaKey = key
+	c.schemaPrecedence = precedence
+}
+
+// extractSchema moves the schema embedded under the schema key of the merged data to the embedded schema,
+// merging it into the schema embedded by the data merged before.
+func (c *Conflate) extractSchema() error {
+	if c.schemaKey == "" {
+		return nil
+	}
+
+	if base, ok := c.base.(map[string]interface{}); ok {
+		delete(base, c.schemaKey)
+	}
+
+	props, ok := c.data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	schema, ok := props[c.schemaKey]
+	if !ok {
+		return nil
+	}
+
+	delete(props, c.schemaKey)
+	merger{provenance: c.provenance, positions: c.positions}.forget(rootContext().add(c.schemaKey))
+
+	err := merge(&c.embeddedSchema, schema)
+	if err != nil {
+		return fmt.Errorf("the embedded schema could not be merged: %w", err)
+	}
+
+	return nil
+}
+
+// schemaOr returns the schema to use in place of the external schema, which is the schema set by WithSchema when s
+// is nil, or the embedded schema as decided by the precedence. The embedded schema is only created again when its
+// content changes, so that it is compiled once, as other schemas are.
+func (c *Conflate) schemaOr(s *Schema) (*Schema, error) {
+	if s == nil {
+		s = c.schema
+	}
+
+	if c.embeddedSchema == nil || (s != nil && c.schemaPrecedence == PreferExternalSchema) {
+		return s, nil
+	}
+
+	content, err := jsonMarshal(c.embeddedSchema)
+	if err != nil {
+		return nil, fmt.Errorf("the embedded schema is not valid: %w", err)
+	}
+
+	if c.embeddedCompiled != nil && c.embeddedJSON == string(content) {
+		return c.embeddedCompiled, nil
+	}
+
+	// the embedded schema is merged in place as data is added, so the schema is created from a copy
+	embedded, err := NewSchemaGo(copyValue(c.embeddedSchema, true))
+	if err != nil {
+		return nil, fmt.Errorf("the embedded schema is not valid: %w", err)
+	}
+
+	c.embeddedCompiled = embedded
+	c.embeddedJSON = string(content)
+
+	return embedded, nil
+}
@@ -0,0 +1,88 @@
+package conflate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflate_EmbeddedSchema(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json": {Data: []byte(`{
+			"$config-schema": {"type": "object", "properties": {"port": {"type": "integer"}, "timeout": {"type": "integer"}}},
+			"port": 80
+		}`)},
+		"local.yaml": {Data: []byte("includes: [base.json]\n$config-schema:\n  required: [name]\nname: app\n")},
+	}
+
+	c := New(WithFS(fsys), WithEmbeddedSchema("$config-schema", PreferExternalSchema))
+	c.TrackProvenance(true)
+
+	err := c.AddFiles("local.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"port": 80.0, "name": "app"}, c.Data())
+	assert.NotContains(t, c.Provenance(), "/$config-schema/required")
+
+	out, err := c.MarshalJSON()
+	assert.Nil(t, err)
+	assert.NotContains(t, string(out), "config-schema")
+
+	patch, err := c.Patch()
+	assert.Nil(t, err)
+	assert.JSONEq(t, `[{"op": "add", "path": "/name", "value": "app"}]`, string(patch))
+
+	assert.Nil(t, c.Validate(nil))
+
+	embedded := c.embeddedCompiled
+	assert.NotNil(t, embedded)
+	assert.Nil(t, c.Validate(nil))
+	assert.Same(t, embedded, c.embeddedCompiled)
+
+	clone := c.Clone()
+	assert.Nil(t, clone.AddData([]byte(`{"timeout": "soon"}`)))
+	assert.ErrorIs(t, clone.Validate(nil), errInvalidPerSchema)
+
+	clone = c.Clone()
+	assert.Nil(t, clone.AddData([]byte(`{"$config-schema": {"required": ["host"]}}`)))
+	assert.ErrorIs(t, clone.Validate(nil), errInvalidPerSchema)
+	assert.NotSame(t, embedded, clone.embeddedCompiled)
+	assert.Same(t, embedded, c.embeddedCompiled)
+
+	// the embedded schema is taken from the instances merged
+	merged := New()
+	assert.Nil(t, merged.AddData([]byte(`{"timeout": "soon"}`)))
+	assert.ErrorIs(t, merged.Validate(nil), errNotSetSchema)
+	assert.Nil(t, merged.Merge(c))
+	assert.ErrorIs(t, merged.Validate(nil), errInvalidPerSchema)
+
+	c.Reset()
+	assert.ErrorIs(t, c.Validate(nil), errNotSetSchema)
+}
+
+func TestConflate_EmbeddedSchemaPrecedence(t *testing.T) {
+	external, err := NewSchemaData([]byte(`{"properties": {"port": {"type": "string"}}}`))
+	assert.Nil(t, err)
+
+	data := []byte(`{"schema": {"properties": {"port": {"type": "integer"}, "timeout": {"type": "integer"}}}, "port": 80}`)
+
+	c := New(WithSchema(external), WithEmbeddedSchema("schema", PreferExternalSchema))
+	assert.Nil(t, c.AddData(data))
+	assert.ErrorIs(t, c.Validate(nil), errInvalidPerSchema)
+
+	c = New(WithSchema(external), WithEmbeddedSchema("schema", PreferEmbeddedSchema))
+	assert.Nil(t, c.AddData(data))
+	assert.Nil(t, c.Validate(nil))
+	assert.Nil(t, c.Validate(external))
+
+	// the key is merged as data without the option
+	c = New(WithSchema(external))
+	assert.Nil(t, c.AddData(data))
+	assert.Contains(t, c.Data(), "schema")
+
+	c = New(WithEmbeddedSchema("schema", PreferEmbeddedSchema))
+	assert.Nil(t, c.AddData([]byte(`{"schema": {"type": 1}}`)))
+	err = c.Validate(nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "the embedded schema is not valid")
+}
@@ -0,0 +1,61 @@
+package conflate
+
+import (
+	"bytes"
+	"errors"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var (
+	utf8BOM    = []byte("\xef\xbb\xbf")
+	utf16LEBOM = []byte("\xff\xfe")
+	utf16BEBOM = []byte("\xfe\xff")
+)
+
+var (
+	errUTF16    = errors.New("the data is UTF-16, which is only decoded when DecodeUTF16 is on")
+	errOddUTF16 = errors.New("the UTF-16 data has an odd number of bytes")
+)
+
+// stripBOM removes a UTF-8 byte order mark from the start of the data, as the JSON decoder does not accept one.
+// Data starting with a UTF-16 byte order mark is an error, as it has not been decoded.
+func stripBOM(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, utf16LEBOM) || bytes.HasPrefix(data, utf16BEBOM) {
+		return nil, errUTF16
+	}
+
+	return bytes.TrimPrefix(data, utf8BOM), nil
+}
+
+// decodeUTF16 converts data starting with a UTF-16 byte order mark, little or big endian, to UTF-8.
+// Any other data is returned unchanged.
+func decodeUTF16(data []byte) ([]byte, error) {
+	var unit func(first, second byte) uint16
+
+	switch {
+	case bytes.HasPrefix(data, utf16LEBOM):
+		unit = func(first, second byte) uint16 { return uint16(second)<<8 | uint16(first) }
+	case bytes.HasPrefix(data, utf16BEBOM):
+		unit = func(first, second byte) uint16 { return uint16(first)<<8 | uint16(second) }
+	default:
+		return data, nil
+	}
+
+	data = data[2:]
+	if len(data)%2 != 0 {
+		return nil, errOddUTF16
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i < len(data); i += 2 {
+		units = append(units, unit(data[i], data[i+1]))
+	}
+
+	out := make([]byte, 0, len(data))
+	for _, r := range utf16.Decode(units) {
+		out = utf8.AppendRune(out, r)
+	}
+
+	return out, nil
+}
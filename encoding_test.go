@@ -0,0 +1,67 @@
+package conflate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripBOM(t *testing.T) {
+	out, err := stripBOM([]byte("\xef\xbb\xbf{}"))
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(out))
+
+	out, err = stripBOM([]byte("{}"))
+	assert.Nil(t, err)
+	assert.Equal(t, "{}", string(out))
+
+	_, err = stripBOM([]byte("\xff\xfe{\x00}\x00"))
+	assert.ErrorIs(t, err, errUTF16)
+
+	_, err = stripBOM([]byte("\xfe\xff\x00{\x00}"))
+	assert.ErrorIs(t, err, errUTF16)
+}
+
+func TestDecodeUTF16(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{in: "\xff\xfe{\x00\"\x00\xe9\x00\"\x00:\x00 \x00=\xd8\x00\xde}\x00", out: "{\"é\": \U0001F600}"},
+		{in: "\xfe\xff\x00{\x00\"\x00\xe9\x00\"\x00:\x00 \xd8=\xde\x00\x00}", out: "{\"é\": \U0001F600}"},
+		{in: "\xef\xbb\xbf{}", out: "\xef\xbb\xbf{}"},
+		{in: "{}", out: "{}"},
+	}
+
+	for _, tt := range tests {
+		out, err := decodeUTF16([]byte(tt.in))
+		assert.Nil(t, err)
+		assert.Equal(t, tt.out, string(out))
+	}
+
+	_, err := decodeUTF16([]byte("\xff\xfe{"))
+	assert.ErrorIs(t, err, errOddUTF16)
+}
+
+func TestConflate_DecodeUTF16(t *testing.T) {
+	fsys := fstest.MapFS{
+		"utf8.json":  {Data: []byte("\xef\xbb\xbf{\"a\": 1}")},
+		"utf16.json": {Data: []byte("\xff\xfe{\x00\"\x00b\x00\"\x00:\x002\x00}\x00")},
+	}
+
+	c := New(WithFS(fsys))
+
+	err := c.AddFiles("utf8.json")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1.0}, c.Data())
+
+	err = c.AddFiles("utf16.json")
+	assert.ErrorIs(t, err, errUTF16)
+
+	c.DecodeUTF16(true)
+
+	err = c.AddFiles("utf16.json")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1.0, "b": 2.0}, c.Data())
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 )
 
+// context is the location of a value within the data, as "#" followed by its RFC 6901 JSON pointer.
 type context string
 
 type errWithContext struct {
@@ -0,0 +1,187 @@
+package conflate
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var errVarSyntax = errors.New("bad variable substitution")
+
+// lookupEnv returns the value of the variable using the configured lookup function, by default os.LookupEnv.
+func (l *loader) lookupEnv(name string) (string, bool) {
+	if l.lookup == nil {
+		return os.LookupEnv(name)
+	}
+
+	return l.lookup(name)
+}
+
+// expandStrings expands the variables in each string value of the data, as described for expansion.expand.
+// Object keys are left unchanged.
+func (l *loader) expandStrings(ctx context, data interface{}) (interface{}, error) {
+	var err error
+
+	switch node := data.(type) {
+	case string:
+		return l.expandValue(ctx, node)
+	case map[string]interface{}:
+		for name, val := range node {
+			node[name], err = l.expandStrings(ctx.add(name), val)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, item := range node {
+			node[i], err = l.expandStrings(ctx.addInt(i), item)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return data, nil
+}
+
+func (l *loader) expandValue(ctx context, value string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+
+	e := expansion{lookup: l.lookupEnv}
+
+	expanded, err := e.expand(value)
+	if err == nil && l.strictExpand && len(e.undefined) > 0 {
+		err = fmt.Errorf("%w %v", errUndefinedVar, strings.Join(e.undefined, ", "))
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("%w (%v)", err, ctx)
+	}
+
+	return expanded, nil
+}
+
+// expansion expands variables shell style, recording any that are undefined.
+type expansion struct {
+	lookup    func(string) (string, bool)
+	undefined []string
+}
+
+// expand replaces $VAR and ${VAR} with the value of the variable, and $$ with a literal '$'.
+// ${VAR:-default} uses the default when the variable is unset or empty, and ${VAR:?message} is an error with the
+// message when it is. The default may itself contain variables and braces.
+func (e *expansion) expand(s string) (string, error) {
+	var sb strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			sb.WriteByte(s[i])
+
+			continue
+		}
+
+		switch c := s[i+1]; {
+		case c == '$':
+			sb.WriteByte('$')
+			i++
+		case c == '{':
+			end := matchingBrace(s, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("%w : %v", errVarSyntax, s[i:])
+			}
+
+			val, err := e.expandBraced(s[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+
+			sb.WriteString(val)
+			i = end
+		case isVarChar(c):
+			name := varName(s[i+1:])
+			sb.WriteString(e.value(name))
+			i += len(name)
+		default:
+			sb.WriteByte('$')
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// expandBraced expands the contents of ${...}.
+func (e *expansion) expandBraced(content string) (string, error) {
+	name := varName(content)
+	op := content[len(name):]
+
+	if name == "" || (op != "" && !strings.HasPrefix(op, ":-") && !strings.HasPrefix(op, ":?")) {
+		return "", fmt.Errorf("%w : ${%v}", errVarSyntax, content)
+	}
+
+	if op == "" {
+		return e.value(name), nil
+	}
+
+	if val, _ := e.lookup(name); val != "" {
+		return val, nil
+	}
+
+	arg, err := e.expand(op[2:])
+	if err != nil {
+		return "", err
+	}
+
+	if op[1] == '?' {
+		if arg == "" {
+			arg = "not set"
+		}
+
+		return "", fmt.Errorf("%w %v: %v", errUndefinedVar, name, arg)
+	}
+
+	return arg, nil
+}
+
+func (e *expansion) value(name string) string {
+	val, ok := e.lookup(name)
+	if !ok {
+		e.undefined = append(e.undefined, name)
+	}
+
+	return val
+}
+
+// matchingBrace returns the index of the '}' that closes the '{' at start, or -1 if there is none.
+func matchingBrace(s string, start int) int {
+	depth := 0
+
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func varName(s string) string {
+	i := 0
+	for i < len(s) && isVarChar(s[i]) {
+		i++
+	}
+
+	return s[:i]
+}
+
+func isVarChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,101 @@
+package conflate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLookup(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		val, ok := vars[name]
+
+		return val, ok
+	}
+}
+
+func TestLoader_ExpandStrings(t *testing.T) {
+	l := loader{lookup: testLookup(map[string]string{"HOST": "db", "PASSWORD": "secret"})}
+
+	data := map[string]interface{}{
+		"$HOST": "${HOST}:5432",
+		"auth":  map[string]interface{}{"password": "$PASSWORD", "price": "$$5"},
+		"list":  []interface{}{"$HOST", 1.0, true, nil},
+		"unset": "[$MISSING]",
+	}
+
+	out, err := l.expandStrings(rootContext(), data)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"$HOST": "db:5432",
+		"auth":  map[string]interface{}{"password": "secret", "price": "$5"},
+		"list":  []interface{}{"db", 1.0, true, nil},
+		"unset": "[]",
+	}, out)
+}
+
+func TestLoader_ExpandStringsStrict(t *testing.T) {
+	l := loader{lookup: testLookup(map[string]string{}), strictExpand: true}
+
+	_, err := l.expandStrings(rootContext(), map[string]interface{}{"list": []interface{}{"ok", "$A and ${B}"}})
+	assert.ErrorIs(t, err, errUndefinedVar)
+	assert.Contains(t, err.Error(), "A, B")
+	assert.Contains(t, err.Error(), "#/list/1")
+
+	_, err = l.expandStrings(rootContext(), "$$")
+	assert.Nil(t, err)
+}
+
+func TestLoader_LookupEnv(t *testing.T) {
+	t.Setenv("CONFLATE_TEST_VAR", "env")
+
+	var l loader
+
+	val, ok := l.lookupEnv("CONFLATE_TEST_VAR")
+	assert.True(t, ok)
+	assert.Equal(t, "env", val)
+
+	l.lookup = testLookup(map[string]string{})
+
+	_, ok = l.lookupEnv("CONFLATE_TEST_VAR")
+	assert.False(t, ok)
+}
+
+func TestExpansion_Expand(t *testing.T) {
+	e := expansion{lookup: testLookup(map[string]string{"PORT": "80", "EMPTY": "", "HOST": "db"})}
+
+	for in, out := range map[string]string{
+		"${PORT:-8080}":                    "80",
+		"${MISSING:-8080}":                 "8080",
+		"${EMPTY:-8080}":                   "8080",
+		"${MISSING:-http://$HOST:${PORT}}": "http://db:80",
+		"${MISSING:-{\"a\": {}}}":          `{"a": {}}`,
+		"${MISSING:-}":                     "",
+		"${PORT:?required}":                "80",
+		"cost: $ 5, $-":                    "cost: $ 5, $-",
+		"trailing $":                       "trailing $",
+	} {
+		val, err := e.expand(in)
+		assert.Nil(t, err, in)
+		assert.Equal(t, out, val, in)
+	}
+
+	assert.Empty(t, e.undefined)
+}
+
+func TestExpansion_ExpandError(t *testing.T) {
+	e := expansion{lookup: testLookup(map[string]string{"EMPTY": ""})}
+
+	_, err := e.expand("${MISSING:?the port must be set}")
+	assert.ErrorIs(t, err, errUndefinedVar)
+	assert.Contains(t, err.Error(), "MISSING: the port must be set")
+
+	_, err = e.expand("${EMPTY:?}")
+	assert.ErrorIs(t, err, errUndefinedVar)
+	assert.Contains(t, err.Error(), "EMPTY: not set")
+
+	for _, in := range []string{"${UNTERMINATED", "${}", "${A:+x}", "${A-x}", "${MISSING:-${BAD}"} {
+		_, err = e.expand(in)
+		assert.ErrorIs(t, err, errVarSyntax, in)
+	}
+}
@@ -1,24 +1,117 @@
 package conflate
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	pkgurl "net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
 type filedata struct {
-	url      *pkgurl.URL
-	data     []byte
-	obj      map[string]interface{}
-	includes []string
+	url *pkgurl.URL
+	// contentType is the media type the data was served with, if any, which takes precedence over the extension,
+	// or the extension of the format given for the url, e.g. ".yaml", which takes precedence over both
+	contentType string
+	data        []byte
+	obj         map[string]interface{}
+	// items holds the data of a document that is a top level array in place of obj, as it has no includes
+	items    []interface{}
+	includes []include
+	// included is set for the data of an include, rather than of a source added directly
+	included bool
 }
 
 var emptyFiledata = filedata{}
 
+var errUnknownFormat = errors.New("could not unmarshal data of unknown format")
+
+var (
+	// the decoders for yaml, toml and hcl only report the position of an error in the message
+	errLine    = regexp.MustCompile(`\bline (\d+)`)
+	errLineCol = regexp.MustCompile(`\bAt (\d+):(\d+)`)
+	tomlLine   = regexp.MustCompile(`^(\[\[?[\w.\-" ]+\]\]?$|[\w\-"]+\s*=)`)
+	yamlLine   = regexp.MustCompile(`^(---|- |[\w.\-"']+\s*:(\s|$))`)
+)
+
 type filedatas []filedata
 
+// ParseError is returned when the data from a source could not be unmarshalled.
+type ParseError struct {
+	// URL is the url the data was loaded from, which is nil for data that was not loaded from a url.
+	URL *pkgurl.URL
+	// Line and Col give the position of the error, starting from 1, where the decoder reports it, and are 0 otherwise.
+	Line int
+	Col  int
+	err  error
+}
+
+func (e *ParseError) Error() string {
+	source := "data"
+	if e.URL != nil {
+		source = redact(e.URL)
+	}
+
+	switch {
+	case e.Col > 0:
+		source = fmt.Sprintf("%v at line %v, column %v", source, e.Line, e.Col)
+	case e.Line > 0:
+		source = fmt.Sprintf("%v at line %v", source, e.Line)
+	}
+
+	return fmt.Sprintf("error parsing %v: %v", source, e.err.Error())
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.err
+}
+
+func newParseError(url *pkgurl.URL, data []byte, err error) *ParseError {
+	perr := &ParseError{URL: url, err: err}
+
+	var serr *json.SyntaxError
+
+	var terr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &serr):
+		// the offset is just after the invalid character
+		perr.Line, perr.Col = offsetPosition(data, serr.Offset-1)
+	case errors.As(err, &terr):
+		perr.Line, perr.Col = offsetPosition(data, terr.Offset)
+	default:
+		if m := errLineCol.FindStringSubmatch(err.Error()); m != nil {
+			perr.Line, _ = strconv.Atoi(m[1])
+			perr.Col, _ = strconv.Atoi(m[2])
+		} else if m := errLine.FindStringSubmatch(err.Error()); m != nil {
+			perr.Line, _ = strconv.Atoi(m[1])
+		}
+	}
+
+	return perr
+}
+
+// offsetPosition returns the line and column of the byte offset in the data.
+func offsetPosition(data []byte, offset int64) (int, int) {
+	if offset < 0 {
+		offset = 0
+	} else if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+
+	return line, col
+}
+
 // UnmarshallerFunc defines the type of function used for unmarshalling data.
 type UnmarshallerFunc func([]byte, interface{}) error
 
@@ -31,29 +124,75 @@ type UnmarshallerMap map[string]UnmarshallerFuncs
 // Unmarshallers is a list of unmarshalling functions to be used for given file extensions.
 // The unmarshaller slice for the blank file extension is used when no match is found.
 var Unmarshallers = UnmarshallerMap{
-	".json": {JSONUnmarshal},
-	".jsn":  {JSONUnmarshal},
-	".yaml": {YAMLUnmarshal},
-	".yml":  {YAMLUnmarshal},
-	".toml": {TOMLUnmarshal},
-	".tml":  {TOMLUnmarshal},
-	"":      {JSONUnmarshal, YAMLUnmarshal, TOMLUnmarshal},
+	".json":       {JSONUnmarshal},
+	".jsn":        {JSONUnmarshal},
+	".jsonc":      {JSONCUnmarshal},
+	".json5":      {JSONCUnmarshal},
+	".yaml":       {YAMLUnmarshal},
+	".yml":        {YAMLUnmarshal},
+	".toml":       {TOMLUnmarshal},
+	".tml":        {TOMLUnmarshal},
+	".hcl":        {HCLUnmarshal},
+	".env":        {DotenvUnmarshal},
+	".properties": {PropertiesUnmarshal},
+	"":            {JSONUnmarshal, YAMLUnmarshal, TOMLUnmarshal},
+}
+
+// ContentTypes maps the media type of data loaded over http to the file extension used to look up its unmarshallers.
+// Media types without an entry fall back to using the extension of the url.
+var ContentTypes = map[string]string{
+	"application/json":   ".json",
+	"text/json":          ".json",
+	"application/yaml":   ".yaml",
+	"application/x-yaml": ".yaml",
+	"text/yaml":          ".yaml",
+	"text/x-yaml":        ".yaml",
+	"application/toml":   ".toml",
+	"text/x-toml":        ".toml",
+}
+
+// RegisterUnmarshaler sets the function used to unmarshal data for the given file extension, replacing any existing
+// unmarshallers for it. The extension is matched case insensitively, with or without the leading '.'.
+func RegisterUnmarshaler(ext string, fn func([]byte) (map[string]interface{}, error)) {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	Unmarshallers[ext] = UnmarshallerFuncs{
+		func(data []byte, out interface{}) error {
+			obj, err := fn(data)
+			if err != nil {
+				return err
+			}
+
+			return jsonMarshalUnmarshal(obj, out)
+		},
+	}
 }
 
-func newFiledata(data []byte, url *pkgurl.URL) (filedata, error) {
-	fd := filedata{data: data, url: url}
+// newFiledata unmarshals the data, extracting the includes array from the top level key named by includes.
+func newFiledata(data []byte, url *pkgurl.URL, contentType, includes string) (filedata, error) {
+	fd := filedata{url: url, contentType: contentType}
 
-	err := fd.unmarshal()
+	data, err := stripBOM(data)
 	if err != nil {
-		return emptyFiledata, err
+		return emptyFiledata, newParseError(fd.source(), data, err)
+	}
+
+	fd.data = data
+
+	err = fd.unmarshal()
+	if err != nil {
+		return emptyFiledata, newParseError(fd.source(), data, err)
 	}
 
-	err = fd.validate()
+	err = fd.validate(includes)
 	if err != nil {
 		return emptyFiledata, err
 	}
 
-	err = fd.extractIncludes()
+	err = fd.extractIncludes(includes)
 	if err != nil {
 		return emptyFiledata, err
 	}
@@ -61,8 +200,8 @@ func newFiledata(data []byte, url *pkgurl.URL) (filedata, error) {
 	return fd, nil
 }
 
-func newExpandedFiledata(data []byte, url *pkgurl.URL) (filedata, error) {
-	return newFiledata(recursiveExpand(data), url)
+func newExpandedFiledata(data []byte, url *pkgurl.URL, contentType, includes string) (filedata, error) {
+	return newFiledata(recursiveExpand(data), url, contentType, includes)
 }
 
 func (fd *filedata) wrapError(err error) error {
@@ -70,21 +209,63 @@ func (fd *filedata) wrapError(err error) error {
 		return err
 	}
 
-	return fmt.Errorf("error processing %v: %w", fd.url.String(), err)
+	return fmt.Errorf("error processing %v: %w", redact(fd.url), err)
 }
 
-func (fd *filedata) validate() error {
-	return fd.wrapError(validate(fd.obj, getSchema()))
+func (fd *filedata) validate(includes string) error {
+	if fd.items != nil {
+		return nil
+	}
+
+	return fd.wrapError(validate(fd.obj, getSchema(includes)))
 }
 
 func (fd *filedata) unmarshal() error {
-	ext := strings.ToLower(filepath.Ext(fd.url.Path))
+	// an empty document merges to nothing, whatever the format
+	if len(bytes.TrimSpace(bytes.TrimPrefix(fd.data, []byte("\xef\xbb\xbf")))) == 0 {
+		return nil
+	}
+
+	ext := fd.format()
+
+	if unmarshallers, ok := Unmarshallers[ext]; ok && ext != "" {
+		return fd.unmarshalWith(unmarshallers)
+	}
+
+	// try the format the data looks like first, falling back to any format that can read it
+	sniffed := sniffExtension(fd.data)
+	if sniffed == "" {
+		err := fd.unmarshalWith(Unmarshallers[""])
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUnknownFormat, errors.Unwrap(err))
+		}
+
+		return nil
+	}
 
-	unmarshallers, ok := Unmarshallers[ext]
+	err := fd.unmarshalWith(Unmarshallers[sniffed])
+	if err == nil || fd.unmarshalWith(Unmarshallers[""]) == nil {
+		return nil
+	}
+
+	return fmt.Errorf("%w (sniffed %v)", err, strings.TrimPrefix(sniffed, "."))
+}
+
+// format returns the extension of the format of the data, given by its content type or else the extension of its url.
+func (fd *filedata) format() string {
+	if strings.HasPrefix(fd.contentType, ".") {
+		return fd.contentType
+	}
+
+	ext, ok := ContentTypes[fd.contentType]
 	if !ok {
-		unmarshallers = Unmarshallers[""]
+		ext = strings.ToLower(filepath.Ext(fd.url.Path))
 	}
 
+	return ext
+}
+
+func (fd *filedata) unmarshalWith(unmarshallers UnmarshallerFuncs) error {
 	var err error
 
 	for _, unmarshal := range unmarshallers {
@@ -93,23 +274,56 @@ func (fd *filedata) unmarshal() error {
 			return nil
 		}
 
+		// a document can also be a top level array
+		if unmarshal(fd.data, &fd.items) == nil {
+			fd.obj = nil
+
+			return nil
+		}
+
 		err = fmt.Errorf("could not unmarshal data: %w", uerr)
 	}
 
 	return err
 }
 
-func (fd *filedata) extractIncludes() error {
-	if Includes == "" {
+// sniffExtension guesses the format of the data from its first line that is not blank or a comment,
+// returning the extension used for the format, or blank when it is not clear.
+func sniffExtension(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		switch {
+		case tomlLine.MatchString(line):
+			return ".toml"
+		case line[0] == '{' || line[0] == '[':
+			return ".json"
+		case yamlLine.MatchString(line):
+			return ".yaml"
+		default:
+			return ""
+		}
+	}
+
+	return ""
+}
+
+func (fd *filedata) extractIncludes(includes string) error {
+	if includes == "" {
 		return nil
 	}
 
-	err := jsonMarshalUnmarshal(fd.obj[Includes], &fd.includes)
+	err := jsonMarshalUnmarshal(fd.obj[includes], &fd.includes)
 	if err != nil {
 		return fmt.Errorf("could not extract includes: %w", err)
 	}
 
-	delete(fd.obj, Includes)
+	delete(fd.obj, includes)
 
 	return nil
 }
@@ -118,14 +332,35 @@ func (fds filedatas) objs() []interface{} {
 	var objs []interface{}
 
 	for _, fd := range fds {
-		objs = append(objs, fd.obj)
+		objs = append(objs, fd.value())
 	}
 
 	return objs
 }
 
+// source returns the url the data was loaded from, or nil for data that was not loaded from a url.
+func (fd *filedata) source() *pkgurl.URL {
+	if fd.url == nil || *fd.url == emptyURL {
+		return nil
+	}
+
+	return fd.url
+}
+
+// value returns the data of the document, which is either an object or an array, or nil when it is empty.
+func (fd *filedata) value() interface{} {
+	switch {
+	case fd.items != nil:
+		return fd.items
+	case fd.obj != nil:
+		return fd.obj
+	default:
+		return nil
+	}
+}
+
 func (fd *filedata) isEmpty() bool {
-	return fd == nil || fd.obj == nil
+	return fd == nil || fd.value() == nil
 }
 
 func recursiveExpand(b []byte) []byte {
@@ -161,16 +396,31 @@ func expand(b []byte) (result []byte, count int) {
 
 var getSchema = getDefaultSchema
 
-func getDefaultSchema() map[string]interface{} {
+func getDefaultSchema(includes string) map[string]interface{} {
 	return map[string]interface{}{
 		"anyOf": []interface{}{
 			map[string]interface{}{
 				"type": "object",
 				"properties": map[string]interface{}{
-					Includes: map[string]interface{}{
+					includes: map[string]interface{}{
 						"type": "array",
 						"items": map[string]interface{}{
-							"type": "string",
+							"anyOf": []interface{}{
+								map[string]interface{}{
+									"type": "string",
+								},
+								map[string]interface{}{
+									"type": "object",
+									"properties": map[string]interface{}{
+										"url":      map[string]interface{}{"type": "string"},
+										"when":     map[string]interface{}{"type": "string"},
+										"optional": map[string]interface{}{"type": "boolean"},
+										"format":   map[string]interface{}{"type": "string"},
+									},
+									"required":             []interface{}{"url"},
+									"additionalProperties": false,
+								},
+							},
 						},
 					},
 				},
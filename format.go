@@ -8,17 +8,30 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/xeipuuv/gojsonschema"
 	"golang.org/x/net/html"
 )
 
 var (
-	errRequiredString  = errors.New("the value is not a string")
-	errUnsupportedType = errors.New("called with unsupported type")
+	errRequiredString      = errors.New("the value is not a string")
+	errUnsupportedType     = errors.New("called with unsupported type")
+	errUnknownSchemaFormat = errors.New("the schema uses a format with no registered checker")
 )
 
+// FailUnknownFormats makes validation fail when the schema uses a format that has no checker, rather than
+// treating any value as valid for the format.
+var FailUnknownFormats = false
+
+// RegisterFormat adds a checker for the named JSON schema format, replacing any existing checker for it.
+// Values that are not strings do not match the format.
+func RegisterFormat(name string, fn func(string) bool) {
+	gojsonschema.FormatCheckers.Add(newFuncFormatChecker(name, fn))
+}
+
 func initFormatCheckers() {
 	// annoyingly the format checker list is a global variable
 	gojsonschema.FormatCheckers.Add(newXMLFormatChecker("xml"))
@@ -35,19 +48,58 @@ func initFormatCheckers() {
 
 // ----------------
 
+// formatErrors holds the reason each value failed a format check, as the checkers can only report whether it passed.
+// It is shared by the validations in progress, and is cleared before one starts when there are none.
 type formatErrors map[string]error
 
-var formatErrs = formatErrors{}
+var (
+	formatErrs      = formatErrors{}
+	formatErrsMu    sync.Mutex
+	checkingFormats int
+)
+
+func (errs formatErrors) begin() {
+	formatErrsMu.Lock()
+	defer formatErrsMu.Unlock()
+
+	if checkingFormats == 0 {
+		errs.clearLocked()
+	}
+
+	checkingFormats++
+}
+
+func (errs formatErrors) end() {
+	formatErrsMu.Lock()
+	defer formatErrsMu.Unlock()
+
+	checkingFormats--
+}
 
 func (errs formatErrors) clear() {
-	formatErrs = formatErrors{}
+	formatErrsMu.Lock()
+	defer formatErrsMu.Unlock()
+
+	errs.clearLocked()
+}
+
+func (errs formatErrors) clearLocked() {
+	for key := range errs {
+		delete(errs, key)
+	}
 }
 
 func (errs formatErrors) add(name, value interface{}, err error) {
+	formatErrsMu.Lock()
+	defer formatErrsMu.Unlock()
+
 	errs[errs.key(name, value)] = err
 }
 
 func (errs formatErrors) get(name, value interface{}) error {
+	formatErrsMu.Lock()
+	defer formatErrsMu.Unlock()
+
 	return errs[errs.key(name, value)]
 }
 
@@ -253,3 +305,76 @@ func (f regexFormatChecker) IsFormat(input interface{}) bool {
 
 	return true
 }
+
+// ----------------
+
+type funcFormatChecker struct {
+	name string
+	fn   func(string) bool
+}
+
+func newFuncFormatChecker(name string, fn func(string) bool) (string, gojsonschema.FormatChecker) {
+	return name, funcFormatChecker{name: name, fn: fn}
+}
+
+func (f funcFormatChecker) IsFormat(input interface{}) bool {
+	s, ok := input.(string)
+
+	return ok && f.fn(s)
+}
+
+// checkFormatsKnown returns an error for the first format in the schema that has no checker.
+func checkFormatsKnown(schema interface{}) error {
+	for _, name := range schemaFormats(schema) {
+		if !hasFormatChecker(name) {
+			return fmt.Errorf("%w : %v", errUnknownSchemaFormat, name)
+		}
+	}
+
+	return nil
+}
+
+func hasFormatChecker(name string) bool {
+	return gojsonschema.FormatCheckers.Has(name)
+}
+
+// schemaFormats returns the name of each format used in the schema, in sorted order without duplicates.
+func schemaFormats(schema interface{}) []string {
+	names := appendFormats(nil, schema)
+	sort.Strings(names)
+
+	var unique []string
+
+	for _, name := range names {
+		if len(unique) == 0 || name != unique[len(unique)-1] {
+			unique = append(unique, name)
+		}
+	}
+
+	return unique
+}
+
+func appendFormats(names []string, schema interface{}) []string {
+	switch node := schema.(type) {
+	case map[string]interface{}:
+		for key, val := range node {
+			switch key {
+			case "format":
+				if name, ok := val.(string); ok {
+					names = append(names, name)
+				}
+			case "default", "enum", "const", "examples":
+				// these hold data rather than schemas
+				continue
+			}
+
+			names = appendFormats(names, val)
+		}
+	case []interface{}:
+		for _, item := range node {
+			names = appendFormats(names, item)
+		}
+	}
+
+	return names
+}
@@ -2,8 +2,10 @@ package conflate
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xeipuuv/gojsonschema"
 )
 
 const (
@@ -544,3 +546,53 @@ func TestRegexFormatCheckerIsFormat_NotValid(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to parse regular expression")
 }
+
+func TestRegisterFormat(t *testing.T) {
+	RegisterFormat("test-duration", func(s string) bool {
+		_, err := time.ParseDuration(s)
+		return err == nil
+	})
+
+	defer gojsonschema.FormatCheckers.Remove("test-duration")
+
+	s, err := NewSchemaData([]byte(`{"type": "object", "properties": {"timeout": {"format": "test-duration"}}}`))
+	assert.Nil(t, err)
+
+	err = s.Validate(map[string]interface{}{"timeout": "5s"})
+	assert.Nil(t, err)
+
+	err = s.Validate(map[string]interface{}{"timeout": "5 seconds"})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Does not match format 'test-duration'")
+
+	err = s.Validate(map[string]interface{}{"timeout": 5.0})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "Does not match format 'test-duration'")
+}
+
+func TestFailUnknownFormats(t *testing.T) {
+	s, err := NewSchemaData([]byte(`{
+		"type": "object",
+		"default": {"format": "not-a-schema"},
+		"properties": {"version": {"format": "test-semver"}}
+	}`))
+	assert.Nil(t, err)
+
+	data := map[string]interface{}{"version": "anything"}
+
+	err = s.Validate(data)
+	assert.Nil(t, err)
+
+	FailUnknownFormats = true
+	defer func() { FailUnknownFormats = false }()
+
+	err = s.Validate(data)
+	assert.ErrorIs(t, err, errUnknownSchemaFormat)
+	assert.Contains(t, err.Error(), "test-semver")
+
+	RegisterFormat("test-semver", func(s string) bool { return true })
+	defer gojsonschema.FormatCheckers.Remove("test-semver")
+
+	err = s.Validate(data)
+	assert.Nil(t, err)
+}
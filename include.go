@@ -0,0 +1,278 @@
+package conflate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	pkgurl "net/url"
+	"strings"
+	"unicode"
+)
+
+var (
+	errIncludeURL       = errors.New("the include has no url")
+	errIncludeCondition = errors.New("invalid include condition")
+	errIncludeFormat    = errors.New("unknown include format")
+	errIncludeStdin     = errors.New("standard input cannot be included")
+)
+
+// include is an entry of an includes array, which is either the path of the include, or an object holding the path
+// and a condition for loading it, e.g. {"url": "prod.json", "when": "ENV == prod"}.
+// An optional include is skipped when there is nothing at its url, and is marked by starting the path with '?'
+// or with "optional": true. The format, e.g. "yaml", is used in place of the format given by the extension of the url.
+type include struct {
+	URL      string `json:"url"`
+	When     string `json:"when,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+	Format   string `json:"format,omitempty"`
+}
+
+func (inc *include) UnmarshalJSON(data []byte) error {
+	*inc = include{}
+
+	if err := json.Unmarshal(data, &inc.URL); err == nil {
+		if strings.HasPrefix(inc.URL, "?") {
+			inc.URL, inc.Optional = inc.URL[1:], true
+		}
+
+		return nil
+	}
+
+	type object include
+
+	err := json.Unmarshal(data, (*object)(inc))
+	if err != nil {
+		return err
+	}
+
+	if inc.URL == "" {
+		return errIncludeURL
+	}
+
+	return nil
+}
+
+// formatExt returns the extension of the format of the include, e.g. ".yaml" for "yaml", or blank when it has none.
+func (inc *include) formatExt() (string, error) {
+	if inc.Format == "" {
+		return "", nil
+	}
+
+	ext := "." + strings.TrimPrefix(strings.ToLower(inc.Format), ".")
+	if _, ok := Unmarshallers[ext]; !ok || ext == "." {
+		return "", fmt.Errorf("%w %v : %v", errIncludeFormat, inc.Format, inc.URL)
+	}
+
+	return ext, nil
+}
+
+// includeURLs returns the urls to load for the include relative to the url, or none when its condition does not hold.
+func (l *loader) includeURLs(url *pkgurl.URL, inc include) ([]*pkgurl.URL, error) {
+	ok, err := l.evalCondition(inc.When)
+	if err != nil {
+		return nil, fmt.Errorf("%w : %v", err, inc.URL)
+	}
+
+	if !ok {
+		return nil, nil
+	}
+
+	urls, err := l.toURLs(url, inc.URL)
+
+	// an optional pattern may match no files, as an optional file may not exist
+	if errors.Is(err, errGlobNoMatch) && inc.Optional {
+		urls, err = nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if l.checkIncludes && len(urls) == 0 {
+		l.warn(WarnEmptyInclude, url, "the include %v matches no files", inc.URL)
+	}
+
+	for _, u := range urls {
+		// only the paths given to AddFiles read standard input, so that loaded data cannot make a process read it
+		if u.Scheme == stdinScheme {
+			return nil, fmt.Errorf("%w : %v", errIncludeStdin, inc.URL)
+		}
+
+		if err := l.checkScheme(u); err != nil {
+			return nil, err
+		}
+	}
+
+	return urls, l.checkRoot(urls)
+}
+
+// evalCondition evaluates the condition of an include using environment variables, where a blank condition holds.
+// A condition is a list of comparisons joined by '&&' or '||', with '&&' taking precedence. Each comparison is
+// either VAR == value or VAR != value, where the value may be quoted, or VAR or !VAR to test if a variable is set
+// to a non empty value.
+func (l *loader) evalCondition(cond string) (bool, error) {
+	if strings.TrimSpace(cond) == "" {
+		return true, nil
+	}
+
+	for _, alt := range strings.Split(cond, "||") {
+		all := true
+
+		for _, cmp := range strings.Split(alt, "&&") {
+			ok, err := l.evalComparison(strings.TrimSpace(cmp))
+			if err != nil {
+				return false, err
+			}
+
+			all = all && ok
+		}
+
+		if all {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (l *loader) evalComparison(cmp string) (bool, error) {
+	for _, op := range []string{"==", "!="} {
+		name, value, ok := strings.Cut(cmp, op)
+		if !ok {
+			continue
+		}
+
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !isVarName(name) || value == "" {
+			return false, fmt.Errorf("%w %q", errIncludeCondition, cmp)
+		}
+
+		val, _ := l.lookupEnv(name)
+
+		return (val == unquote(value)) == (op == "=="), nil
+	}
+
+	name := strings.TrimPrefix(cmp, "!")
+	if !isVarName(name) {
+		return false, fmt.Errorf("%w %q", errIncludeCondition, cmp)
+	}
+
+	val, _ := l.lookupEnv(name)
+
+	return (val != "") == (name == cmp), nil
+}
+
+func isVarName(s string) bool {
+	return s != "" && varName(s) == s
+}
+
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+
+	return s
+}
+
+// warnIncludesKeys adds a warning for each top level key of the data that looks like a misspelling of the includes
+// key, i.e. it differs by at most two letters once case and punctuation are ignored, and has a value that could
+// be an includes array.
+func (l *loader) warnIncludesKeys(data *filedata) {
+	key := l.includesKey()
+	if key == "" {
+		return
+	}
+
+	want := normalizeKey(key)
+
+	for name, val := range data.obj {
+		if name == key || !looksLikeIncludes(val) || editDistance(normalizeKey(name), want) > 2 {
+			continue
+		}
+
+		l.warn(WarnSuspectIncludesKey, data.source(), "the key %v is merged as data, the includes key is %v", name, key)
+	}
+}
+
+func normalizeKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+
+		return -1
+	}, key)
+}
+
+// looksLikeIncludes reports whether the value is a path, or an array of paths or include objects.
+func looksLikeIncludes(val interface{}) bool {
+	switch val := val.(type) {
+	case string:
+		return true
+	case []interface{}:
+		for _, item := range val {
+			switch item := item.(type) {
+			case string:
+			case map[string]interface{}:
+				if _, ok := item["url"]; !ok {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+
+		return true
+	default:
+		return false
+	}
+}
+
+// editDistance returns the number of single letter insertions, deletions or substitutions needed to change a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(first int, rest ...int) int {
+	for _, n := range rest {
+		if n < first {
+			first = n
+		}
+	}
+
+	return first
+}
+
+// noData reports whether none of the data has any values.
+func noData(fds filedatas) bool {
+	for i := range fds {
+		if len(fds[i].obj) > 0 || len(fds[i].items) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
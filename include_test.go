@@ -0,0 +1,275 @@
+package conflate
+
+import (
+	gocontext "context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"testing/fstest"
+
+	"cloud.google.com/go/storage"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInclude_UnmarshalJSON(t *testing.T) {
+	var includes []include
+
+	err := JSONUnmarshal([]byte(`["a.json", {"url": "b.json", "when": "ENV == prod"}, {"url": "c.json"}]`), &includes)
+	assert.Nil(t, err)
+	assert.Equal(t, []include{{URL: "a.json"}, {URL: "b.json", When: "ENV == prod"}, {URL: "c.json"}}, includes)
+
+	err = JSONUnmarshal([]byte(`["?a.json", {"url": "b.json", "optional": true}]`), &includes)
+	assert.Nil(t, err)
+	assert.Equal(t, []include{{URL: "a.json", Optional: true}, {URL: "b.json", Optional: true}}, includes)
+
+	err = JSONUnmarshal([]byte(`[{"url": "a.conf", "format": "YAML"}]`), &includes)
+	assert.Nil(t, err)
+	assert.Equal(t, []include{{URL: "a.conf", Format: "YAML"}}, includes)
+
+	ext, err := includes[0].formatExt()
+	assert.Nil(t, err)
+	assert.Equal(t, ".yaml", ext)
+
+	_, err = (&include{URL: "a.conf", Format: "."}).formatExt()
+	assert.ErrorIs(t, err, errIncludeFormat)
+
+	err = JSONUnmarshal([]byte(`[{"when": "ENV"}]`), &includes)
+	assert.ErrorIs(t, err, errIncludeURL)
+
+	err = JSONUnmarshal([]byte(`[1]`), &includes)
+	assert.NotNil(t, err)
+}
+
+func TestLoader_EvalCondition(t *testing.T) {
+	l := loader{lookup: testLookup(map[string]string{"ENV": "prod", "REGION": "eu", "EMPTY": ""})}
+
+	for cond, expected := range map[string]bool{
+		"":                                  true,
+		"ENV == prod":                       true,
+		"ENV==prod":                         true,
+		"ENV == 'prod'":                     true,
+		`ENV == "dev"`:                      false,
+		"ENV != dev":                        true,
+		"MISSING == ''":                     true,
+		"ENV":                               true,
+		"EMPTY":                             false,
+		"!MISSING":                          true,
+		"ENV == prod && REGION == us":       false,
+		"ENV == dev || REGION == eu":        true,
+		"ENV == dev || ENV == prod && !ENV": false,
+	} {
+		ok, err := l.evalCondition(cond)
+		assert.Nil(t, err, cond)
+		assert.Equal(t, expected, ok, cond)
+	}
+
+	for _, cond := range []string{"ENV ==", "== prod", "ENV = prod", "ENV && ", "$ENV == prod"} {
+		_, err := l.evalCondition(cond)
+		assert.ErrorIs(t, err, errIncludeCondition, cond)
+	}
+}
+
+func TestLoader_IncludeURLs(t *testing.T) {
+	l := loader{lookup: testLookup(map[string]string{"ENV": "prod"})}
+
+	root, err := url.Parse("file:///conf/base.json")
+	assert.Nil(t, err)
+
+	urls, err := l.includeURLs(root, include{URL: "prod.json", When: "ENV == prod"})
+	assert.Nil(t, err)
+	assert.Len(t, urls, 1)
+	assert.Equal(t, "/conf/prod.json", urls[0].Path)
+
+	urls, err = l.includeURLs(root, include{URL: "dev.json", When: "ENV == dev"})
+	assert.Nil(t, err)
+	assert.Empty(t, urls)
+
+	_, err = l.includeURLs(root, include{URL: "bad.json", When: "=="})
+	assert.ErrorIs(t, err, errIncludeCondition)
+	assert.Contains(t, err.Error(), "bad.json")
+}
+
+func TestIsNotFound(t *testing.T) {
+	assert.True(t, isNotFound(&statusError{code: http.StatusNotFound}))
+	assert.True(t, isNotFound(fmt.Errorf("wrapped: %w", &statusError{code: http.StatusGone})))
+	assert.False(t, isNotFound(&statusError{code: http.StatusForbidden}))
+	assert.True(t, isNotFound(fs.ErrNotExist))
+	assert.True(t, isNotFound(storage.ErrObjectNotExist))
+	assert.True(t, isNotFound(&s3types.NoSuchKey{}))
+	assert.False(t, isNotFound(errTest))
+	assert.False(t, isNotFound(nil))
+}
+
+func TestLoadURLsRecursive_OptionalIncludes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/base.json":
+			_, _ = w.Write([]byte(`{"includes": ["?missing.json", {"url": "gone.json", "optional": true}, "?child.json"], "x": 1}`))
+		case "/ok.json":
+			_, _ = w.Write([]byte(`{"includes": ["?missing.json", {"url": "gone.json", "optional": true}], "x": 1}`))
+		case "/child.json":
+			_, _ = w.Write([]byte(`{"includes": ["missing.json"]}`))
+		case "/broken.json":
+			_, _ = w.Write([]byte(`{"includes": ["?invalid.json"]}`))
+		case "/invalid.json":
+			_, _ = w.Write([]byte(`{"x": `))
+		case "/error.json":
+			_, _ = w.Write([]byte(`{"includes": ["?fail.json"]}`))
+		case "/fail.json":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	load := func(path string) error {
+		u, err := url.Parse(server.URL + path)
+		assert.Nil(t, err)
+
+		_, err = testLoader.loadURLsRecursive(gocontext.Background(), nil, u)
+
+		return err
+	}
+
+	assert.Nil(t, load("/ok.json"))
+
+	// the optional child exists, but its own include is required
+	err := load("/base.json")
+	assert.ErrorIs(t, err, errFailedToLoad)
+	assert.Contains(t, err.Error(), "/missing.json")
+
+	var perr *ParseError
+
+	err = load("/broken.json")
+	assert.ErrorAs(t, err, &perr)
+
+	err = load("/error.json")
+	assert.ErrorIs(t, err, errFailedToLoad)
+	assert.Contains(t, err.Error(), "403")
+}
+
+func TestConflate_CheckIncludes(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json":  {Data: []byte(`{"includes": ["empty.json", "a.json", "?none/*.json", "?missing.json"], "inclde": ["b.json"], "include": 1}`)},
+		"a.json":     {Data: []byte(`{"includes": ["empty.yaml"], "a": 1, "$Includes": [{"url": "b.json"}], "imports": ["b.json"]}`)},
+		"b.json":     {Data: []byte(`{"b": 1}`)},
+		"empty.json": {Data: []byte(`{}`)},
+		"empty.yaml": {Data: []byte("# nothing\n")},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddFiles("base.json"))
+	assert.Len(t, c.Result().Warnings, 1)
+
+	c = New(WithFS(fsys))
+	c.CheckIncludes(true)
+	assert.Nil(t, c.AddFiles("base.json"))
+
+	var got []string
+	for _, w := range c.Result().Warnings {
+		got = append(got, w.String())
+	}
+
+	assert.ElementsMatch(t, []string{
+		"empty-include: the include adds no data (file:///empty.json)",
+		"suspect-includes-key: the key $Includes is merged as data, the includes key is includes (file:///a.json)",
+		"empty-include: the include adds no data (file:///empty.yaml)",
+		"empty-include: the include none/*.json matches no files (file:///base.json)",
+		"optional-include-missing: the optional include was not found (file:///missing.json)",
+		"suspect-includes-key: the key inclde is merged as data, the includes key is includes (file:///base.json)",
+	}, got)
+}
+
+func TestConflate_CheckIncludes_Overridden(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json":     {Data: []byte(`{"includes": ["defaults.json", "partial.json", "nested.json"], "a": 2, "b": {"c": 2}}`)},
+		"defaults.json": {Data: []byte(`{"a": 1, "b": {"c": 1}}`)},
+		"partial.json":  {Data: []byte(`{"a": 1, "d": 1}`)},
+		"nested.json":   {Data: []byte(`{"includes": ["deep.json"], "b": {"c": 3}}`)},
+		"deep.json":     {Data: []byte(`{"a": 3}`)},
+	}
+
+	c := New(WithFS(fsys))
+	c.CheckIncludes(true)
+	assert.Nil(t, c.AddFiles("base.json"))
+
+	var got []string
+	for _, w := range c.Result().Warnings {
+		got = append(got, w.String())
+	}
+
+	assert.ElementsMatch(t, []string{
+		"overridden-include: the values of the include are all overridden (file:///defaults.json)",
+		"overridden-include: the values of the include are all overridden (file:///nested.json)",
+		"overridden-include: the values of the include are all overridden (file:///deep.json)",
+	}, got)
+	assert.Nil(t, c.Provenance())
+
+	c = New(WithFS(fsys))
+	c.TrackProvenance(true)
+	c.CheckIncludes(true)
+	assert.Nil(t, c.AddFiles("partial.json", "deep.json"))
+	assert.Empty(t, c.Result().Warnings)
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"includes", "includes", 0},
+		{"include", "includes", 1},
+		{"inclde", "includes", 2},
+		{"imports", "includes", 6},
+		{"", "abc", 3},
+		{"kitten", "sitting", 3},
+	} {
+		assert.Equal(t, tc.want, editDistance(tc.a, tc.b), tc.a)
+	}
+}
+
+func TestConflate_BaseURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config/a.json":
+			_, _ = w.Write([]byte(`{"includes": ["b.json"], "a": 1}`))
+		case "/config/b.json":
+			_, _ = w.Write([]byte(`{"b": 1}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	defer server.Close()
+
+	base, err := url.Parse(server.URL + "/config/")
+	assert.Nil(t, err)
+
+	c := New(WithBaseURL(base))
+	assert.Nil(t, c.AddData([]byte(`{"includes": ["a.json"], "x": 1}`)))
+	assert.Equal(t, map[string]interface{}{"a": 1.0, "b": 1.0, "x": 1.0}, c.Data())
+
+	assert.Nil(t, c.AddGo(map[string]interface{}{"includes": []string{"b.json"}, "y": 1}))
+	assert.Equal(t, map[string]interface{}{"a": 1.0, "b": 1.0, "x": 1.0, "y": 1.0}, c.Data())
+
+	// the includes of data loaded from a url are still relative to it
+	c = New(WithBaseURL(base), WithFS(fstest.MapFS{
+		"other/main.json": {Data: []byte(`{"includes": ["b.json"]}`)},
+		"other/b.json":    {Data: []byte(`{"other": 1}`)},
+	}))
+	assert.Nil(t, c.AddFiles("/other/main.json"))
+	assert.Equal(t, map[string]interface{}{"other": 1.0}, c.Data())
+
+	// the base url is subject to the same restrictions as any other url
+	c = New(WithBaseURL(base))
+	c.AllowSchemes("file")
+
+	err = c.AddData([]byte(`{"includes": ["a.json"]}`))
+	assert.ErrorIs(t, err, errSchemeDenied)
+}
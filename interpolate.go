@@ -0,0 +1,189 @@
+package conflate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errMissingReference = errors.New("the reference is not in the data")
+	errReferenceCycle   = errors.New("the reference refers back to itself")
+	errReferenceType    = errors.New("only a string, number, boolean, duration or time can be interpolated into a string")
+)
+
+// interpolation replaces references of the form ${pointer} in the string values of the data with the value at
+// the JSON pointer, e.g. ${db/host} or ${/db/host}, where a value that is only a reference is replaced by the value
+// with its type. Values are interpolated in the order of their pointers, and each value is interpolated before it
+// is referenced, so the result does not depend on the order.
+type interpolation struct {
+	root interface{}
+	// resolving holds the pointers of the values being interpolated, to detect references to themselves
+	resolving []string
+	done      map[string]bool
+}
+
+func interpolate(data interface{}) error {
+	i := interpolation{root: data, done: map[string]bool{}}
+
+	_, err := i.value("", data)
+
+	return err
+}
+
+// value interpolates the references within the value at the pointer, returning the value to replace it with.
+func (i *interpolation) value(pointer string, data interface{}) (interface{}, error) {
+	if i.done[pointer] {
+		return data, nil
+	}
+
+	i.resolving = append(i.resolving, pointer)
+	defer func() { i.resolving = i.resolving[:len(i.resolving)-1] }()
+
+	var err error
+
+	switch node := data.(type) {
+	case string:
+		data, err = i.str(pointer, node)
+	case map[string]interface{}:
+		err = i.object(pointer, node)
+	case []interface{}:
+		for n, item := range node {
+			node[n], err = i.value(pointer+"/"+strconv.Itoa(n), item)
+			if err != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	i.done[pointer] = true
+
+	return data, nil
+}
+
+func (i *interpolation) object(pointer string, node map[string]interface{}) error {
+	names := make([]string, 0, len(node))
+	for name := range node {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		val, err := i.value(pointer+"/"+escapePointerToken(name), node[name])
+		if err != nil {
+			return err
+		}
+
+		node[name] = val
+	}
+
+	return nil
+}
+
+// str replaces the references in the string, where $${ is a literal ${.
+func (i *interpolation) str(pointer, s string) (interface{}, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	if ref, ok := wholeReference(s); ok {
+		return i.resolve(pointer, ref)
+	}
+
+	var sb strings.Builder
+
+	for n := 0; n < len(s); n++ {
+		switch {
+		case strings.HasPrefix(s[n:], "$${"):
+			sb.WriteString("${")
+			n += 2
+		case strings.HasPrefix(s[n:], "${") && strings.Contains(s[n:], "}"):
+			end := n + strings.IndexByte(s[n:], '}')
+
+			val, err := i.resolve(pointer, s[n+2:end])
+			if err != nil {
+				return nil, err
+			}
+
+			str, err := scalarString(val)
+			if err != nil {
+				return nil, fmt.Errorf("%w : ${%v} (#%v)", err, s[n+2:end], pointer)
+			}
+
+			sb.WriteString(str)
+			n = end
+		default:
+			sb.WriteByte(s[n])
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// wholeReference returns the reference when the string is nothing but a single reference.
+func wholeReference(s string) (string, bool) {
+	if !strings.HasPrefix(s, "${") || !strings.HasSuffix(s, "}") || strings.Count(s, "}") != 1 {
+		return "", false
+	}
+
+	return s[2 : len(s)-1], true
+}
+
+// resolve returns the value of the reference from the value at the pointer, once it has been interpolated itself.
+func (i *interpolation) resolve(pointer, ref string) (interface{}, error) {
+	target := ref
+	if !strings.HasPrefix(target, "/") {
+		target = "/" + target
+	}
+
+	for n, resolving := range i.resolving {
+		if resolving == target {
+			chain := append(append([]string(nil), i.resolving[n:]...), target)
+
+			return nil, fmt.Errorf("%w : %v", errReferenceCycle, strings.Join(chain, " -> "))
+		}
+	}
+
+	val, ok := lookupPointer(i.root, target)
+	if !ok {
+		return nil, fmt.Errorf("%w : ${%v} (#%v)", errMissingReference, ref, pointer)
+	}
+
+	val, err := i.value(target, val)
+	if err != nil {
+		return nil, err
+	}
+
+	// the referenced value is interpolated in place, so later references find it done
+	setPointer(i.root, target, val)
+
+	return copyValue(val, true), nil
+}
+
+func scalarString(val interface{}) (string, error) {
+	switch val := val.(type) {
+	case string:
+		return val, nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case json.Number:
+		return val.String(), nil
+	case bool, int, int64, uint64:
+		return fmt.Sprint(val), nil
+	case time.Duration:
+		return val.String(), nil
+	case time.Time:
+		return val.Format(time.RFC3339Nano), nil
+	default:
+		return "", errReferenceType
+	}
+}
@@ -0,0 +1,77 @@
+package conflate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterpolate(t *testing.T) {
+	var data interface{}
+
+	err := JSONUnmarshal([]byte(`{
+		"db": {"host": "db", "port": 5432, "dsn": "postgres://${db/host}:${db/port}", "url": "${/db/dsn}?ssl=${ssl}"},
+		"dsn": "${db/dsn}",
+		"ssl": true,
+		"hosts": ["${db/host}", "$${db/host}"],
+		"copy": "${db}"
+	}`), &data)
+	assert.Nil(t, err)
+
+	assert.Nil(t, interpolate(data))
+	assert.Equal(t, "postgres://db:5432?ssl=true", lookup(t, data, "/db/url"))
+	assert.Equal(t, "postgres://db:5432", lookup(t, data, "/dsn"))
+	assert.Equal(t, []interface{}{"db", "${db/host}"}, lookup(t, data, "/hosts"))
+	assert.Equal(t, lookup(t, data, "/db"), lookup(t, data, "/copy"))
+	assert.Equal(t, 5432.0, lookup(t, data, "/copy/port"))
+}
+
+func TestInterpolate_Errors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  error
+		msg  string
+	}{
+		{data: `{"a": "${missing}"}`, err: errMissingReference, msg: "${missing} (#/a)"},
+		{data: `{"a": "${b}", "b": "x${a}"}`, err: errReferenceCycle, msg: "/a -> /b -> /a"},
+		{data: `{"a": {"b": "${a}"}}`, err: errReferenceCycle, msg: "/a -> /a/b -> /a"},
+		{data: `{"a": "x${b}", "b": [1]}`, err: errReferenceType, msg: "${b} (#/a)"},
+	}
+
+	for _, tt := range tests {
+		var data interface{}
+
+		assert.Nil(t, JSONUnmarshal([]byte(tt.data), &data))
+
+		err := interpolate(data)
+		assert.ErrorIs(t, err, tt.err, tt.data)
+		assert.Contains(t, err.Error(), tt.msg, tt.data)
+	}
+}
+
+func TestConflate_Interpolate(t *testing.T) {
+	c, err := FromData([]byte(`{"host": "db", "url": "postgres://${host}:5432"}`))
+	assert.Nil(t, err)
+
+	assert.Nil(t, c.Interpolate())
+	assert.Equal(t, map[string]interface{}{"host": "db", "url": "postgres://db:5432"}, c.Data())
+
+	c, err = FromData([]byte(`{"host": "${url}", "url": "${host}"}`))
+	assert.Nil(t, err)
+
+	err = c.Interpolate()
+	assert.ErrorIs(t, err, errReferenceCycle)
+	assert.Equal(t, map[string]interface{}{"host": "${url}", "url": "${host}"}, c.Data())
+
+	c.Freeze()
+	assert.ErrorIs(t, c.Interpolate(), errFrozen)
+}
+
+func lookup(t *testing.T, data interface{}, pointer string) interface{} {
+	t.Helper()
+
+	val, ok := lookupPointer(data, pointer)
+	assert.True(t, ok, pointer)
+
+	return val
+}
@@ -0,0 +1,182 @@
+package conflate
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	errJSONCComment = errors.New("unterminated comment")
+	errJSONCString  = errors.New("unterminated string")
+)
+
+// JSONCUnmarshal unmarshals JSON that also has the relaxed syntax people tend to use when editing by hand:
+// '//' and '/* */' comments, trailing commas, unquoted object keys and single quoted strings.
+// It is registered for .jsonc and .json5 files, although other JSON5 extensions such as hex numbers are not supported.
+func JSONCUnmarshal(data []byte, out interface{}) error {
+	stripped, err := jsoncStripComments(data)
+	if err == nil {
+		data, err = jsoncNormalize(stripped)
+	}
+
+	if err != nil {
+		return fmt.Errorf("the data could not be unmarshalled as jsonc: %w", err)
+	}
+
+	return JSONUnmarshal(data, out)
+}
+
+// jsoncStripComments replaces each comment outside of a string with a space.
+func jsoncStripComments(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		switch {
+		case c == '"' || c == '\'':
+			end, err := jsoncStringEnd(data, i)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, data[i:end+1]...)
+			i = end
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				return out, nil
+			}
+
+			out = append(out, ' ')
+			i += end - 1
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, errJSONCComment
+			}
+
+			out = append(out, ' ')
+			i += end + 3
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return out, nil
+}
+
+// jsoncNormalize rewrites data without comments as standard JSON.
+func jsoncNormalize(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	last := byte(0)
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		switch {
+		case c == '"' || c == '\'':
+			end, err := jsoncStringEnd(data, i)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, jsoncQuote(data[i:end+1])...)
+			i = end
+		case c == ',' && bytes.IndexByte([]byte("}]"), jsoncNext(data, i+1)) >= 0:
+			// drop a trailing comma
+			continue
+		case jsoncIdentStart(c) && (last == '{' || last == ','):
+			var ident []byte
+
+			ident, i = jsoncIdent(data, i)
+			out = append(out, ident...)
+		default:
+			out = append(out, c)
+		}
+
+		if !jsoncSpace(c) {
+			last = c
+		}
+	}
+
+	return out, nil
+}
+
+// jsoncIdent reads the identifier starting at start, quoting it when it is an object key,
+// and returns it along with the index of its last character.
+func jsoncIdent(data []byte, start int) ([]byte, int) {
+	end := start
+	for end < len(data) && (jsoncIdentStart(data[end]) || data[end] >= '0' && data[end] <= '9') {
+		end++
+	}
+
+	ident := data[start:end]
+	if jsoncNext(data, end) == ':' {
+		ident = append(append([]byte{'"'}, ident...), '"')
+	}
+
+	return ident, end - 1
+}
+
+// jsoncStringEnd returns the index of the quote that closes the string starting at start.
+func jsoncStringEnd(data []byte, start int) (int, error) {
+	quote := data[start]
+
+	for i := start + 1; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case quote:
+			return i, nil
+		}
+	}
+
+	return 0, errJSONCString
+}
+
+// jsoncQuote converts a single quoted string to a double quoted one, leaving double quoted strings unchanged.
+func jsoncQuote(s []byte) []byte {
+	if s[0] == '"' {
+		return s
+	}
+
+	out := []byte{'"'}
+
+	for i := 1; i < len(s)-1; i++ {
+		switch {
+		case s[i] == '\\' && s[i+1] == '\'':
+			out = append(out, '\'')
+			i++
+		case s[i] == '\\':
+			out = append(out, s[i], s[i+1])
+			i++
+		case s[i] == '"':
+			out = append(out, '\\', '"')
+		default:
+			out = append(out, s[i])
+		}
+	}
+
+	return append(out, '"')
+}
+
+// jsoncNext returns the next character from start that is not whitespace, or 0 at the end of the data.
+func jsoncNext(data []byte, start int) byte {
+	for i := start; i < len(data); i++ {
+		if !jsoncSpace(data[i]) {
+			return data[i]
+		}
+	}
+
+	return 0
+}
+
+func jsoncSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func jsoncIdentStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '$'
+}
@@ -0,0 +1,53 @@
+package conflate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONCUnmarshal(t *testing.T) {
+	var out interface{}
+
+	err := JSONCUnmarshal([]byte(`
+// the server settings
+{
+	host: "example.com", // trailing comment
+	/* block
+	   comment */
+	port: 8080,
+	"url": "http://example.com/*not a comment*/",
+	$name_1: 'it\'s "quoted"',
+	list: [true, false, null,],
+	nested: {x: 1,},
+}
+`), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"host":    "example.com",
+		"port":    8080.0,
+		"url":     "http://example.com/*not a comment*/",
+		"$name_1": `it's "quoted"`,
+		"list":    []interface{}{true, false, nil},
+		"nested":  map[string]interface{}{"x": 1.0},
+	}, out)
+}
+
+func TestJSONCUnmarshal_Errors(t *testing.T) {
+	tests := []struct {
+		data, msg string
+	}{
+		{`{"x": 1 /* open`, "unterminated comment"},
+		{`{"x": "open`, "unterminated string"},
+		{`{"x": 'open`, "unterminated string"},
+		{`{x 1}`, "could not be unmarshalled as json"},
+	}
+
+	for _, tt := range tests {
+		var out interface{}
+
+		err := JSONCUnmarshal([]byte(tt.data), &out)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), tt.msg)
+	}
+}
@@ -1,158 +1,631 @@
 package conflate
 
 import (
-	ctx "context"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	gocontext "context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net"
 	"net/http"
 	pkgurl "net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"google.golang.org/api/option"
 )
 
-const windowsOS = "windows"
+const (
+	windowsOS   = "windows"
+	stdinPath   = "-"
+	stdinScheme = "stdin"
+
+	defaultMaxRedirects = 10
+	defaultMaxDepth     = 64
+)
 
 var (
-	goos        = runtime.GOOS
-	emptyURL    = pkgurl.URL{}
-	getwd       = os.Getwd
-	driveLetter = regexp.MustCompile(`^[A-Za-z]:.*$`)
+	goos                  = runtime.GOOS
+	stdin       io.Reader = os.Stdin
+	emptyURL              = pkgurl.URL{}
+	getwd                 = os.Getwd
+	driveLetter           = regexp.MustCompile(`^[A-Za-z]:.*$`)
 
 	errBlankFilePath = errors.New("the file path is blank")
 	errFailedToLoad  = errors.New("failed to load url")
 	errRecursiveURL  = errors.New("the url recursively includes itself")
+	errMaxBytes      = errors.New("include exceeded max size")
+	errUndefinedVar  = errors.New("undefined environment variable")
+	errMaxRedirects  = errors.New("stopped after too many redirects")
+	errOutsideRoot   = errors.New("the include is outside the include root")
+	errGlobScheme    = errors.New("globbing not supported for scheme")
+	errGlobNoMatch   = errors.New("no files match the pattern")
+	errMaxDepth      = errors.New("includes are nested too deeply")
+	errGeneration    = errors.New("invalid gcs object generation")
+	errFileDisabled  = errors.New("file scheme disabled")
+	errSchemeDenied  = errors.New("the url scheme is not allowed")
+	errOutsideFiles  = errors.New("the file is outside the file root")
 )
 
+// IncludeError is returned when includes recursively include themselves, or are nested too deeply.
+type IncludeError struct {
+	// Chain holds the url of each include in turn, from the first url loaded to the url that failed.
+	Chain []*pkgurl.URL
+	err   error
+}
+
+func newIncludeError(err error, parentUrls []*pkgurl.URL, url *pkgurl.URL) *IncludeError {
+	chain := make([]*pkgurl.URL, 0, len(parentUrls)+1)
+
+	return &IncludeError{Chain: append(append(chain, parentUrls...), url), err: err}
+}
+
+// Error formats the chain of urls as "a -> b -> c -> a".
+func (e *IncludeError) Error() string {
+	names := make([]string, 0, len(e.Chain))
+	for _, url := range e.Chain {
+		names = append(names, redact(url))
+	}
+
+	return fmt.Sprintf("%v : %v", e.err.Error(), strings.Join(names, " -> "))
+}
+
+func (e *IncludeError) Unwrap() error {
+	return e.err
+}
+
+// statusError is returned when loading a url over http does not succeed.
+type statusError struct {
+	code int
+	url  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%v : %v : %v", errFailedToLoad.Error(), e.code, e.url)
+}
+
+func (e *statusError) Unwrap() error {
+	return errFailedToLoad
+}
+
+// isNotFound reports whether loading a url failed because there is nothing at the url, rather than for any other
+// reason. Scheme loaders can return an error wrapping fs.ErrNotExist to report this.
+func isNotFound(err error) bool {
+	var serr *statusError
+
+	var nerr *s3types.NoSuchKey
+
+	switch {
+	case errors.As(err, &serr):
+		return serr.code == http.StatusNotFound || serr.code == http.StatusGone
+	case errors.As(err, &nerr):
+		return true
+	default:
+		return errors.Is(err, fs.ErrNotExist) || errors.Is(err, storage.ErrObjectNotExist)
+	}
+}
+
+// SchemeLoader defines the type of function used for loading the data at a url.
+type SchemeLoader func(ctx gocontext.Context, url *pkgurl.URL) ([]byte, error)
+
+// SchemeLoaderMap defines the type of a map of url scheme to SchemeLoader.
+type SchemeLoaderMap map[string]SchemeLoader
+
+// SchemeLoaders is a list of loading functions to be used for given url schemes.
+// They take precedence over the built-in handling of the file, gs, s3, http and https schemes.
+var SchemeLoaders = SchemeLoaderMap{}
+
+// TransportOptions configures the http transport used to load remote urls.
+// A zero value for any field means the default is used.
+type TransportOptions struct {
+	DialTimeout         time.Duration
+	TLSHandshakeTimeout time.Duration
+	IdleConnTimeout     time.Duration
+	MaxIdleConns        int
+	// Proxy returns the proxy to use for each request, or nil for no proxy, e.g. to route some hosts through
+	// a particular proxy. By default the proxy is taken from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	// variables, which the function can fall back to by calling http.ProxyFromEnvironment.
+	Proxy func(*http.Request) (*pkgurl.URL, error)
+	// TLSConfig configures https connections, e.g. with RootCAs to trust a private certificate authority, or with
+	// Certificates to authenticate the client. InsecureSkipVerify turns off verifying the server's certificate, which
+	// is only safe for development, and is logged as a warning whenever a transport is created with it.
+	TLSConfig *tls.Config
+	// DisableFile stops file urls being read from the local file system, so that only remote urls can be loaded.
+	// Loading a file url then fails, unless a file system has been set with Conflate.FS.
+	DisableFile bool
+}
+
 type loader struct {
-	newFiledata func([]byte, *pkgurl.URL) (filedata, error)
+	newFiledata func([]byte, *pkgurl.URL, string, string) (filedata, error)
+	gcsClient   *storage.Client
+	gcsOptions  []option.ClientOption
+	transport   TransportOptions
+	httpClient  *http.Client
+	retry       RetryPolicy
+	headers     http.Header
+	hostHeaders map[string]http.Header
+	maxBytes    int64
+	useCache    bool
+	cache       map[string][]byte
+	// archives holds the files of each archive loaded during the run
+	archives map[string]archiveEntries
+	// contentTypes holds the media type of each url loaded over http during the run
+	contentTypes map[string]string
+	// formats holds the extension of the format given for an include url during the run, e.g. ".yaml"
+	formats map[string]string
+	// expandPaths turns on the expansion of environment variables in paths
+	expandPaths  bool
+	expandValues bool
+	strictExpand bool
+	lookup       func(string) (string, bool)
+	maxRedirects int
+	maxDepth     int
+	// includeRoot is the url that every include must be within, if set
+	includeRoot *pkgurl.URL
+	// baseURL is the url that the includes of data not loaded from a url are relative to, if set, rather than
+	// the working directory
+	baseURL *pkgurl.URL
+	// fsys serves file urls in place of the operating system's file system, if set
+	fsys fs.FS
+	// observer is notified around fetching each url, if set
+	observer Observer
+	// onLoad is called after each source is loaded, and stops loading when it returns an error
+	onLoad func(url *pkgurl.URL, data []byte, err error) error
+	// sources holds the url of each source loaded, which unlike the cache lasts beyond a single run
+	sources []*pkgurl.URL
+	// includes is the top level key holding the includes array, the Includes variable is used when it is blank
+	includes string
+	// warnings holds the problems found that did not stop loading, which like the sources last beyond a single run
+	warnings []Warning
+	// fileRoot is the directory that every file loaded from the local file system must be within, if set
+	fileRoot string
+	// schemes holds the url schemes that can be loaded, any scheme can be loaded when it is empty
+	schemes []string
+	// disableIncludes merges the includes key of every document as ordinary data, rather than loading the includes
+	disableIncludes bool
+	// includesOverride merges the data of a document before its includes, so that the includes take precedence
+	includesOverride bool
+	// checkIncludes warns about suspect includes keys and includes that add no data
+	checkIncludes bool
+	// decodeUTF16 converts data with a UTF-16 byte order mark to UTF-8 before it is parsed
+	decodeUTF16 bool
+	// failDuplicateKeys fails loading data with a key used more than once in the same object
+	failDuplicateKeys bool
+	// failUnknownFiles fails loading a directory with files that are not of a known format, rather than skipping them
+	failUnknownFiles bool
 }
 
-func (l *loader) loadURLsRecursive(parentUrls []*pkgurl.URL, urls ...*pkgurl.URL) (filedatas, error) {
-	var allData filedatas
+func (l *loader) loadURLsRecursive(ctx gocontext.Context, parentUrls []*pkgurl.URL, urls ...*pkgurl.URL) (filedatas, error) {
+	var (
+		allData filedatas
+		err     error
+	)
+
+	parentUrls = l.parentStack(parentUrls)
 
 	for _, url := range urls {
-		data, err := l.loadURLRecursive(parentUrls, url)
+		allData, err = l.loadURLRecursive(ctx, parentUrls, url, false, allData)
 		if err != nil {
 			return nil, err
 		}
-
-		allData = append(allData, data...)
 	}
 
 	return allData, nil
 }
 
-func (l *loader) loadURLRecursive(parentUrls []*pkgurl.URL, url *pkgurl.URL) (filedatas, error) {
-	data, err := loadURL(url)
+// parentStack returns a copy of the parent urls with room for the urls of every level of includes below them, so that
+// the recursion can append the url of each level in place. The urls above a level are never changed while it is
+// loaded, so the levels can share the backing array, and any error that keeps the chain copies it.
+func (l *loader) parentStack(parentUrls []*pkgurl.URL) []*pkgurl.URL {
+	max := l.maxDepth
+	if max == 0 {
+		max = defaultMaxDepth
+	}
+
+	stack := make([]*pkgurl.URL, len(parentUrls), len(parentUrls)+max+1)
+	copy(stack, parentUrls)
+
+	return stack
+}
+
+// loadURLRecursive appends the data at the url followed by its includes to allData, where nothing is loaded for
+// an optional url when there is nothing at the url.
+func (l *loader) loadURLRecursive(ctx gocontext.Context, parentUrls []*pkgurl.URL, url *pkgurl.URL, optional bool, allData filedatas) (filedatas, error) {
+	data, err := l.loadURLCached(ctx, url)
+	if l.onLoad != nil {
+		if herr := l.onLoad(url, data, err); herr != nil {
+			return nil, fmt.Errorf("the source %v was rejected: %w", redact(url), herr)
+		}
+	}
+
+	if optional && isNotFound(err) {
+		l.warn(WarnOptionalIncludeMissing, url, "the optional include was not found")
+
+		return allData, nil
+	}
+
 	if err != nil {
 		return nil, err
 	}
 
-	fdata, err := l.newFiledata(data, url)
+	if !containsURL(url, l.sources) {
+		l.sources = append(l.sources, url)
+	}
+
+	contentType, ok := l.formats[url.String()]
+	if !ok {
+		contentType = l.contentTypes[url.String()]
+	}
+
+	fdata, err := l.parseDocuments(data, url, contentType)
 	if err != nil {
 		return nil, err
 	}
 
-	return l.loadDatumRecursive(parentUrls, url, &fdata)
+	for _, datum := range fdata {
+		datum := datum
+
+		allData, err = l.loadDatumRecursive(ctx, parentUrls, url, &datum, allData)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return allData, nil
 }
 
-func (l *loader) loadDataRecursive(parentUrls []*pkgurl.URL, data ...filedata) (filedatas, error) {
-	var allData filedatas
+func (l *loader) loadDataRecursive(ctx gocontext.Context, parentUrls []*pkgurl.URL, data ...filedata) (filedatas, error) {
+	var (
+		allData filedatas
+		err     error
+	)
+
+	parentUrls = l.parentStack(parentUrls)
 
 	for _, datum := range data {
 		datum := datum
 
-		childData, err := l.loadDatumRecursive(parentUrls, nil, &datum)
+		allData, err = l.loadDatumRecursive(ctx, parentUrls, nil, &datum, allData)
 		if err != nil {
 			return nil, err
 		}
-
-		allData = append(allData, childData...)
 	}
 
 	return allData, nil
 }
 
-func (l *loader) loadDatumRecursive(parentUrls []*pkgurl.URL, url *pkgurl.URL, data *filedata) (filedatas, error) {
+// loadDatumRecursive appends the data of the includes of the datum followed by the datum itself to allData,
+// or the datum followed by its includes when includesOverride is set.
+func (l *loader) loadDatumRecursive(ctx gocontext.Context, parentUrls []*pkgurl.URL, url *pkgurl.URL, data *filedata, allData filedatas) (filedatas, error) {
 	if data.isEmpty() {
-		return nil, nil
+		return allData, nil
 	}
 
 	if containsURL(url, parentUrls) {
-		return nil, fmt.Errorf("%w (%v)", errRecursiveURL, url)
+		return nil, newIncludeError(errRecursiveURL, parentUrls, url)
 	}
 
-	childUrls, err := toURLs(url, data.includes...)
-	if err != nil {
-		return nil, err
+	if l.tooDeep(parentUrls) {
+		return nil, newIncludeError(errMaxDepth, parentUrls, url)
 	}
 
-	var newParentUrls []*pkgurl.URL
+	if l.checkIncludes {
+		l.warnIncludesKeys(data)
+	}
 
-	newParentUrls = append(newParentUrls, parentUrls...)
+	if l.expandValues {
+		_, err := l.expandStrings(rootContext(), data.value())
+		if err != nil {
+			return nil, data.wrapError(err)
+		}
+	}
 
 	if url != nil {
-		newParentUrls = append(newParentUrls, url)
+		parentUrls = append(parentUrls, url)
+	}
+
+	if l.includesOverride {
+		return l.loadIncludes(ctx, parentUrls, url, data, append(allData, *data))
 	}
 
-	childData, err := l.loadURLsRecursive(newParentUrls, childUrls...)
+	allData, err := l.loadIncludes(ctx, parentUrls, url, data, allData)
 	if err != nil {
 		return nil, err
 	}
 
-	var allData filedatas
+	return append(allData, *data), nil
+}
 
-	allData = append(allData, childData...)
-	allData = append(allData, *data)
+func (l *loader) wrapFiledata(bytes []byte) (filedata, error) {
+	return l.parseDocument(bytes, &emptyURL, "")
+}
 
-	return allData, nil
+// parseDocument unmarshals a document from the url, checking it for duplicate keys when failDuplicateKeys is set.
+func (l *loader) parseDocument(data []byte, url *pkgurl.URL, contentType string) (filedata, error) {
+	fd, err := l.newFiledata(data, url, contentType, l.includesKey())
+	if err == nil && l.failDuplicateKeys {
+		err = fd.checkDuplicateKeys()
+	}
+
+	if err != nil {
+		return emptyFiledata, err
+	}
+
+	return fd, nil
 }
 
-func (l *loader) wrapFiledata(bytes []byte) (filedata, error) {
-	return l.newFiledata(bytes, &emptyURL)
+// parseDocuments parses each document of a YAML stream as a separate filedata, so that the documents are merged
+// with the merge options like separate sources. Data in any other format is parsed as a single filedata.
+func (l *loader) parseDocuments(data []byte, url *pkgurl.URL, contentType string) (filedatas, error) {
+	if l.decodeUTF16 {
+		decoded, err := decodeUTF16(data)
+		if err != nil {
+			return nil, (&filedata{url: url}).wrapError(err)
+		}
+
+		data = decoded
+	}
+
+	docs := [][]byte{data}
+	if isYAMLStream(data, url, contentType) {
+		docs = yamlDocuments(data)
+	}
+
+	fdata := make(filedatas, 0, len(docs))
+
+	for _, doc := range docs {
+		fd, err := l.parseDocument(doc, url, contentType)
+		if err != nil {
+			return nil, err
+		}
+
+		fdata = append(fdata, fd)
+	}
+
+	return fdata, nil
+}
+
+// isYAMLStream returns true when the data from the url is in YAML format, as given by the content type or the
+// extension of the url, or else as sniffed from the data.
+func isYAMLStream(data []byte, url *pkgurl.URL, contentType string) bool {
+	ext := (&filedata{url: url, contentType: contentType}).format()
+	if _, ok := Unmarshallers[ext]; !ok || ext == "" {
+		ext = sniffExtension(data)
+	}
+
+	return ext == ".yaml" || ext == ".yml"
+}
+
+func (l *loader) includesKey() string {
+	if l.disableIncludes {
+		return ""
+	}
+
+	if l.includes == "" {
+		return Includes
+	}
+
+	return l.includes
 }
 
 func (l *loader) wrapFiledatas(bytes ...[]byte) (filedatas, error) {
 	var fds []filedata
 
 	for _, b := range bytes {
-		fd, err := l.wrapFiledata(b)
+		fd, err := l.parseDocuments(b, &emptyURL, "")
 		if err != nil {
 			return nil, err
 		}
 
-		fds = append(fds, fd)
+		fds = append(fds, fd...)
 	}
 
 	return fds, nil
 }
 
+// loadURLCached loads each url once per run when caching is enabled.
+func (l *loader) loadURLCached(ctx gocontext.Context, url *pkgurl.URL) ([]byte, error) {
+	if !l.useCache {
+		return l.observeLoad(ctx, url)
+	}
+
+	key := url.String()
+	if data, ok := l.cache[key]; ok {
+		return data, nil
+	}
+
+	data, err := l.observeLoad(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.cache == nil {
+		l.cache = map[string][]byte{}
+	}
+
+	l.cache[key] = data
+
+	return data, nil
+}
+
+// close releases any clients and cached data from the run, clients are recreated lazily when next needed.
+func (l *loader) close() {
+	l.cache = nil
+	l.archives = nil
+	l.contentTypes = nil
+	l.formats = nil
+
+	if l.gcsClient == nil {
+		return
+	}
+
+	if err := l.gcsClient.Close(); err != nil {
+		log.Printf("error when closing the gcp storage client: %v", err.Error())
+	}
+
+	l.gcsClient = nil
+}
+
+// reset forgets the sources and warnings recorded by earlier runs, along with anything from the current run.
+func (l *loader) reset() {
+	l.close()
+	l.sources = nil
+	l.warnings = nil
+}
+
+// clone returns a copy of the loader with the same settings and sources, but none of the clients or cached data
+// of a run.
+func (l *loader) clone() loader {
+	clone := *l
+	clone.gcsClient = nil
+	clone.cache = nil
+	clone.archives = nil
+	clone.contentTypes = nil
+	clone.formats = nil
+	clone.sources = append([]*pkgurl.URL(nil), l.sources...)
+	clone.warnings = append([]Warning(nil), l.warnings...)
+
+	return clone
+}
+
+func (l *loader) storageClient(ctx gocontext.Context) (*storage.Client, error) {
+	if l.gcsClient != nil {
+		return l.gcsClient, nil
+	}
+
+	client, err := storage.NewClient(ctx, l.gcsOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create gcp storage client: %w", err)
+	}
+
+	l.gcsClient = client
+
+	return client, nil
+}
+
 func loadURL(url *pkgurl.URL) ([]byte, error) {
+	return loadURLContext(gocontext.Background(), url)
+}
+
+func loadURLContext(ctx gocontext.Context, url *pkgurl.URL) ([]byte, error) {
+	var l loader
+
+	defer l.close()
+
+	return l.loadURL(ctx, url)
+}
+
+func (l *loader) loadURL(ctx gocontext.Context, url *pkgurl.URL) ([]byte, error) {
+	if err := l.checkScheme(url); err != nil {
+		return nil, err
+	}
+
+	if archiveURL, name, ok := splitArchiveURL(url); ok {
+		return l.loadArchiveEntry(ctx, archiveURL, name)
+	}
+
+	if load := SchemeLoaders[url.Scheme]; load != nil {
+		return load(ctx, url)
+	}
+
+	if url.Scheme == stdinScheme {
+		return ioutil.ReadAll(stdin)
+	}
+
+	if url.Scheme == "file" && l.fsys != nil {
+		return fs.ReadFile(l.fsys, fsPath(url.Path))
+	}
+
+	if url.Scheme == "file" && l.transport.DisableFile {
+		return nil, fmt.Errorf("%w : %v", errFileDisabled, redact(url))
+	}
+
 	if url.Scheme == "file" {
+		name, err := l.filePath(url)
+		if err != nil {
+			return nil, err
+		}
+
 		// attempt to load locally handling case where we are loading from fifo etc
-		b, err := ioutil.ReadFile(getPath(url.Path))
+		b, err := ioutil.ReadFile(name)
 		if err == nil {
 			return b, nil
 		}
 	}
 
 	if url.Scheme == "gs" {
-		return loadConfigFromBucket(url)
+		return l.loadConfigFromBucket(ctx, url)
+	}
+
+	if url.Scheme == "s3" {
+		return l.loadConfigFromS3(ctx, url)
+	}
+
+	return l.loadHTTP(ctx, url)
+}
+
+func (l *loader) loadHTTP(ctx gocontext.Context, url *pkgurl.URL) ([]byte, error) {
+	attempts := l.retry.attempts()
+
+	for attempt := 1; ; attempt++ {
+		data, retryable, err := l.fetchHTTP(ctx, url)
+		if err == nil {
+			return data, nil
+		}
+
+		if !retryable || attempt >= attempts || ctx.Err() != nil {
+			if attempt > 1 {
+				err = fmt.Errorf("giving up after %v attempts: %w", attempt, err)
+			}
+
+			return nil, err
+		}
+
+		if werr := l.retry.wait(ctx, attempt); werr != nil {
+			return nil, fmt.Errorf("%w (after %v attempts: %v)", werr, attempt, err)
+		}
 	}
+}
 
-	client := http.Client{Transport: newTransport()}
+// fetchHTTP makes a single request, reporting whether a failure is transient and worth retrying.
+func (l *loader) fetchHTTP(ctx gocontext.Context, url *pkgurl.URL) (data []byte, retryable bool, err error) {
+	reqURL := withoutUser(url)
 
-	resp, err := client.Get(url.String())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL.String(), nil)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	if url.User != nil && (url.Scheme == "http" || url.Scheme == "https") {
+		password, _ := url.User.Password()
+		req.SetBasicAuth(url.User.Username(), password)
+	}
+
+	l.setHeaders(req)
+
+	resp, err := l.client().Do(req)
+	if err != nil {
+		return nil, retryableError(err), err
 	}
 
 	defer func() {
@@ -161,29 +634,191 @@ func loadURL(url *pkgurl.URL) ([]byte, error) {
 		}
 	}()
 
-	data, err := ioutil.ReadAll(resp.Body)
-
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%w : %v : %v", errFailedToLoad, resp.StatusCode, url.String())
+		return nil, resp.StatusCode >= http.StatusInternalServerError, &statusError{code: resp.StatusCode, url: redact(url)}
+	}
+
+	data, err = l.readAll(resp.Body)
+	if err != nil {
+		return nil, false, err
 	}
 
-	return data, err
+	data, err = l.decodeContent(resp.Header.Get("Content-Encoding"), data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	l.setContentType(url, resp.Header.Get("Content-Type"))
+
+	return data, false, nil
+}
+
+// retryableError reports whether an error making a request is transient, which is a timeout or a connection that
+// was refused or reset, rather than e.g. a refused redirect or a certificate that cannot be verified.
+func retryableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// setContentType records the media type of the url, ignoring any parameters such as the charset.
+func (l *loader) setContentType(url *pkgurl.URL, contentType string) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return
+	}
+
+	if l.contentTypes == nil {
+		l.contentTypes = map[string]string{}
+	}
+
+	l.contentTypes[url.String()] = mediaType
 }
 
-func loadConfigFromBucket(url *pkgurl.URL) ([]byte, error) {
+// setFormat records the extension of the format given for the url, which takes precedence over its content type.
+func (l *loader) setFormat(url *pkgurl.URL, ext string) {
+	if l.formats == nil {
+		l.formats = map[string]string{}
+	}
+
+	l.formats[url.String()] = ext
+}
+
+// readAll reads until EOF, failing once more than the configured maximum number of bytes has been read.
+func (l *loader) readAll(r io.Reader) ([]byte, error) {
+	if l.maxBytes <= 0 {
+		return ioutil.ReadAll(r)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, l.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > l.maxBytes {
+		return nil, fmt.Errorf("%w %v", errMaxBytes, l.maxBytes)
+	}
+
+	return data, nil
+}
+
+// decodeContent decompresses data the server sent with a content encoding the http client did not handle itself.
+func (l *loader) decodeContent(encoding string, data []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		// servers disagree on whether deflate is zlib wrapped, so fall back to a raw stream
+		r, err = zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			r, err = flate.NewReader(bytes.NewReader(data)), nil
+		}
+	default:
+		return data, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %v content: %w", encoding, err)
+	}
+
+	defer r.Close()
+
+	out, err := l.readAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not decode %v content: %w", encoding, err)
+	}
+
+	return out, nil
+}
+
+// setHeaders adds the configured headers to requests for http urls, host specific headers take precedence.
+func (l *loader) setHeaders(req *http.Request) {
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return
+	}
+
+	for _, headers := range []http.Header{l.headers, l.hostHeaders[req.URL.Host]} {
+		for name, values := range headers {
+			req.Header.Del(name)
+
+			for _, value := range values {
+				req.Header.Add(name, value)
+			}
+		}
+	}
+}
+
+// redirectHeaders replaces the headers a redirect copied from the previous request, so that host specific headers
+// are only sent to their own host.
+func (l *loader) redirectHeaders(req *http.Request) {
+	for _, headers := range l.hostHeaders {
+		for name := range headers {
+			req.Header.Del(name)
+		}
+	}
+
+	l.setHeaders(req)
+}
+
+func (l *loader) client() *http.Client {
+	if l.httpClient != nil {
+		return l.httpClient
+	}
+
+	return &http.Client{Transport: newTransport(l.transport), CheckRedirect: l.checkRedirect}
+}
+
+// checkRedirect limits the number of redirects followed, reporting the chain of urls when the limit is hit.
+func (l *loader) checkRedirect(req *http.Request, via []*http.Request) error {
+	max := l.maxRedirects
+	if max <= 0 {
+		max = defaultMaxRedirects
+	}
+
+	if err := l.checkScheme(req.URL); err != nil {
+		return fmt.Errorf("redirect refused: %w", err)
+	}
+
+	l.redirectHeaders(req)
+
+	// via holds the requests already made, so its length is one more than the redirects followed
+	if len(via) <= max {
+		return nil
+	}
+
+	chain := make([]string, 0, len(via)+1)
+	for _, r := range via {
+		chain = append(chain, r.URL.String())
+	}
+
+	chain = append(chain, req.URL.String())
+
+	return fmt.Errorf("%w (%v): %v", errMaxRedirects, max, strings.Join(chain, " -> "))
+}
+
+func (l *loader) loadConfigFromBucket(ctx gocontext.Context, url *pkgurl.URL) ([]byte, error) {
 	bucket := url.Host
 	fileName := strings.TrimLeft(url.Path, "/")
 
-	context := ctx.Background()
-
-	client, err := storage.NewClient(context)
+	client, err := l.storageClient(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create gcp storage client: %w", err)
+		return nil, err
 	}
 
-	bucketHandler := client.Bucket(bucket)
+	obj, err := gcsObject(client, url)
+	if err != nil {
+		return nil, err
+	}
 
-	rc, err := bucketHandler.Object(fileName).NewReader(context)
+	rc, err := obj.NewReader(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open file from bucket %q, file %q: %w", bucket, fileName, err)
 	}
@@ -194,7 +829,7 @@ func loadConfigFromBucket(url *pkgurl.URL) ([]byte, error) {
 		}
 	}()
 
-	slurp, err := ioutil.ReadAll(rc)
+	slurp, err := l.readAll(rc)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read data from bucket %q, file %q: %w", bucket, fileName, err)
 	}
@@ -202,7 +837,71 @@ func loadConfigFromBucket(url *pkgurl.URL) ([]byte, error) {
 	return slurp, nil
 }
 
-func newTransport() *http.Transport {
+// gcsObject returns the handle of the object at the url, which is pinned to a generation of the object when
+// the url has a generation query parameter, e.g. gs://bucket/conf.json?generation=12345.
+func gcsObject(client *storage.Client, url *pkgurl.URL) (*storage.ObjectHandle, error) {
+	obj := client.Bucket(url.Host).Object(strings.TrimLeft(url.Path, "/"))
+
+	gen := url.Query().Get("generation")
+	if gen == "" {
+		return obj, nil
+	}
+
+	n, err := strconv.ParseInt(gen, 10, 64)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("%w %q : %v", errGeneration, gen, redact(url))
+	}
+
+	return obj.Generation(n), nil
+}
+
+func (l *loader) loadConfigFromS3(ctx gocontext.Context, url *pkgurl.URL) ([]byte, error) {
+	bucket := url.Host
+	key := strings.TrimLeft(url.Path, "/")
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load aws config: %w", err)
+	}
+
+	if cfg.Region == "" {
+		cfg.Region = s3Region()
+	}
+
+	client := s3.NewFromConfig(cfg)
+
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to open file from bucket %q, file %q: %w", bucket, key, err)
+	}
+
+	defer func() {
+		if err := out.Body.Close(); err != nil {
+			log.Printf("error when closing the s3 object reader: %v", err.Error())
+		}
+	}()
+
+	slurp, err := l.readAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read data from bucket %q, file %q: %w", bucket, key, err)
+	}
+
+	return slurp, nil
+}
+
+// s3Region falls back to the region environment variables, since the aws sdk does not read AWS_DEFAULT_REGION.
+func s3Region() string {
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+
+	return os.Getenv("AWS_DEFAULT_REGION")
+}
+
+func (o TransportOptions) withDefaults() TransportOptions {
 	const (
 		conns            = 100
 		timeout          = 30
@@ -210,24 +909,368 @@ func newTransport() *http.Transport {
 		idleConnTimeout  = 90
 	)
 
+	if o.DialTimeout == 0 {
+		o.DialTimeout = timeout * time.Second
+	}
+
+	if o.TLSHandshakeTimeout == 0 {
+		o.TLSHandshakeTimeout = handshakeTimeout * time.Second
+	}
+
+	if o.IdleConnTimeout == 0 {
+		o.IdleConnTimeout = idleConnTimeout * time.Second
+	}
+
+	if o.MaxIdleConns == 0 {
+		o.MaxIdleConns = conns
+	}
+
+	if o.Proxy == nil {
+		o.Proxy = http.ProxyFromEnvironment
+	}
+
+	return o
+}
+
+func newDialer(opts TransportOptions) *net.Dialer {
+	return &net.Dialer{
+		Timeout:   opts.DialTimeout,
+		KeepAlive: opts.DialTimeout,
+		DualStack: true,
+	}
+}
+
+func newTransport(opts TransportOptions) *http.Transport {
+	opts = opts.withDefaults()
+
 	transport := &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
-			Timeout:   timeout * time.Second,
-			KeepAlive: timeout * time.Second,
-			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          conns,
-		IdleConnTimeout:       idleConnTimeout * time.Second,
-		TLSHandshakeTimeout:   handshakeTimeout * time.Second,
+		Proxy:                 opts.Proxy,
+		DialContext:           newDialer(opts).DialContext,
+		MaxIdleConns:          opts.MaxIdleConns,
+		IdleConnTimeout:       opts.IdleConnTimeout,
+		TLSHandshakeTimeout:   opts.TLSHandshakeTimeout,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 
-	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/"))) //nolint:gosec // safe in this case
+	if opts.TLSConfig != nil {
+		transport.TLSClientConfig = opts.TLSConfig.Clone()
+
+		if opts.TLSConfig.InsecureSkipVerify {
+			log.Printf("WARNING: the certificates of https servers are not verified, so connections are insecure")
+		}
+	}
+
+	if !opts.DisableFile {
+		transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/"))) //nolint:gosec // safe in this case
+	}
 
 	return transport
 }
 
+// toURLs expands any environment variables in the paths before converting them to urls, when expansion is on.
+// The includes of data loaded from anywhere but a file are never expanded, so that remote data cannot read the
+// environment, e.g. by including a url with a secret in it.
+func (l *loader) toURLs(rootURL *pkgurl.URL, paths ...string) ([]*pkgurl.URL, error) {
+	if !l.expandPaths || (rootURL != nil && rootURL.Scheme != "file" && rootURL.Scheme != stdinScheme) {
+		return l.toGlobURLs(rootURL, paths...)
+	}
+
+	expanded := make([]string, 0, len(paths))
+
+	for _, path := range paths {
+		path, err := l.expandPath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		expanded = append(expanded, path)
+	}
+
+	return l.toGlobURLs(rootURL, expanded...)
+}
+
+func (l *loader) toGlobURLs(rootURL *pkgurl.URL, paths ...string) ([]*pkgurl.URL, error) {
+	// paths are relative to the root of the file system rather than the working directory
+	if l.fsys != nil && (rootURL == nil || rootURL.Scheme == stdinScheme) {
+		rootURL = &pkgurl.URL{Scheme: "file", Path: "/"}
+	}
+
+	escaped := make([]string, 0, len(paths))
+	for _, path := range paths {
+		escaped = append(escaped, escapeGlobQuery(rootURL, path))
+	}
+
+	urls, err := toURLs(rootURL, escaped...)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.expandGlobs(urls)
+}
+
+// escapeGlobQuery escapes each '?' of a path to a file, which is a glob wildcard there rather than the start of
+// a query, so that it is kept in the path of the url.
+func escapeGlobQuery(rootURL *pkgurl.URL, path string) string {
+	if !strings.Contains(path, "?") || isStdin(path) {
+		return path
+	}
+
+	url, err := pkgurl.Parse(setPath(path))
+	if err != nil {
+		return path
+	}
+
+	scheme := url.Scheme
+	if scheme == "" && rootURL != nil && rootURL.Scheme != stdinScheme {
+		scheme = rootURL.Scheme
+	}
+
+	if scheme != "" && scheme != "file" {
+		return path
+	}
+
+	return strings.ReplaceAll(path, "?", "%3F")
+}
+
+// expandGlobs replaces each file url whose path contains glob metacharacters with the urls of the matching files,
+// in sorted order so that the files are always merged in the same order. A file whose name contains the
+// metacharacters, e.g. "config[prod].json", is used as it is, and it is an error when a pattern matches no files.
+func (l *loader) expandGlobs(urls []*pkgurl.URL) ([]*pkgurl.URL, error) {
+	expanded := make([]*pkgurl.URL, 0, len(urls))
+
+	for _, url := range urls {
+		if !strings.ContainsAny(url.Path, "*?[") {
+			expanded = append(expanded, url)
+
+			continue
+		}
+
+		if url.Scheme != "file" {
+			return nil, fmt.Errorf("%w : %v", errGlobScheme, url.Scheme)
+		}
+
+		if l.fileExists(url.Path) {
+			expanded = append(expanded, url)
+
+			continue
+		}
+
+		matches, err := l.glob(url.Path)
+		if err != nil {
+			return nil, fmt.Errorf("could not expand %v: %w", url.Path, err)
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%w : %v", errGlobNoMatch, url.Path)
+		}
+
+		for _, match := range matches {
+			u := *url
+			u.Path = match
+			u.RawPath = ""
+			expanded = append(expanded, &u)
+		}
+	}
+
+	return expanded, nil
+}
+
+// fileExists reports whether there is a file at the url path.
+func (l *loader) fileExists(urlPath string) bool {
+	var err error
+	if l.fsys != nil {
+		_, err = fs.Stat(l.fsys, fsPath(urlPath))
+	} else {
+		_, err = os.Stat(getPath(urlPath))
+	}
+
+	return err == nil
+}
+
+// glob returns the url paths of the files matching the pattern, which is a url path.
+func (l *loader) glob(pattern string) ([]string, error) {
+	if l.fsys != nil {
+		matches, err := fs.Glob(l.fsys, fsPath(pattern))
+		for i, match := range matches {
+			matches[i] = "/" + match
+		}
+
+		return matches, err
+	}
+
+	matches, err := filepath.Glob(getPath(pattern))
+	for i, match := range matches {
+		matches[i] = setPath(filepath.ToSlash(match))
+	}
+
+	return matches, err
+}
+
+// fsPath converts a url path to a path in the loader's file system.
+func fsPath(urlPath string) string {
+	return path.Clean(strings.TrimPrefix(urlPath, "/"))
+}
+
+// loadIncludes loads the includes of the data in turn, relative to its url, or to the base url for data that was
+// not loaded from a url.
+func (l *loader) loadIncludes(ctx gocontext.Context, parentUrls []*pkgurl.URL, url *pkgurl.URL, data *filedata, allData filedatas) (filedatas, error) {
+	type child struct {
+		url      *pkgurl.URL
+		optional bool
+	}
+
+	base := url
+	if base == nil {
+		base = l.baseURL
+	}
+
+	// the urls of every include are resolved and checked before any of them is loaded
+	children := make([]child, 0, len(data.includes))
+
+	for _, inc := range data.includes {
+		ext, err := inc.formatExt()
+		if err != nil {
+			return nil, data.wrapError(err)
+		}
+
+		urls, err := l.includeURLs(base, inc)
+		if err != nil {
+			return nil, data.wrapError(err)
+		}
+
+		for _, u := range urls {
+			children = append(children, child{url: u, optional: inc.Optional})
+
+			if ext != "" {
+				l.setFormat(u, ext)
+			}
+		}
+	}
+
+	for _, c := range children {
+		var err error
+
+		n := len(allData)
+
+		allData, err = l.loadURLRecursive(ctx, parentUrls, c.url, c.optional, allData)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := n; i < len(allData); i++ {
+			allData[i].included = true
+		}
+
+		// a missing optional include adds nothing, and has already been warned about
+		if l.checkIncludes && noData(allData[n:]) && !(c.optional && n == len(allData)) {
+			l.warn(WarnEmptyInclude, c.url, "the include adds no data")
+		}
+	}
+
+	return allData, nil
+}
+
+// tooDeep reports whether loading another level of includes below the parents exceeds the maximum depth.
+func (l *loader) tooDeep(parentUrls []*pkgurl.URL) bool {
+	max := l.maxDepth
+	if max == 0 {
+		max = defaultMaxDepth
+	}
+
+	return len(parentUrls) > max
+}
+
+// filePath returns the path of the file url in the local file system. When a file root is set, any symbolic links
+// in the path are resolved, and it is an error if the file is not within the root.
+func (l *loader) filePath(url *pkgurl.URL) (string, error) {
+	name := getPath(url.Path)
+	if l.fileRoot == "" {
+		return name, nil
+	}
+
+	root, err := resolvePath(l.fileRoot)
+	if err != nil {
+		return "", fmt.Errorf("invalid file root: %w", err)
+	}
+
+	resolved, err := resolvePath(name)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w : %v", errOutsideFiles, name)
+	}
+
+	return resolved, nil
+}
+
+func resolvePath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.EvalSymlinks(abs)
+}
+
+// checkScheme returns an error when the scheme of the url is not one of the allowed schemes, if any are set.
+func (l *loader) checkScheme(url *pkgurl.URL) error {
+	if len(l.schemes) == 0 {
+		return nil
+	}
+
+	for _, scheme := range l.schemes {
+		if strings.EqualFold(scheme, url.Scheme) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w %q : %v", errSchemeDenied, url.Scheme, redact(url))
+}
+
+// checkRoot returns an error for the first url that is not within the include root.
+func (l *loader) checkRoot(urls []*pkgurl.URL) error {
+	if l.includeRoot == nil {
+		return nil
+	}
+
+	root := l.includeRoot.Path
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+
+	for _, url := range urls {
+		if url.Scheme != l.includeRoot.Scheme || url.Host != l.includeRoot.Host || !strings.HasPrefix(url.Path, root) {
+			return fmt.Errorf("%w : %v", errOutsideRoot, redact(url))
+		}
+	}
+
+	return nil
+}
+
+// expandPath replaces $VAR and ${VAR} with the value of the environment variable.
+// Undefined variables are replaced with an empty string, or are an error in strict mode.
+func (l *loader) expandPath(path string) (string, error) {
+	var undefined []string
+
+	expanded := os.Expand(path, func(name string) string {
+		val, ok := l.lookupEnv(name)
+		if !ok {
+			undefined = append(undefined, name)
+		}
+
+		return val
+	})
+
+	if l.strictExpand && len(undefined) > 0 {
+		return "", fmt.Errorf("%w %v in path %q", errUndefinedVar, strings.Join(undefined, ", "), path)
+	}
+
+	return expanded, nil
+}
+
 func toURLs(rootURL *pkgurl.URL, paths ...string) ([]*pkgurl.URL, error) {
 	var urls []*pkgurl.URL
 
@@ -248,9 +1291,14 @@ func toURL(rootURL *pkgurl.URL, path string) (*pkgurl.URL, error) {
 		return &emptyURL, errBlankFilePath
 	}
 
+	if isStdin(path) {
+		return &pkgurl.URL{Scheme: stdinScheme, Path: path}, nil
+	}
+
 	var err error
 
-	if rootURL == nil {
+	// data read from stdin has no location, so its includes are relative to the working directory
+	if rootURL == nil || rootURL.Scheme == stdinScheme {
 		rootURL, err = workingDir()
 		if err != nil {
 			return &emptyURL, err
@@ -270,13 +1318,23 @@ func toURL(rootURL *pkgurl.URL, path string) (*pkgurl.URL, error) {
 	return url, nil
 }
 
+// isStdin reports whether the path refers to standard input, either "-" or "-" followed by an extension
+// such as "-.yaml" to choose the format of the data.
+func isStdin(path string) bool {
+	return path == stdinPath || (strings.HasPrefix(path, stdinPath+".") && !strings.ContainsAny(path, `/\`))
+}
+
+// containsURL reports whether the url is in the list, where urls that normalize to the same url are the same
+// resource, e.g. urls differing only by credentials, dot segments in the path or the order of the query.
 func containsURL(searchURL *pkgurl.URL, urls []*pkgurl.URL) bool {
 	if searchURL == nil {
 		return false
 	}
 
+	search := normalizeURL(searchURL)
+
 	for _, u := range urls {
-		if *u == *searchURL {
+		if normalizeURL(u) == search {
 			return true
 		}
 	}
@@ -284,6 +1342,50 @@ func containsURL(searchURL *pkgurl.URL, urls []*pkgurl.URL) bool {
 	return false
 }
 
+// normalizeURL returns the url without credentials, with a clean path, query parameters in sorted order, and the
+// scheme and host in lower case. File paths on windows are also in lower case, as they are case insensitive.
+func normalizeURL(url *pkgurl.URL) pkgurl.URL {
+	u := withoutUser(url)
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.RawPath = ""
+	u.RawQuery = u.Query().Encode()
+	u.ForceQuery = false
+
+	if u.Path == "" {
+		return u
+	}
+
+	if u.Scheme != "file" || goos != windowsOS {
+		u.Path = path.Clean(u.Path)
+
+		return u
+	}
+
+	filePath := strings.Replace(getPath(u.Path), `\`, `/`, -1)
+	u.Path = strings.ToLower(setPath(path.Clean(filePath)))
+
+	return u
+}
+
+func withoutUser(url *pkgurl.URL) pkgurl.URL {
+	u := *url
+	u.User = nil
+
+	return u
+}
+
+// redact formats the url without any credentials, so they do not leak into errors and logs.
+func redact(url *pkgurl.URL) string {
+	if url == nil {
+		return "<nil>"
+	}
+
+	u := withoutUser(url)
+
+	return u.String()
+}
+
 func workingDir() (*pkgurl.URL, error) {
 	rootPath, err := getwd()
 	if err != nil {
@@ -0,0 +1,75 @@
+package conflate
+
+import (
+	gocontext "context"
+	"fmt"
+	pkgurl "net/url"
+)
+
+// FromManifest constructs a new Conflate instance populated with the data from the sources listed in the manifest,
+// see AddManifest.
+func FromManifest(path string) (*Conflate, error) {
+	c := New()
+
+	err := c.AddManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// AddManifest merges the data from each source listed in the manifest at the path or url, in order, so that the sources
+// do not need an includes key of their own. The manifest is a JSON or YAML array, which lists the sources in the same
+// way as an includes array, e.g. ["base.json", {"url": "local.yaml", "optional": true}], with each source relative
+// to the manifest. A source can also give its format, e.g. {"url": "app.conf", "format": "toml"}. The manifest
+// can be loaded from any url that data can, and the includes of the sources are loaded as usual.
+func (c *Conflate) AddManifest(path string) error {
+	return c.AddManifestContext(c.ctx, path)
+}
+
+// AddManifestContext merges the data from each source listed in the manifest, as for AddManifest.
+// Remote fetches are aborted when the context is done.
+func (c *Conflate) AddManifestContext(ctx gocontext.Context, path string) error {
+	urls, err := c.loader.toURLs(nil, path)
+	if err != nil {
+		return err
+	}
+
+	if err := c.lockUnfrozen(); err != nil {
+		return err
+	}
+	defer c.mu.Unlock()
+	defer c.loader.close()
+
+	var data filedatas
+
+	for _, url := range urls {
+		data, err = c.loader.loadManifest(ctx, url, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.observeMerge(ctx, data...)
+}
+
+// loadManifest appends the data of each source listed in the manifest at the url to allData, loading the sources
+// as if they were the includes of the manifest.
+func (l *loader) loadManifest(ctx gocontext.Context, url *pkgurl.URL, allData filedatas) (filedatas, error) {
+	data, err := l.loadURLCached(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := filedata{data: data, url: url}
+
+	err = YAMLUnmarshal(data, &manifest.includes)
+	if err != nil {
+		return nil, newParseError(url, data, fmt.Errorf("the manifest must be an array of sources: %w", err))
+	}
+
+	parentUrls := append(l.parentStack(nil), url)
+
+	return l.loadIncludes(ctx, parentUrls, url, &manifest, allData)
+}
@@ -0,0 +1,57 @@
+package conflate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflate_AddManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"etc/manifest.yaml": {Data: []byte(`
+- base.json
+- url: app/app.conf
+  format: TOML
+- url: local.yaml
+  optional: true
+- "?missing.json"
+`)},
+		"etc/base.json":       {Data: []byte(`{"includes": ["shared.json"], "name": "base", "port": 80}`)},
+		"etc/shared.json":     {Data: []byte(`{"shared": true}`)},
+		"etc/app/app.conf":    {Data: []byte("name = \"app\"\n")},
+		"etc/other.json":      {Data: []byte(`["base.json", {"url": "other.json"}]`)},
+		"etc/bad.json":        {Data: []byte(`{"sources": ["base.json"]}`)},
+		"etc/bad-format.json": {Data: []byte(`[{"url": "base.json", "format": "xml"}]`)},
+	}
+
+	c := New(WithFS(fsys))
+
+	err := c.AddManifest("etc/manifest.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "app", "port": 80.0, "shared": true}, c.Data())
+	assert.Len(t, c.Sources(), 3)
+
+	err = New(WithFS(fsys)).AddManifest("etc/other.json")
+	assert.ErrorIs(t, err, errRecursiveURL)
+
+	err = New(WithFS(fsys)).AddManifest("etc/bad.json")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "the manifest must be an array of sources")
+
+	err = New(WithFS(fsys)).AddManifest("etc/bad-format.json")
+	assert.ErrorIs(t, err, errIncludeFormat)
+
+	err = New(WithFS(fsys)).AddManifest("etc/missing.yaml")
+	assert.NotNil(t, err)
+}
+
+func TestFromManifest(t *testing.T) {
+	c, err := FromManifest("testdata/manifest.json")
+	assert.Nil(t, err)
+	assert.Equal(t, "child", c.Data()["child_only"])
+	assert.Equal(t, "parent", c.Data()["all"])
+
+	_, err = FromManifest("testdata/missing.json")
+	assert.NotNil(t, err)
+}
@@ -1,16 +1,60 @@
 package conflate
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/ghodss/yaml"
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/hcl/hcl/ast"
+	yaml3 "gopkg.in/yaml.v3"
 )
 
-var errToml = errors.New("the data could not be marshalled to toml")
+var (
+	errToml         = errors.New("the data could not be marshalled to toml")
+	errYAMLAnchor   = errors.New("the yaml alias refers to an unknown anchor")
+	errTrailingData = errors.New("invalid data after the top-level value")
+)
+
+// UseNumber decodes the numbers in JSON and YAML data as json.Number rather than float64, so that integers such as
+// 64 bit ids keep their exact value through merging and marshalling. Numbers of different types, e.g. a json.Number
+// and a TOML integer, can be merged with each other.
+var UseNumber = false
+
+var (
+	yamlDocumentStart = regexp.MustCompile(`^---(\s|$)`)
+	yamlUnknownAnchor = regexp.MustCompile(`unknown anchor '([^']*)' referenced`)
+)
+
+// JSONOptions configures how data is marshalled to JSON.
+type JSONOptions struct {
+	// Prefix starts each line, and Indent is repeated for each level of nesting. The output is compact when both are blank.
+	Prefix string
+	Indent string
+	// EscapeHTML escapes the characters <, > and & within strings.
+	EscapeHTML bool
+}
+
+var defaultJSONOptions = JSONOptions{Indent: "  "}
+
+// YAMLOptions configures how data is marshalled to YAML. With the zero value, objects and arrays are written in block
+// style, with a line for each value, indented by two spaces, and the items of an array are not indented within
+// their key.
+type YAMLOptions struct {
+	// Indent is the number of spaces for each level of nesting, which is two when it is zero. The items of an array
+	// are indented within their key.
+	Indent int
+	// Flow writes the objects and arrays within the top level in flow style, e.g. {a: 1, b: 2}, rather than block style.
+	Flow bool
+}
 
 func jsonMarshalAll(data ...interface{}) ([][]byte, error) {
 	var outs [][]byte
@@ -37,8 +81,15 @@ func jsonMarshalUnmarshal(in, out interface{}) error {
 }
 
 // JSONUnmarshal unmarshals the data as JSON.
+// Numbers are decoded as json.Number when UseNumber is set.
 func JSONUnmarshal(data []byte, out interface{}) error {
-	err := json.Unmarshal(data, out)
+	var err error
+	if UseNumber {
+		err = jsonDecodeNumbers(data, out)
+	} else {
+		err = json.Unmarshal(data, out)
+	}
+
 	if err != nil {
 		return fmt.Errorf("the data could not be unmarshalled as json: %w", err)
 	}
@@ -46,11 +97,132 @@ func JSONUnmarshal(data []byte, out interface{}) error {
 	return nil
 }
 
+// jsonDecodeNumbers decodes the data as json.Unmarshal does, but with numbers as json.Number.
+func jsonDecodeNumbers(data []byte, out interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	err := decoder.Decode(out)
+	if err != nil {
+		return err
+	}
+
+	// as for json.Unmarshal, nothing but whitespace may follow the value
+	_, err = decoder.Token()
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil
+	case err != nil:
+		return err
+	default:
+		return fmt.Errorf("%w at offset %v", errTrailingData, decoder.InputOffset())
+	}
+}
+
 // YAMLUnmarshal unmarshals the data as YAML.
+// A stream of several documents separated by '---' is merged in order with the default merge options. Conflate
+// instead loads each document of a stream as a separate source, merged with the merge options of the instance.
+// Anchors, aliases and merge keys are resolved as each document is decoded, before it is merged, so an alias can only
+// refer to an anchor in the same document.
 func YAMLUnmarshal(data []byte, out interface{}) error {
-	err := yaml.Unmarshal(data, out)
+	docs := yamlDocuments(data)
+	if len(docs) == 1 {
+		return yamlUnmarshal(data, out)
+	}
+
+	var merged interface{}
+
+	for _, doc := range docs {
+		var obj interface{}
+
+		err := yamlUnmarshal(doc, &obj)
+		if err != nil {
+			return err
+		}
+
+		err = merger{}.merge(&merged, obj)
+		if err != nil {
+			return fmt.Errorf("the yaml documents could not be merged: %w", err)
+		}
+	}
+
+	return jsonMarshalUnmarshal(merged, out)
+}
+
+// yamlUnmarshal unmarshals a single YAML document, resolving any anchors, aliases and merge keys in it.
+func yamlUnmarshal(data []byte, out interface{}) error {
+	var err error
+	if UseNumber {
+		err = yamlDecodeNumbers(data, out)
+	} else {
+		err = yaml.Unmarshal(data, out)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	// anchors are resolved as each document is decoded, before the documents are merged
+	if m := yamlUnknownAnchor.FindStringSubmatch(err.Error()); m != nil {
+		return fmt.Errorf("%w *%v, as anchors can only be used within the document defining them, "+
+			"not in other documents or files", errYAMLAnchor, m[1])
+	}
+
+	return fmt.Errorf("the data could not be unmarshalled as yaml: %w", err)
+}
+
+// yamlDocuments splits a YAML stream into its documents. Each document keeps its line numbers from the stream,
+// so that errors report the line in the stream, and a document is not split again by its leading blank lines.
+func yamlDocuments(data []byte) [][]byte {
+	var docs [][]byte
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	start := 0
+
+	for i, line := range lines {
+		if !yamlDocumentStart.Match(line) || yamlBlank(lines[start:i]) {
+			continue
+		}
+
+		docs = append(docs, yamlDocument(lines, start, i))
+		start = i
+	}
+
+	return append(docs, yamlDocument(lines, start, len(lines)))
+}
+
+// yamlBlank returns true when the lines are all blank.
+func yamlBlank(lines [][]byte) bool {
+	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// yamlDocument joins the lines of a document, preceded by blank lines in place of the lines before it.
+func yamlDocument(lines [][]byte, start, end int) []byte {
+	doc := bytes.Repeat([]byte("\n"), start)
+	for _, line := range lines[start:end] {
+		doc = append(doc, line...)
+	}
+
+	return doc
+}
+
+// yamlDecodeNumbers decodes the data as yaml.Unmarshal does, but with numbers as json.Number.
+func yamlDecodeNumbers(data []byte, out interface{}) error {
+	j, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return fmt.Errorf("error converting YAML to JSON: %w", err)
+	}
+
+	err = jsonDecodeNumbers(j, out)
 	if err != nil {
-		return fmt.Errorf("the data could not be unmarshalled as yaml: %w", err)
+		return fmt.Errorf("error unmarshaling JSON: %w", err)
 	}
 
 	return nil
@@ -66,11 +238,104 @@ func TOMLUnmarshal(data []byte, out interface{}) error {
 	return nil
 }
 
+// HCLUnmarshal unmarshals the data as HCL.
+// Each block is decoded as an object, nested within an object for each of its labels, e.g. service "web" { port = 80 }
+// as {"service": {"web": {"port": 80}}}, so that blocks merge with the objects of other formats. A block repeated with
+// the same labels is decoded as an array of objects. Numbers are decoded as for JSON so that they merge with other
+// formats. HCL in JSON syntax is unmarshalled as JSON.
+func HCLUnmarshal(data []byte, out interface{}) (err error) {
+	// hcl flattens the arrays of objects of JSON, which are kept as they are
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		err = JSONUnmarshal(data, out)
+		if err != nil {
+			return fmt.Errorf("the data could not be unmarshalled as hcl: %w", err)
+		}
+
+		return nil
+	}
+
+	file, err := hcl.ParseBytes(data)
+	if err != nil {
+		return fmt.Errorf("the data could not be unmarshalled as hcl: %w", err)
+	}
+
+	// hcl panics on literals that it has parsed but cannot convert
+	defer func() {
+		if isPanicking := recover(); isPanicking != nil {
+			err = fmt.Errorf("the data could not be unmarshalled as hcl: %v", isPanicking)
+		}
+	}()
+
+	return jsonMarshalUnmarshal(hclValue(file.Node), out)
+}
+
+// hclValue converts a node of the hcl syntax tree to the value it represents.
+func hclValue(node ast.Node) interface{} {
+	switch n := node.(type) {
+	case *ast.ObjectType:
+		return hclValue(n.List)
+	case *ast.ObjectList:
+		obj := map[string]interface{}{}
+		for _, item := range n.Items {
+			keys := make([]string, 0, len(item.Keys))
+			for _, key := range item.Keys {
+				keys = append(keys, fmt.Sprint(key.Token.Value()))
+			}
+
+			hclSet(obj, keys, hclValue(item.Val))
+		}
+
+		return obj
+	case *ast.ListType:
+		list := make([]interface{}, 0, len(n.List))
+		for _, item := range n.List {
+			list = append(list, hclValue(item))
+		}
+
+		return list
+	case *ast.LiteralType:
+		return n.Token.Value()
+	default:
+		return nil
+	}
+}
+
+// hclSet sets the value at the keys of an hcl item within the object, where any keys after the first are the labels
+// of a block. An object set again at the same keys is added to an array of the objects, as a repeated block.
+func hclSet(obj map[string]interface{}, keys []string, value interface{}) {
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := obj[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			obj[key] = child
+		}
+
+		obj = child
+	}
+
+	key := keys[len(keys)-1]
+	if _, ok := value.(map[string]interface{}); ok {
+		switch existing := obj[key].(type) {
+		case map[string]interface{}:
+			value = []interface{}{existing, value}
+		case []interface{}:
+			value = append(existing, value)
+		}
+	}
+
+	obj[key] = value
+}
+
 func jsonMarshal(data interface{}) ([]byte, error) {
+	return jsonMarshalWith(defaultJSONOptions, data)
+}
+
+func jsonMarshalWith(opts JSONOptions, data interface{}) ([]byte, error) {
 	buffer := bytes.Buffer{}
+
 	encoder := json.NewEncoder(&buffer)
-	encoder.SetEscapeHTML(false)
-	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(opts.EscapeHTML)
+	encoder.SetIndent(opts.Prefix, opts.Indent)
 
 	err := encoder.Encode(data)
 	if err != nil {
@@ -80,6 +345,172 @@ func jsonMarshal(data interface{}) ([]byte, error) {
 	return buffer.Bytes(), nil
 }
 
+// jsonStream writes data as JSON in the same way as jsonMarshalWith, but writes the objects and arrays of the data
+// value by value, so that only one of their other values is marshalled in memory at a time.
+type jsonStream struct {
+	w    *bufio.Writer
+	out  *stickyWriter
+	opts JSONOptions
+	buf  bytes.Buffer
+	enc  *json.Encoder
+}
+
+// stickyWriter keeps the first error writing to a writer.
+type stickyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (w *stickyWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.w.Write(p)
+	w.err = err
+
+	return n, err
+}
+
+func jsonWrite(w io.Writer, opts JSONOptions, data interface{}) error {
+	out := &stickyWriter{w: w}
+	s := &jsonStream{w: bufio.NewWriter(out), out: out, opts: opts}
+	s.enc = json.NewEncoder(&s.buf)
+	s.enc.SetEscapeHTML(opts.EscapeHTML)
+
+	// the writer keeps the first error writing, which is returned by Flush
+	err := s.value(data, 0)
+	if err == nil {
+		_ = s.w.WriteByte('\n')
+		err = s.w.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf("the data could not be marshalled to json: %w", err)
+	}
+
+	return nil
+}
+
+func (s *jsonStream) value(data interface{}, depth int) error {
+	switch node := data.(type) {
+	case map[string]interface{}:
+		if node == nil || len(node) == 0 {
+			return s.leaf(data, depth)
+		}
+
+		names := make([]string, 0, len(node))
+		for name := range node {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		_ = s.w.WriteByte('{')
+
+		for i, name := range names {
+			s.separator(i, depth+1)
+
+			err := s.leaf(name, depth+1)
+			if err != nil {
+				return err
+			}
+
+			_ = s.w.WriteByte(':')
+			if s.indented() {
+				_ = s.w.WriteByte(' ')
+			}
+
+			err = s.value(node[name], depth+1)
+			if err != nil {
+				return err
+			}
+		}
+
+		s.newline(depth)
+		_ = s.w.WriteByte('}')
+	case []interface{}:
+		if len(node) == 0 {
+			return s.leaf(data, depth)
+		}
+
+		_ = s.w.WriteByte('[')
+
+		for i, item := range node {
+			s.separator(i, depth+1)
+
+			err := s.value(item, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+
+		s.newline(depth)
+		_ = s.w.WriteByte(']')
+	default:
+		return s.leaf(data, depth)
+	}
+
+	return s.flushed()
+}
+
+// leaf writes a value that is not an object or an array of the data, indenting any object or array within it.
+func (s *jsonStream) leaf(data interface{}, depth int) error {
+	s.buf.Reset()
+
+	err := s.enc.Encode(data)
+	if err != nil {
+		return err
+	}
+
+	out := bytes.TrimSuffix(s.buf.Bytes(), []byte("\n"))
+
+	if s.indented() && (out[0] == '{' || out[0] == '[') {
+		var indented bytes.Buffer
+
+		err = json.Indent(&indented, out, s.opts.Prefix+strings.Repeat(s.opts.Indent, depth), s.opts.Indent)
+		if err != nil {
+			return err
+		}
+
+		out = indented.Bytes()
+	}
+
+	_, _ = s.w.Write(out)
+
+	return nil
+}
+
+func (s *jsonStream) separator(i, depth int) {
+	if i > 0 {
+		_ = s.w.WriteByte(',')
+	}
+
+	s.newline(depth)
+}
+
+func (s *jsonStream) newline(depth int) {
+	if !s.indented() {
+		return
+	}
+
+	_ = s.w.WriteByte('\n')
+	_, _ = s.w.WriteString(s.opts.Prefix)
+
+	for i := 0; i < depth; i++ {
+		_, _ = s.w.WriteString(s.opts.Indent)
+	}
+}
+
+func (s *jsonStream) indented() bool {
+	return s.opts.Prefix != "" || s.opts.Indent != ""
+}
+
+// flushed returns the first error writing to the writer, so that writing stops once it has failed.
+func (s *jsonStream) flushed() error {
+	return s.out.err
+}
+
 func yamlMarshal(in interface{}) ([]byte, error) {
 	data, err := yaml.Marshal(in)
 	if err != nil {
@@ -89,22 +520,148 @@ func yamlMarshal(in interface{}) ([]byte, error) {
 	return data, nil
 }
 
-func tomlMarshal(in interface{}) (out []byte, err error) {
+// yamlMarshalWith marshals the data with the yaml.v3 encoder, which unlike the default encoder can set the
+// indentation and the style of each node. The data is marshalled to JSON and decoded as YAML first, so that it is
+// written the same way as by yamlMarshal, e.g. with json.Number as a number and object keys sorted.
+func yamlMarshalWith(opts YAMLOptions, in interface{}) ([]byte, error) {
+	if opts == (YAMLOptions{}) {
+		return yamlMarshal(in)
+	}
+
+	data, err := jsonMarshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("the data could not be marshalled to yaml: %w", err)
+	}
+
+	var node yaml3.Node
+
+	err = yaml3.Unmarshal(data, &node)
+	if err != nil {
+		return nil, fmt.Errorf("the data could not be marshalled to yaml: %w", err)
+	}
+
+	yamlStyle(&node, opts.Flow, true)
+
+	indent := opts.Indent
+	if indent == 0 {
+		indent = 2
+	}
+
+	buf := bytes.Buffer{}
+	enc := yaml3.NewEncoder(&buf)
+	enc.SetIndent(indent)
+
+	err = enc.Encode(&node)
+	if err == nil {
+		err = enc.Close()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("the data could not be marshalled to yaml: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// yamlStyle sets the style of the node and the nodes within it, as they keep the flow style and quoting of the JSON
+// they were decoded from. Scalars are left for the encoder to quote only where they need it, other than strings
+// that would be read as another type by the YAML 1.1 decoder used to unmarshal YAML, e.g. yes and on.
+func yamlStyle(node *yaml3.Node, flow, top bool) {
+	node.Style = 0
+
+	switch node.Kind {
+	case yaml3.ScalarNode:
+		if node.Tag == "!!str" && !strings.Contains(node.Value, "\n") {
+			var val interface{}
+			if err := yaml.Unmarshal([]byte(node.Value), &val); err != nil || val != node.Value {
+				node.Style = yaml3.DoubleQuotedStyle
+			}
+		}
+	case yaml3.DocumentNode:
+		for _, child := range node.Content {
+			yamlStyle(child, flow, true)
+		}
+
+		return
+	case yaml3.MappingNode, yaml3.SequenceNode:
+		if flow && !top {
+			node.Style = yaml3.FlowStyle
+		}
+	}
+
+	for _, child := range node.Content {
+		yamlStyle(child, flow, false)
+	}
+}
+
+func yamlWrite(w io.Writer, opts YAMLOptions, in interface{}) error {
+	data, err := yamlMarshalWith(opts, in)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+func tomlMarshal(in interface{}) ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	err := tomlWrite(&buf, in)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func tomlWrite(w io.Writer, in interface{}) (err error) {
 	defer func() {
 		if isPanicking := recover(); isPanicking != nil {
 			err = fmt.Errorf("%w : %v", errToml, isPanicking)
 		}
 	}()
 
-	buf := bytes.Buffer{}
-	enc := toml.NewEncoder(&buf)
+	enc := toml.NewEncoder(w)
 
-	err = enc.Encode(in)
+	err = enc.Encode(tomlNumbers(in))
 	if err != nil {
-		return nil, fmt.Errorf("the data could not be marshalled to toml: %w", err)
+		return fmt.Errorf("the data could not be marshalled to toml: %w", err)
 	}
 
-	out = buf.Bytes()
+	return nil
+}
+
+// tomlNumbers returns a copy of the data with each json.Number converted to an int64, or a float64 if it is not an
+// integer, as the TOML encoder would otherwise write them as strings.
+func tomlNumbers(data interface{}) interface{} {
+	switch val := data.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+
+		if f, err := val.Float64(); err == nil {
+			return f
+		}
+
+		return val
+	case map[string]interface{}:
+		props := make(map[string]interface{}, len(val))
+		for name, prop := range val {
+			props[name] = tomlNumbers(prop)
+		}
 
-	return out, nil
+		return props
+	case []interface{}:
+		items := make([]interface{}, len(val))
+		for i, item := range val {
+			items[i] = tomlNumbers(item)
+		}
+
+		return items
+	default:
+		return data
+	}
 }
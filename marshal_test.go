@@ -1,7 +1,10 @@
 package conflate
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
+	"testing/fstest"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -69,6 +72,62 @@ func TestJSONUnmarshal_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "could not be unmarshalled as json")
 }
 
+func TestJSONUnmarshal_UseNumber(t *testing.T) {
+	UseNumber = true
+
+	defer func() { UseNumber = false }()
+
+	var out interface{}
+
+	err := JSONUnmarshal([]byte(`{"id": 12345678901234567890, "f": 1.5, "s": "1"}`), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"id": json.Number("12345678901234567890"),
+		"f":  json.Number("1.5"),
+		"s":  "1",
+	}, out)
+
+	var typed struct{ ID uint64 }
+
+	err = JSONUnmarshal([]byte(`{"id": 12345678901234567890}`), &typed)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(12345678901234567890), typed.ID)
+
+	err = JSONUnmarshal([]byte(`{} {}`), &out)
+	assert.ErrorIs(t, err, errTrailingData)
+
+	err = JSONUnmarshal([]byte("{}\n x"), &out)
+	assert.Contains(t, err.Error(), "invalid character")
+
+	err = JSONUnmarshal([]byte(" {} \n"), &out)
+	assert.Nil(t, err)
+}
+
+func TestYAMLUnmarshal_UseNumber(t *testing.T) {
+	UseNumber = true
+
+	defer func() { UseNumber = false }()
+
+	var out interface{}
+
+	err := YAMLUnmarshal([]byte("id: 9007199254740993\nf: 1.5\n"), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"id": json.Number("9007199254740993"), "f": json.Number("1.5")}, out)
+
+	err = YAMLUnmarshal([]byte("a: [\n"), &out)
+	assert.Contains(t, err.Error(), "could not be unmarshalled as yaml")
+}
+
+func TestTOMLMarshal_Numbers(t *testing.T) {
+	out, err := tomlMarshal(map[string]interface{}{
+		"id": json.Number("9007199254740993"),
+		"f":  json.Number("1.5"),
+		"l":  []interface{}{json.Number("1")},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "f = 1.5\nid = 9007199254740993\nl = [1]\n", string(out))
+}
+
 func TestYAMLUnmarshal(t *testing.T) {
 	var out interface{}
 
@@ -85,6 +144,119 @@ func TestYAMLUnmarshal_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "could not be unmarshalled as yaml")
 }
 
+func TestYAMLUnmarshal_Anchors(t *testing.T) {
+	var out interface{}
+
+	err := YAMLUnmarshal([]byte("base: &base\n  a: 1\n  b: 2\nchild:\n  <<: *base\n  b: 3\nlist: [*base]\n"), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"base":  map[string]interface{}{"a": 1.0, "b": 2.0},
+		"child": map[string]interface{}{"a": 1.0, "b": 3.0},
+		"list":  []interface{}{map[string]interface{}{"a": 1.0, "b": 2.0}},
+	}, out)
+
+	err = YAMLUnmarshal([]byte("child:\n  <<: *base\n"), &out)
+	assert.ErrorIs(t, err, errYAMLAnchor)
+	assert.Contains(t, err.Error(), "*base")
+
+	// anchors do not carry over to the following documents
+	err = YAMLUnmarshal([]byte("a: &x 1\n---\nb: *x\n"), &out)
+	assert.ErrorIs(t, err, errYAMLAnchor)
+}
+
+func TestYAMLUnmarshal_MultipleDocuments(t *testing.T) {
+	var out interface{}
+
+	err := YAMLUnmarshal([]byte("---\na: 1\nlist: [1]\nobj: {x: 1}\n---\n# empty\n--- {b: 2}\n---\na: 3\nlist: [2]\nobj: {z: 2}\n...\n"), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a":    3.0,
+		"b":    2.0,
+		"list": []interface{}{1.0, 2.0},
+		"obj":  map[string]interface{}{"x": 1.0, "z": 2.0},
+	}, out)
+
+	err = YAMLUnmarshal([]byte("---\n---\n"), &out)
+	assert.Nil(t, err)
+	assert.Nil(t, out)
+
+	err = YAMLUnmarshal([]byte("a: 1\n---\na: [\n"), &out)
+	assert.Contains(t, err.Error(), "could not be unmarshalled as yaml")
+	assert.Contains(t, err.Error(), "line 3")
+
+	err = YAMLUnmarshal([]byte("a: 1\n---\na: {b: 1}\n"), &out)
+	assert.Contains(t, err.Error(), "could not be merged")
+}
+
+func TestYAMLDocuments(t *testing.T) {
+	assert.Equal(t, [][]byte{[]byte("a: 1\n")}, yamlDocuments([]byte("a: 1\n")))
+	assert.Equal(t, [][]byte{[]byte("---\na: 1\n")}, yamlDocuments([]byte("---\na: 1\n")))
+	assert.Equal(t, [][]byte{[]byte("a: |\n  ---x\n"), []byte("\n\n--- \nb: 1")},
+		yamlDocuments([]byte("a: |\n  ---x\n--- \nb: 1")))
+	assert.Equal(t, [][]byte{[]byte("\n\n--- \nb: 1")}, yamlDocuments([]byte("\n\n--- \nb: 1")))
+}
+
+func TestHCLUnmarshal(t *testing.T) {
+	var out interface{}
+
+	err := HCLUnmarshal([]byte(`
+port = 80
+ratio = 0.5
+debug = true
+tags = ["a", "b"]
+items = [{ name = "x" }]
+server { host = "a" }
+service "web" "blue" { port = 8080 }
+service "api" { port = 9090 }
+listener { port = 1 }
+listener { port = 2 }
+`), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"port":   80.0,
+		"ratio":  0.5,
+		"debug":  true,
+		"tags":   []interface{}{"a", "b"},
+		"items":  []interface{}{map[string]interface{}{"name": "x"}},
+		"server": map[string]interface{}{"host": "a"},
+		"service": map[string]interface{}{
+			"web": map[string]interface{}{"blue": map[string]interface{}{"port": 8080.0}},
+			"api": map[string]interface{}{"port": 9090.0},
+		},
+		"listener": []interface{}{map[string]interface{}{"port": 1.0}, map[string]interface{}{"port": 2.0}},
+	}, out)
+}
+
+func TestHCLUnmarshal_JSON(t *testing.T) {
+	var out interface{}
+
+	err := HCLUnmarshal([]byte(`{"db": {"host": "x"}, "items": [{"name": "x"}]}`), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"db":    map[string]interface{}{"host": "x"},
+		"items": []interface{}{map[string]interface{}{"name": "x"}},
+	}, out)
+}
+
+func TestConflate_HCLOverriddenByJSON(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.hcl":      {Data: []byte("db {\n  host = \"x\"\n  port = 5432\n}\n")},
+		"override.json": {Data: []byte(`{"db": {"host": "y"}}`)},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddFiles("base.hcl", "override.json"))
+	assert.Equal(t, map[string]interface{}{"db": map[string]interface{}{"host": "y", "port": 5432.0}}, c.Data())
+}
+
+func TestHCLUnmarshal_Error(t *testing.T) {
+	var out interface{}
+
+	err := HCLUnmarshal(testMarshalInvalid, &out)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not be unmarshalled as hcl")
+}
+
 func TestTOMLUnmarshal(t *testing.T) {
 	var out interface{}
 
@@ -117,6 +289,57 @@ func TestJSONMarshal_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "marshalled to json")
 }
 
+func TestJSONWrite(t *testing.T) {
+	data := []interface{}{
+		testMarshalData,
+		map[string]interface{}{},
+		[]interface{}{},
+		map[string]interface{}(nil),
+		[]interface{}(nil),
+		"<a&b>",
+		json.Number("1.50"),
+		map[string]interface{}{
+			"html":   "<a&b>",
+			"empty":  map[string]interface{}{},
+			"none":   []interface{}{},
+			"nil":    nil,
+			"number": json.Number("2"),
+			"nested": []interface{}{[]interface{}{1, "x"}, map[string]interface{}{"b": true, "a": 1.5}},
+			"struct": struct {
+				Name string   `json:"name"`
+				List []string `json:"list"`
+			}{Name: "s", List: []string{"a", "b"}},
+		},
+	}
+	opts := []JSONOptions{
+		defaultJSONOptions,
+		{},
+		{Prefix: "> ", Indent: "\t", EscapeHTML: true},
+		{Prefix: "# "},
+	}
+
+	for _, o := range opts {
+		for _, d := range data {
+			want, err := jsonMarshalWith(o, d)
+			assert.Nil(t, err)
+
+			var buf bytes.Buffer
+
+			err = jsonWrite(&buf, o, d)
+			assert.Nil(t, err)
+			assert.Equal(t, string(want), buf.String())
+		}
+	}
+}
+
+func TestJSONWrite_Error(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := jsonWrite(&buf, defaultJSONOptions, testMarshalDataInvalid)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "marshalled to json")
+}
+
 func TestYAMLMarshal(t *testing.T) {
 	out, err := yamlMarshal(testMarshalData)
 	assert.Nil(t, err)
@@ -130,6 +353,33 @@ func TestYAMLMarshal_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "marshalled to yaml")
 }
 
+func TestYAMLMarshalWith(t *testing.T) {
+	data := map[string]interface{}{
+		"a": map[string]interface{}{"b": 1.0, "c": "true", "d": "x\ny"},
+		"e": []interface{}{map[string]interface{}{"f": json.Number("123456789012345678")}},
+		"g": map[string]interface{}{},
+	}
+
+	out, err := yamlMarshalWith(YAMLOptions{Indent: 4}, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "a:\n    b: 1\n    c: \"true\"\n    d: |-\n        x\n        y\ne:\n  - f: 123456789012345678\ng: {}\n",
+		string(out))
+
+	out, err = yamlMarshalWith(YAMLOptions{Flow: true}, data)
+	assert.Nil(t, err)
+	assert.Equal(t, "a: {b: 1, c: \"true\", d: \"x\\ny\"}\ne: [{f: 123456789012345678}]\ng: {}\n", string(out))
+
+	out, err = yamlMarshalWith(YAMLOptions{}, data)
+	assert.Nil(t, err)
+
+	expected, err := yamlMarshal(data)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expected), string(out))
+
+	_, err = yamlMarshalWith(YAMLOptions{Indent: 2}, testMarshalDataInvalid)
+	assert.Contains(t, err.Error(), "marshalled to yaml")
+}
+
 func TestTOMLMarshal(t *testing.T) {
 	out, err := tomlMarshal(testMarshalData)
 	assert.Nil(t, err)
@@ -151,6 +401,14 @@ func TestTOMLMarshal_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "marshalled to toml")
 }
 
+func TestTOMLMarshal_NonStringKeyError(t *testing.T) {
+	in := map[string]interface{}{"key": map[int]interface{}{1: "one"}}
+	out, err := tomlMarshal(in)
+	assert.NotNil(t, err)
+	assert.Nil(t, out)
+	assert.Contains(t, err.Error(), "non-string key")
+}
+
 // --------
 
 var (
@@ -1,15 +1,117 @@
 package conflate
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
+	pkgurl "net/url"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/mitchellh/hashstructure/v2"
 )
 
+// MergeOptions configures how the data from each source is merged together.
+type MergeOptions struct {
+	// ArrayKeys maps the JSON pointer of an array to the property used to match its items, e.g. {"/servers": "name"}.
+	// The pointers of these options are as in RFC 6901, where a '/' in a name is written as ~1 and a '~' as ~0.
+	// Items with the same value for the property are merged rather than both being kept.
+	// Arrays without an entry match items on their "id", "refId" or "name" properties.
+	ArrayKeys map[string]string
+	// ArrayStrategy is how arrays are merged, unless overridden for the JSON pointer of the array in ArrayStrategies.
+	ArrayStrategy   ArrayStrategy
+	ArrayStrategies map[string]ArrayStrategy
+	// Funcs maps the JSON pointer of a value to a function used to merge it, in place of the default merge.
+	Funcs map[string]MergeFunc
+	// Resolver, when set, is called whenever the incoming value would overwrite a different existing value,
+	// i.e. unless both are objects or both are arrays, which are merged. Funcs take precedence over it.
+	Resolver Resolver
+	// NullDelete removes a property from the merged data when a source explicitly sets it to null,
+	// rather than keeping the value from earlier sources.
+	NullDelete bool
+	// CaseInsensitive merges object properties whose names differ only by case, e.g. Timeout and timeout.
+	// The name that was merged first is kept, with the properties of each object taken in sorted order, so that
+	// Timeout is kept over timeout when both are in the same object. Objects within arrays keep their names as they
+	// are, unless they are merged with another item.
+	CaseInsensitive bool
+}
+
+// MergeFunc merges the incoming value from a source into the existing value, returning the merged value.
+type MergeFunc func(existing, incoming interface{}) (interface{}, error)
+
+// Resolver decides the value at the JSON pointer when the incoming value from a source conflicts with the existing
+// value, returning the merged value, or ErrDefaultMerge to merge them as if there was no resolver.
+type Resolver func(pointer string, existing, incoming interface{}) (interface{}, error)
+
+// ErrDefaultMerge is returned by a Resolver to leave the values to the default merge.
+var ErrDefaultMerge = errors.New("use the default merge")
+
+// ArrayStrategy defines how an array is merged with the array at the same location in a later source.
+type ArrayStrategy int
+
+const (
+	// ArrayUnion keeps the items of both arrays, merging items with matching keys and skipping duplicates.
+	ArrayUnion ArrayStrategy = iota
+	// ArrayReplace uses the array from the later source in place of the earlier one.
+	ArrayReplace
+	// ArrayAppend adds all the items of the later array after those of the earlier one.
+	ArrayAppend
+)
+
+// MergeAction describes a change made when merging a value.
+type MergeAction string
+
+const (
+	// MergeAdd is a value being set where there was none.
+	MergeAdd MergeAction = "add"
+	// MergeOverwrite is a value being replaced by a different one.
+	MergeOverwrite MergeAction = "overwrite"
+	// MergeAppend is an array gaining items from a later array.
+	MergeAppend MergeAction = "append"
+	// MergeDelete is a property being removed by an explicit null.
+	MergeDelete MergeAction = "delete"
+)
+
+// MergeChange is a single change made when merging data, as reported by MergePreview.
+type MergeChange struct {
+	Pointer  string
+	Action   MergeAction
+	OldValue interface{}
+	NewValue interface{}
+	Source   *pkgurl.URL
+}
+
+var errTopLevelConflict = errors.New("a top level array cannot be merged with a top level object")
+
+var defaultIDKeys = []string{"id", "refId", "name"}
+
+type merger struct {
+	opts MergeOptions
+	// source is the url of the data being merged, and provenance, when not nil, records it against
+	// the JSON pointer of each value that it writes.
+	source     *pkgurl.URL
+	provenance map[string]*pkgurl.URL
+	// positions, when not nil, records the position of each value that it writes, taken from sourcePositions.
+	positions       map[string]Position
+	sourcePositions map[string]Position
+	// changes, when not nil, collects each change made to the merged data.
+	changes *[]MergeChange
+}
+
 func mergeTo(toData interface{}, fromData ...interface{}) error {
+	return merger{}.mergeTo(toData, fromData...)
+}
+
+func merge(pToData, fromData interface{}) error {
+	return merger{}.merge(pToData, fromData)
+}
+
+func (m merger) mergeTo(toData interface{}, fromData ...interface{}) error {
 	for _, fromDatum := range fromData {
-		err := merge(toData, fromDatum)
+		err := m.merge(toData, fromDatum)
 		if err != nil {
 			return err
 		}
@@ -18,11 +120,16 @@ func mergeTo(toData interface{}, fromData ...interface{}) error {
 	return nil
 }
 
-func merge(pToData, fromData interface{}) error {
-	return mergeRecursive(rootContext(), pToData, fromData)
+func (m merger) merge(pToData, fromData interface{}) error {
+	// documents that are arrays are merged as for any other arrays, but not with documents that are objects
+	if p, ok := pToData.(*interface{}); ok && topLevelConflict(*p, fromData) {
+		return errTopLevelConflict
+	}
+
+	return m.mergeRecursive(rootContext(), pToData, fromData)
 }
 
-func mergeRecursive(ctx context, pToData, fromData interface{}) error {
+func (m merger) mergeRecursive(ctx context, pToData, fromData interface{}) error {
 	if pToData == nil {
 		return &errWithContext{
 			context: ctx,
@@ -48,32 +155,116 @@ func mergeRecursive(ctx context, pToData, fromData interface{}) error {
 	toData := toVal.Interface()
 
 	if toVal.Interface() == nil {
-		toVal.Set(fromVal)
+		if props, ok := fromData.(map[string]interface{}); ok && m.opts.CaseInsensitive {
+			folded, err := m.newFolded(ctx, props)
+			if err != nil {
+				return err
+			}
+
+			toVal.Set(reflect.ValueOf(folded))
+			m.change(ctx, MergeAdd, nil, folded)
+
+			return nil
+		}
+
+		toVal.Set(reflect.ValueOf(m.newValue(fromData)))
+		m.record(ctx, fromData)
+		m.change(ctx, MergeAdd, nil, toVal.Interface())
 
 		return nil
 	}
 
+	if fn, ok := m.opts.Funcs[ctx.pointer()]; ok {
+		return m.mergeFunc(ctx, fn, toVal, toData, fromData)
+	}
+
+	if m.opts.Resolver != nil && conflicting(toData, fromData) {
+		merged, err := m.opts.Resolver(ctx.pointer(), toData, fromData)
+		if !errors.Is(err, ErrDefaultMerge) {
+			return m.setMerged(ctx, toVal, toData, merged, err)
+		}
+	}
+
 	var err error
 
 	//nolint:exhaustive // to be refactored
 	switch fromVal.Kind() {
 	case reflect.Map:
-		err = mergeMapRecursive(ctx, toData, fromData)
+		err = m.mergeMapRecursive(ctx, toData, fromData)
 	case reflect.Slice:
-		err = mergeSliceRecursive(ctx, toVal, toData, fromData)
+		err = m.mergeSliceRecursive(ctx, toVal, toData, fromData)
+		m.forget(ctx)
+		m.record(ctx, fromData)
 	default:
 		err = mergeDefaultRecursive(ctx, toVal, fromVal, toData, fromData)
+		m.record(ctx, fromData)
+		m.changeValue(ctx, MergeOverwrite, toData, toVal.Interface())
 	}
 
 	return err
 }
 
-func mergeMapRecursive(ctx context, toData, fromData interface{}) error {
+func (m merger) mergeFunc(ctx context, fn MergeFunc, toVal reflect.Value, toData, fromData interface{}) error {
+	merged, err := fn(toData, fromData)
+
+	return m.setMerged(ctx, toVal, toData, merged, err)
+}
+
+// setMerged sets the value merged by a custom merge, or returns the error it failed with.
+func (m merger) setMerged(ctx context, toVal reflect.Value, toData, merged interface{}, err error) error {
+	if err != nil {
+		return &errWithContext{
+			context: ctx,
+			msg:     fmt.Sprintf("custom merge failed : %v", err.Error()),
+		}
+	}
+
+	if merged == nil {
+		toVal.Set(reflect.Zero(toVal.Type()))
+	} else {
+		toVal.Set(reflect.ValueOf(copyValue(merged, true)))
+	}
+
+	m.forget(ctx)
+	m.record(ctx, merged)
+	m.changeValue(ctx, MergeOverwrite, toData, merged)
+
+	return nil
+}
+
+// topLevelConflict reports whether one of the values is an object and the other an array.
+func topLevelConflict(toData, fromData interface{}) bool {
+	_, toObject := toData.(map[string]interface{})
+	_, toArray := toData.([]interface{})
+	_, fromObject := fromData.(map[string]interface{})
+	_, fromArray := fromData.([]interface{})
+
+	return (toObject && fromArray) || (toArray && fromObject)
+}
+
+// conflicting reports whether the incoming value would overwrite the existing value, rather than being merged into it
+// or being the same.
+func conflicting(toData, fromData interface{}) bool {
+	switch fromData.(type) {
+	case map[string]interface{}:
+		if _, ok := toData.(map[string]interface{}); ok {
+			return false
+		}
+	case []interface{}:
+		if _, ok := toData.([]interface{}); ok {
+			return false
+		}
+	}
+
+	return !equalValues(toData, fromData)
+}
+
+func (m merger) mergeMapRecursive(ctx context, toData, fromData interface{}) error {
 	fromProps, ok := fromData.(map[string]interface{})
 	if !ok {
 		return &errWithContext{
 			context: ctx,
-			msg:     "the source value must be a map[string]interface{}",
+			msg:     fmt.Sprintf("the source value must be a map[string]interface{} (found %T)", fromData),
 		}
 	}
 
@@ -81,15 +272,38 @@ func mergeMapRecursive(ctx context, toData, fromData interface{}) error {
 	if toProps == nil {
 		return &errWithContext{
 			context: ctx,
-			msg:     "the destination value must be a map[string]interface{}",
+			msg:     fmt.Sprintf("the destination value must be a map[string]interface{} (found %T)", toData),
 		}
 	}
 
-	for name, fromProp := range fromProps {
-		if val := toProps[name]; val == nil {
-			toProps[name] = fromProp
+	for _, fromName := range m.propNames(fromProps) {
+		fromProp := fromProps[fromName]
+		name := m.propName(toProps, fromName)
+
+		if fromProp == nil && m.opts.NullDelete {
+			if val, ok := toProps[name]; ok {
+				delete(toProps, name)
+				m.forget(ctx.add(name))
+				m.change(ctx.add(name), MergeDelete, val, nil)
+			}
+
+			continue
+		}
+
+		if props, ok := fromProp.(map[string]interface{}); ok && m.opts.CaseInsensitive && toProps[name] == nil {
+			folded, err := m.newFolded(ctx.add(name), props)
+			if err != nil {
+				return err
+			}
+
+			toProps[name] = folded
+			m.changeValue(ctx.add(name), MergeAdd, nil, folded)
+		} else if val := toProps[name]; val == nil {
+			toProps[name] = m.newValue(fromProp)
+			m.record(ctx.add(name), fromProp)
+			m.changeValue(ctx.add(name), MergeAdd, nil, toProps[name])
 		} else {
-			err := merge(&val, fromProp)
+			err := m.mergeRecursive(ctx.add(name), &val, fromProp)
 			if err != nil {
 				return &errWithContext{
 					context: ctx.add(name),
@@ -104,12 +318,153 @@ func mergeMapRecursive(ctx context, toData, fromData interface{}) error {
 	return nil
 }
 
-func mergeSliceRecursive(ctx context, toVal reflect.Value, toData, fromData interface{}) error {
+// propNames returns the names of the properties in the order they are merged, which is sorted when CaseInsensitive
+// is set, so that the name kept for properties differing only by case does not depend on the order of the map.
+func (m merger) propNames(props map[string]interface{}) []string {
+	names := make([]string, 0, len(props))
+	for name := range props {
+		names = append(names, name)
+	}
+
+	if m.opts.CaseInsensitive {
+		sort.Strings(names)
+	}
+
+	return names
+}
+
+// propName returns the name of the property in the object that the property with the name is merged into, which
+// is an existing name that differs only by case when CaseInsensitive is set.
+func (m merger) propName(props map[string]interface{}, name string) string {
+	if _, ok := props[name]; ok || !m.opts.CaseInsensitive {
+		return name
+	}
+
+	match := name
+
+	for existing := range props {
+		if strings.EqualFold(existing, name) && (match == name || existing < match) {
+			match = existing
+		}
+	}
+
+	return match
+}
+
+// newFolded returns a copy of the object to add to the merged data, with the properties whose names differ only by
+// case merged together. Only the object as a whole is reported as a change.
+func (m merger) newFolded(ctx context, props map[string]interface{}) (map[string]interface{}, error) {
+	folded := map[string]interface{}{}
+
+	quiet := m
+	quiet.changes = nil
+
+	err := quiet.mergeMapRecursive(ctx, folded, props)
+
+	return folded, err
+}
+
+// record sets the source as the provenance of the data at the context. Objects are recursed into,
+// while any other value, including an array, is recorded as a whole.
+func (m merger) record(ctx context, data interface{}) {
+	if m.provenance == nil {
+		return
+	}
+
+	if props, ok := data.(map[string]interface{}); ok {
+		for name, prop := range props {
+			if prop != nil || !m.opts.NullDelete {
+				m.record(ctx.add(name), prop)
+			}
+		}
+
+		return
+	}
+
+	m.provenance[ctx.pointer()] = m.source
+
+	if m.positions != nil {
+		pos, ok := m.sourcePositions[ctx.pointer()]
+		if !ok {
+			pos = Position{URL: m.source}
+		}
+
+		m.positions[ctx.pointer()] = pos
+	}
+}
+
+// forget removes the provenance of the data at the context and anything below it.
+func (m merger) forget(ctx context) {
+	pointer := ctx.pointer()
+
+	for key := range m.provenance {
+		if key == pointer || strings.HasPrefix(key, pointer+"/") {
+			delete(m.provenance, key)
+			delete(m.positions, key)
+		}
+	}
+}
+
+// change adds a change to the merged data, when changes are being collected.
+func (m merger) change(ctx context, action MergeAction, oldValue, newValue interface{}) {
+	if m.changes == nil {
+		return
+	}
+
+	*m.changes = append(*m.changes, MergeChange{
+		Pointer:  ctx.pointer(),
+		Action:   action,
+		OldValue: oldValue,
+		NewValue: newValue,
+		Source:   m.source,
+	})
+}
+
+// changeValue adds a change, unless the new value is the same as the old one.
+func (m merger) changeValue(ctx context, action MergeAction, oldValue, newValue interface{}) {
+	if !reflect.DeepEqual(oldValue, newValue) {
+		m.change(ctx, action, oldValue, newValue)
+	}
+}
+
+// newValue prepares a source value that is being added to the merged data, where there was nothing to merge with.
+// It is copied so that the merged data does not share any maps or slices with the source.
+func (m merger) newValue(fromData interface{}) interface{} {
+	return copyValue(fromData, !m.opts.NullDelete)
+}
+
+// copyValue returns a deep copy of the data, leaving out null object properties unless keepNulls is set.
+func copyValue(data interface{}, keepNulls bool) interface{} {
+	switch val := data.(type) {
+	case map[string]interface{}:
+		props := make(map[string]interface{}, len(val))
+
+		for name, prop := range val {
+			if prop != nil || keepNulls {
+				props[name] = copyValue(prop, keepNulls)
+			}
+		}
+
+		return props
+	case []interface{}:
+		items := make([]interface{}, len(val))
+
+		for i, item := range val {
+			items[i] = copyValue(item, keepNulls)
+		}
+
+		return items
+	default:
+		return data
+	}
+}
+
+func (m merger) mergeSliceRecursive(ctx context, toVal reflect.Value, toData, fromData interface{}) error {
 	fromItems, ok := fromData.([]interface{})
 	if !ok {
 		return &errWithContext{
 			context: ctx,
-			msg:     "the source value must be a []interface{}",
+			msg:     fmt.Sprintf("the source value must be a []interface{} (found %T)", fromData),
 		}
 	}
 
@@ -117,27 +472,69 @@ func mergeSliceRecursive(ctx context, toVal reflect.Value, toData, fromData inte
 	if toItems == nil {
 		return &errWithContext{
 			context: ctx,
-			msg:     "the destination value must be a []interface{}",
+			msg:     fmt.Sprintf("the destination value must be a []interface{} (found %T)", toData),
 		}
 	}
 
+	var (
+		newItems []interface{}
+		err      error
+	)
+
+	switch m.arrayStrategy(ctx) {
+	case ArrayReplace:
+		newItems, _ = m.newValue(fromItems).([]interface{})
+	case ArrayAppend:
+		newItems = make([]interface{}, 0, len(toItems)+len(fromItems))
+		newItems = append(newItems, toItems...)
+
+		for _, item := range fromItems {
+			newItems = append(newItems, m.newValue(item))
+		}
+	default:
+		newItems, err = m.unionSlices(ctx, toItems, fromItems)
+		if err != nil {
+			return err
+		}
+	}
+
+	toVal.Set(reflect.ValueOf(newItems))
+
+	if m.arrayStrategy(ctx) == ArrayReplace {
+		m.changeValue(ctx, MergeOverwrite, toItems, newItems)
+	} else {
+		m.changeValue(ctx, MergeAppend, toItems, newItems)
+	}
+
+	return nil
+}
+
+func (m merger) arrayStrategy(ctx context) ArrayStrategy {
+	if strategy, ok := m.opts.ArrayStrategies[ctx.pointer()]; ok {
+		return strategy
+	}
+
+	return m.opts.ArrayStrategy
+}
+
+func (m merger) unionSlices(ctx context, toItems, fromItems []interface{}) ([]interface{}, error) {
 	var fromById = map[interface{}]interface{}{}
 	var toById = map[interface{}]interface{}{}
 	var seen = map[uint64]int{}
-	addById(fromItems, fromById)
-	addById(toItems, toById)
+	m.addById(ctx, fromItems, fromById)
+	m.addById(ctx, toItems, toById)
 
 	var newItems []interface{}
 	for _, item := range toItems {
-		id := getId(item)
+		id := m.getId(ctx, item)
 		merged := false
 		if id != nil {
 			from := fromById[id]
 			to := toById[id]
 			if from != nil && to != nil {
-				err := merge(&to, from)
+				err := m.mergeRecursive(ctx.add(strconv.Itoa(len(newItems))), &to, from)
 				if err != nil {
-					return err
+					return nil, err
 				}
 				newItems = append(newItems, to)
 				merged = true
@@ -146,14 +543,14 @@ func mergeSliceRecursive(ctx context, toVal reflect.Value, toData, fromData inte
 		if !merged {
 			hash, err := hashstructure.Hash(item, hashstructure.FormatV2, nil)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			seen[hash] += 1
 			newItems = append(newItems, item)
 		}
 	}
 	for _, item := range fromItems {
-		id := getId(item)
+		id := m.getId(ctx, item)
 		skipped := false
 		if id != nil {
 			from := fromById[id]
@@ -165,7 +562,7 @@ func mergeSliceRecursive(ctx context, toVal reflect.Value, toData, fromData inte
 		}
 		hash, err := hashstructure.Hash(item, hashstructure.FormatV2, nil)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if seen[hash] > 0 {
 			seen[hash] -= 1
@@ -173,28 +570,30 @@ func mergeSliceRecursive(ctx context, toVal reflect.Value, toData, fromData inte
 		}
 
 		if !skipped {
-			newItems = append(newItems, item)
+			newItems = append(newItems, m.newValue(item))
 		}
 	}
 
-	toVal.Set(reflect.ValueOf(newItems))
-
-	return nil
+	return newItems, nil
 }
 
-func addById(items []interface{}, target map[interface{}]interface{}) {
+func (m merger) addById(ctx context, items []interface{}, target map[interface{}]interface{}) {
 	for _, item := range items {
-		id := getId(item)
+		id := m.getId(ctx, item)
 		if id != nil {
 			target[id] = item
 		}
 	}
 }
 
-func getId(item interface{}) interface{} {
+func (m merger) getId(ctx context, item interface{}) interface{} {
 	props, ok := item.(map[string]interface{})
 	if ok {
-		ids := []string{"id", "refId", "name"}
+		ids := defaultIDKeys
+		if key, ok := m.opts.ArrayKeys[ctx.pointer()]; ok {
+			ids = []string{key}
+		}
+
 		for _, key := range ids {
 			v := props[key]
 			if v != nil {
@@ -210,6 +609,17 @@ func mergeDefaultRecursive(ctx context, toVal, fromVal reflect.Value, toData, fr
 		return nil
 	}
 
+	// numbers decoded from different formats, or as json.Number, have different types but can be merged
+	if toNum, ok := numberValue(toData); ok && toVal.Kind() == reflect.Interface {
+		if fromNum, ok := numberValue(fromData); ok {
+			if toNum.Cmp(fromNum) != 0 {
+				toVal.Set(fromVal)
+			}
+
+			return nil
+		}
+	}
+
 	fromType := fromVal.Type()
 	toType := toVal.Type()
 
@@ -228,3 +638,26 @@ func mergeDefaultRecursive(ctx context, toVal, fromVal reflect.Value, toData, fr
 
 	return nil
 }
+
+// numberValue returns the exact value of a number of any numeric type, or a json.Number.
+func numberValue(data interface{}) (*big.Rat, bool) {
+	if n, ok := data.(json.Number); ok {
+		return new(big.Rat).SetString(n.String())
+	}
+
+	val := reflect.ValueOf(data)
+
+	//nolint:exhaustive // only numbers are handled
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return new(big.Rat).SetInt64(val.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Rat).SetUint64(val.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		r := new(big.Rat).SetFloat64(val.Float())
+
+		return r, r != nil
+	default:
+		return nil, false
+	}
+}
@@ -2,7 +2,11 @@ package conflate
 
 import (
 	"encoding/json"
+	"errors"
+	"net/url"
 	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -70,6 +74,42 @@ func TestMerge_SimpleFloat(t *testing.T) {
 	assert.Equal(t, 2.0, toData)
 }
 
+func TestMerge_Numbers(t *testing.T) {
+	var toData interface{} = map[string]interface{}{
+		"a": json.Number("12345678901234567890"),
+		"b": 1.0,
+		"c": json.Number("1.0"),
+		"d": int64(1),
+	}
+
+	err := merge(&toData, map[string]interface{}{
+		"a": int64(2),
+		"b": json.Number("9007199254740993"),
+		"c": 1.0,
+		"d": "x",
+	})
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "the destination type (int64) must be the same as the source type (string)")
+
+	err = merge(&toData, map[string]interface{}{
+		"a": int64(2),
+		"b": json.Number("9007199254740993"),
+		"c": 1.0,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a": int64(2),
+		"b": json.Number("9007199254740993"),
+		// an equal number is kept as it is
+		"c": json.Number("1.0"),
+		"d": int64(1),
+	}, toData)
+
+	typed := 1
+	err = merge(&typed, json.Number("2"))
+	assert.NotNil(t, err)
+}
+
 func TestMerge_SimpleMap(t *testing.T) {
 	toData := map[string]interface{}{"x": 1}
 	fromData := map[string]interface{}{"x": 2, "y": 2}
@@ -148,6 +188,356 @@ func TestMerge_SliceOfEqualStructs(t *testing.T) {
 	assert.Equal(t, expected, toData)
 }
 
+func TestMerge_SliceOfMapsWithArrayKey(t *testing.T) {
+	m := merger{opts: MergeOptions{ArrayKeys: map[string]string{"/servers": "host"}}}
+	toData := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"host": "a", "name": "x", "port": 1},
+		},
+		"users": []interface{}{
+			map[string]interface{}{"name": "a", "x": 1},
+		},
+	}
+	fromData := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"host": "a", "name": "y", "tls": true},
+			map[string]interface{}{"host": "b"},
+		},
+		"users": []interface{}{
+			map[string]interface{}{"name": "a", "y": 2},
+		},
+	}
+	expected := map[string]interface{}{
+		"servers": []interface{}{
+			map[string]interface{}{"host": "a", "name": "y", "port": 1, "tls": true},
+			map[string]interface{}{"host": "b"},
+		},
+		"users": []interface{}{
+			map[string]interface{}{"name": "a", "x": 1, "y": 2},
+		},
+	}
+	err := m.merge(&toData, fromData)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, toData)
+}
+
+func TestMerge_ArrayStrategy(t *testing.T) {
+	tests := []struct {
+		strategy ArrayStrategy
+		expected []interface{}
+	}{
+		{ArrayUnion, []interface{}{1.0, 2.0, 3.0}},
+		{ArrayReplace, []interface{}{2.0, 3.0}},
+		{ArrayAppend, []interface{}{1.0, 2.0, 2.0, 3.0}},
+	}
+
+	for _, tt := range tests {
+		m := merger{opts: MergeOptions{ArrayStrategy: tt.strategy}}
+
+		var toData interface{}
+
+		err := m.mergeTo(&toData,
+			testMergeGetData(t, []byte(`{"items": [1, 2]}`)),
+			testMergeGetData(t, []byte(`{"items": [2, 3]}`)),
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"items": tt.expected}, toData)
+	}
+}
+
+func TestMerge_ArrayStrategiesByPath(t *testing.T) {
+	m := merger{opts: MergeOptions{
+		ArrayStrategy:   ArrayAppend,
+		ArrayStrategies: map[string]ArrayStrategy{"/a/b": ArrayReplace},
+	}}
+
+	var toData interface{}
+
+	err := m.mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"a": {"b": [1], "c": [1]}}`)),
+		testMergeGetData(t, []byte(`{"a": {"b": [2], "c": [2]}}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": []interface{}{2.0},
+			"c": []interface{}{1.0, 2.0},
+		},
+	}, toData)
+}
+
+func TestMerge_Funcs(t *testing.T) {
+	maximum := func(existing, incoming interface{}) (interface{}, error) {
+		if existing.(float64) > incoming.(float64) {
+			return existing, nil
+		}
+		return incoming, nil
+	}
+	m := merger{opts: MergeOptions{Funcs: map[string]MergeFunc{"/db/max_connections": maximum}}}
+
+	var toData interface{}
+
+	err := m.mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"db": {"max_connections": 20, "timeout": 5}}`)),
+		testMergeGetData(t, []byte(`{"db": {"max_connections": 10, "timeout": 1}}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"db": map[string]interface{}{"max_connections": 20.0, "timeout": 1.0},
+	}, toData)
+}
+
+func TestMerge_FuncsError(t *testing.T) {
+	fail := func(existing, incoming interface{}) (interface{}, error) {
+		return nil, errors.New("cannot merge")
+	}
+	m := merger{opts: MergeOptions{Funcs: map[string]MergeFunc{"/x": fail}}}
+
+	var toData interface{}
+
+	err := m.mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"x": 1}`)),
+		testMergeGetData(t, []byte(`{"x": 2}`)),
+	)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "cannot merge")
+	assert.Contains(t, err.Error(), "#/x")
+}
+
+func TestMerge_Resolver(t *testing.T) {
+	var pointers []string
+
+	// a later source cannot lower a timeout
+	resolver := func(pointer string, existing, incoming interface{}) (interface{}, error) {
+		pointers = append(pointers, pointer)
+
+		if strings.HasSuffix(pointer, "/timeout") && existing.(float64) > incoming.(float64) {
+			return existing, nil
+		}
+
+		return nil, ErrDefaultMerge
+	}
+	m := merger{opts: MergeOptions{Resolver: resolver}}
+
+	var toData interface{}
+
+	err := m.mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"db": {"timeout": 5, "host": "a", "port": 1, "tags": ["x"]}, "http": {"timeout": 1}}`)),
+		testMergeGetData(t, []byte(`{"db": {"timeout": 1, "host": "b", "port": 1, "tags": ["y"]}, "http": {"timeout": 2}}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"db":   map[string]interface{}{"timeout": 5.0, "host": "b", "port": 1.0, "tags": []interface{}{"x", "y"}},
+		"http": map[string]interface{}{"timeout": 2.0},
+	}, toData)
+
+	sort.Strings(pointers)
+	assert.Equal(t, []string{"/db/host", "/db/timeout", "/http/timeout"}, pointers)
+}
+
+func TestMerge_ResolverTypes(t *testing.T) {
+	// the resolver can merge values that the default merge cannot
+	resolver := func(pointer string, existing, incoming interface{}) (interface{}, error) {
+		if _, ok := existing.(map[string]interface{}); ok {
+			return map[string]interface{}{"value": incoming}, nil
+		}
+
+		return nil, errors.New("cannot resolve")
+	}
+	m := merger{opts: MergeOptions{Resolver: resolver}}
+
+	var toData interface{}
+
+	err := m.mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"a": {"value": 1}, "b": 1}`)),
+		testMergeGetData(t, []byte(`{"a": 2}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a": map[string]interface{}{"value": 2.0}, "b": 1.0}, toData)
+
+	err = m.merge(&toData, testMergeGetData(t, []byte(`{"b": 2}`)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "cannot resolve")
+	assert.Contains(t, err.Error(), "#/b")
+}
+
+func TestMerge_CaseInsensitive(t *testing.T) {
+	a, _ := url.Parse("file:///a.json")
+	provenance := map[string]*url.URL{}
+	m := merger{opts: MergeOptions{CaseInsensitive: true}, source: a, provenance: provenance}
+
+	var toData interface{}
+
+	err := m.mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"Timeout": 1, "timeout": 2, "DB": {"Host": "a", "host": "b"}, "list": [{"Id": 1}]}`)),
+		testMergeGetData(t, []byte(`{"TIMEOUT": 3, "db": {"HOST": "c", "Port": 1}, "List": [{"id": 1}]}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Timeout": 3.0,
+		"DB":      map[string]interface{}{"Host": "c", "Port": 1.0},
+		"list":    []interface{}{map[string]interface{}{"Id": 1.0}, map[string]interface{}{"id": 1.0}},
+	}, toData)
+	assert.Equal(t, map[string]*url.URL{
+		"/Timeout": a,
+		"/DB/Host": a,
+		"/DB/Port": a,
+		"/list":    a,
+	}, provenance)
+
+	// the names are compared as usual without the option
+	toData = nil
+
+	err = mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"Timeout": 1}`)),
+		testMergeGetData(t, []byte(`{"timeout": 2}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"Timeout": 1.0, "timeout": 2.0}, toData)
+}
+
+func TestMerge_CaseInsensitiveTypes(t *testing.T) {
+	m := merger{opts: MergeOptions{CaseInsensitive: true}}
+
+	var toData interface{}
+
+	err := m.merge(&toData, testMergeGetData(t, []byte(`{"a": {"B": 1, "b": "x"}}`)))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "#/a/B")
+}
+
+func TestMerge_EscapedPointers(t *testing.T) {
+	a, _ := url.Parse("file:///a.json")
+	provenance := map[string]*url.URL{}
+	changes := []MergeChange{}
+
+	m := merger{
+		opts: MergeOptions{
+			ArrayKeys:       map[string]string{"/a~1b": "id"},
+			ArrayStrategies: map[string]ArrayStrategy{"/c~0d": ArrayReplace},
+			Funcs: map[string]MergeFunc{"/./..": func(existing, incoming interface{}) (interface{}, error) {
+				return "custom", nil
+			}},
+		},
+		source:     a,
+		provenance: provenance,
+		changes:    &changes,
+	}
+
+	var toData interface{}
+
+	err := m.mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"a/b": [{"id": 1, "v": 1}], "c~d": [1], ".": {"..": 1}, "": {"x": 1}}`)),
+		testMergeGetData(t, []byte(`{"a/b": [{"id": 1, "v": 2}], "c~d": [2], ".": {"..": 2}, "": {"x": 2}}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a/b": []interface{}{map[string]interface{}{"id": 1.0, "v": 2.0}},
+		"c~d": []interface{}{2.0},
+		".":   map[string]interface{}{"..": "custom"},
+		"":    map[string]interface{}{"x": 2.0},
+	}, toData)
+
+	pointers := []string{}
+	for pointer := range provenance {
+		pointers = append(pointers, pointer)
+
+		_, ok := lookupPointer(toData, pointer)
+		assert.True(t, ok, pointer)
+	}
+
+	assert.ElementsMatch(t, []string{"/a~1b", "/c~0d", "/./..", "//x"}, pointers)
+
+	for _, change := range changes {
+		_, ok := lookupPointer(toData, change.Pointer)
+		assert.True(t, ok, change.Pointer)
+	}
+}
+
+func TestMerge_Provenance(t *testing.T) {
+	a, _ := url.Parse("file:///a.json")
+	b, _ := url.Parse("file:///b.json")
+	provenance := map[string]*url.URL{}
+
+	var toData interface{}
+
+	err := merger{source: a, provenance: provenance}.merge(&toData,
+		testMergeGetData(t, []byte(`{"x": 1, "y": {"z": 1, "w": 1}, "list": [{"id": 1, "v": 1}]}`)))
+	assert.Nil(t, err)
+
+	err = merger{source: b, provenance: provenance}.merge(&toData,
+		testMergeGetData(t, []byte(`{"y": {"z": 2}, "list": [{"id": 1, "v": 2}]}`)))
+	assert.Nil(t, err)
+
+	assert.Equal(t, map[string]*url.URL{
+		"/x":    a,
+		"/y/z":  b,
+		"/y/w":  a,
+		"/list": b,
+	}, provenance)
+}
+
+func TestMerge_ProvenanceNullDelete(t *testing.T) {
+	a, _ := url.Parse("file:///a.json")
+	provenance := map[string]*url.URL{}
+	m := merger{opts: MergeOptions{NullDelete: true}, source: a, provenance: provenance}
+
+	var toData interface{}
+
+	err := m.mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"x": {"y": 1}, "z": 1}`)),
+		testMergeGetData(t, []byte(`{"x": null, "w": {"v": null}}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]*url.URL{"/z": a}, provenance)
+}
+
+func TestMerge_DoesNotAliasSources(t *testing.T) {
+	first := testMergeGetData(t, []byte(`{"a": {"b": 1}, "list": [{"c": 1}]}`))
+	second := testMergeGetData(t, []byte(`{"d": {"e": [1]}, "list": [{"f": 1}]}`))
+
+	var toData interface{}
+
+	err := mergeTo(&toData, first, second)
+	assert.Nil(t, err)
+
+	merged := toData.(map[string]interface{})
+	merged["a"].(map[string]interface{})["b"] = 2
+	merged["d"].(map[string]interface{})["e"].([]interface{})[0] = 2
+	merged["list"].([]interface{})[0].(map[string]interface{})["c"] = 2
+	merged["list"].([]interface{})[1].(map[string]interface{})["f"] = 2
+
+	assert.Equal(t, testMergeGetData(t, []byte(`{"a": {"b": 1}, "list": [{"c": 1}]}`)), first)
+	assert.Equal(t, testMergeGetData(t, []byte(`{"d": {"e": [1]}, "list": [{"f": 1}]}`)), second)
+}
+
+func TestMerge_NullDelete(t *testing.T) {
+	m := merger{opts: MergeOptions{NullDelete: true}}
+
+	var toData interface{}
+
+	err := m.mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"a": 1, "b": {"c": 2, "d": 3}, "e": null}`)),
+		testMergeGetData(t, []byte(`{"a": null, "b": {"c": null}, "f": {"g": null, "h": [{"i": null}]}, "x": null}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"b": map[string]interface{}{"d": 3.0},
+		"f": map[string]interface{}{"h": []interface{}{map[string]interface{}{}}},
+	}, toData)
+}
+
+func TestMerge_NullKept(t *testing.T) {
+	var toData interface{}
+
+	err := mergeTo(&toData,
+		testMergeGetData(t, []byte(`{"a": 1}`)),
+		testMergeGetData(t, []byte(`{"a": null, "x": null}`)),
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"a": 1.0, "x": nil}, toData)
+}
+
 func TestMerge_ToNil(t *testing.T) {
 	fromData := make(map[string]interface{})
 	err := merge(nil, fromData)
@@ -228,6 +618,26 @@ func TestMerge_IntToMapInvalid(t *testing.T) {
 	assert.Contains(t, err.Error(), "the destination type (map[string]int) must be the same as the source type (int)")
 }
 
+func TestMerge_TypeConflicts(t *testing.T) {
+	tests := []struct {
+		to, from, msg string
+	}{
+		{`{"a": {"b": [1]}}`, `{"a": {"b": {"c": 1}}}`, "the destination value must be a map[string]interface{} (found []interface {})"},
+		{`{"a": {"b": {"c": 1}}}`, `{"a": {"b": [1]}}`, "the destination value must be a []interface{} (found map[string]interface {})"},
+		{`{"a": {"b": {"c": 1}}}`, `{"a": {"b": "x"}}`, "the destination type (map[string]interface {}) must be the same as the source type (string)"},
+		{`{"a": {"b": "x"}}`, `{"a": {"b": 1}}`, "the destination type (string) must be the same as the source type (float64)"},
+	}
+
+	for _, tt := range tests {
+		var toData interface{}
+
+		err := mergeTo(&toData, testMergeGetData(t, []byte(tt.to)), testMergeGetData(t, []byte(tt.from)))
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), tt.msg)
+		assert.Contains(t, err.Error(), "#/a/b")
+	}
+}
+
 func TestMerge_BadPropertyMerge(t *testing.T) {
 	toData := map[string]interface{}{"x": 1}
 	fromData := map[string]interface{}{"x": map[string]string{}}
@@ -0,0 +1,49 @@
+package conflate
+
+import (
+	gocontext "context"
+	pkgurl "net/url"
+	"time"
+)
+
+// Observer is notified as sources are loaded and merged, e.g. to record metrics or tracing spans.
+// Its methods are called from the goroutine loading the data, and must not call the Conflate instance.
+type Observer interface {
+	// LoadStart is called before the data at the url is fetched. The context it returns is passed on to the fetch
+	// and to LoadEnd, so it can carry a span.
+	LoadStart(ctx gocontext.Context, url *pkgurl.URL) gocontext.Context
+	// LoadEnd is called once the data at the url has been fetched, or has failed to be, with the number of bytes
+	// fetched and how long it took. Data served from the cache is not fetched again.
+	LoadEnd(ctx gocontext.Context, url *pkgurl.URL, bytes int, duration time.Duration, err error)
+	// MergeEnd is called once the data of the sources loaded by a call, including any includes, has been merged.
+	MergeEnd(ctx gocontext.Context, sources int, duration time.Duration, err error)
+}
+
+// observeLoad loads the data at the url, notifying the observer if there is one.
+func (l *loader) observeLoad(ctx gocontext.Context, url *pkgurl.URL) ([]byte, error) {
+	if l.observer == nil {
+		return l.loadURL(ctx, url)
+	}
+
+	ctx = l.observer.LoadStart(ctx, url)
+	start := time.Now()
+
+	data, err := l.loadURL(ctx, url)
+	l.observer.LoadEnd(ctx, url, len(data), time.Since(start), err)
+
+	return data, err
+}
+
+// observeMerge merges the data, notifying the observer if there is one.
+func (c *Conflate) observeMerge(ctx gocontext.Context, fdata ...filedata) error {
+	if c.loader.observer == nil {
+		return c.mergeData(fdata...)
+	}
+
+	start := time.Now()
+
+	err := c.mergeData(fdata...)
+	c.loader.observer.MergeEnd(ctx, len(fdata), time.Since(start), err)
+
+	return err
+}
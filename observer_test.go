@@ -0,0 +1,64 @@
+package conflate
+
+import (
+	gocontext "context"
+	"fmt"
+	"net/url"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testObserverKey struct{}
+
+type testObserver struct {
+	events []string
+}
+
+func (o *testObserver) LoadStart(ctx gocontext.Context, url *url.URL) gocontext.Context {
+	o.events = append(o.events, "start "+url.Path)
+
+	return gocontext.WithValue(ctx, testObserverKey{}, url.Path)
+}
+
+func (o *testObserver) LoadEnd(ctx gocontext.Context, url *url.URL, bytes int, duration time.Duration, err error) {
+	o.events = append(o.events, fmt.Sprintf("end %v %v %v %v", url.Path, ctx.Value(testObserverKey{}), bytes, err != nil))
+}
+
+func (o *testObserver) MergeEnd(ctx gocontext.Context, sources int, duration time.Duration, err error) {
+	o.events = append(o.events, fmt.Sprintf("merge %v %v", sources, err != nil))
+}
+
+func TestConflate_Observer(t *testing.T) {
+	o := &testObserver{}
+	c := New(WithObserver(o), WithFS(fstest.MapFS{
+		"a.json":      {Data: []byte(`{"includes": ["b.json"], "x": 1}`)},
+		"b.json":      {Data: []byte(`{"y": 1}`)},
+		"list.json":   {Data: []byte(`{"x": [1]}`)},
+		"schema.json": {Data: []byte(`{"type": "object"}`)},
+	}))
+
+	assert.Nil(t, c.AddFiles("a.json"))
+	assert.Nil(t, c.AddData([]byte(`{"z": 1}`)))
+	assert.NotNil(t, c.AddFiles("list.json"))
+	assert.NotNil(t, c.AddFiles("missing.json"))
+	assert.Nil(t, c.ValidateWithSchemaURL("schema.json"))
+
+	assert.Equal(t, []string{
+		"start /a.json",
+		"end /a.json /a.json 32 false",
+		"start /b.json",
+		"end /b.json /b.json 8 false",
+		"merge 2 false",
+		"merge 1 false",
+		"start /list.json",
+		"end /list.json /list.json 10 false",
+		"merge 1 true",
+		"start /missing.json",
+		"end /missing.json /missing.json 0 true",
+		"start /schema.json",
+		"end /schema.json /schema.json 18 false",
+	}, o.events)
+}
@@ -0,0 +1,85 @@
+package conflate
+
+import (
+	gocontext "context"
+	"io/fs"
+	"net/http"
+	"net/url"
+
+	"google.golang.org/api/option"
+)
+
+// Option configures a Conflate instance when it is constructed with New.
+// Each option has the same effect as calling the corresponding method afterwards.
+type Option func(*Conflate)
+
+// WithHTTPClient sets the client used when loading remote urls, see Conflate.HTTPClient.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Conflate) {
+		c.HTTPClient(client)
+	}
+}
+
+// WithGCSOptions sets the options used to create the client that loads gs urls, see Conflate.GCSOptions.
+func WithGCSOptions(opts ...option.ClientOption) Option {
+	return func(c *Conflate) {
+		c.GCSOptions(opts...)
+	}
+}
+
+// WithBaseURL sets the url that the includes of data not loaded from a url are relative to, see Conflate.BaseURL.
+func WithBaseURL(base *url.URL) Option {
+	return func(c *Conflate) {
+		c.BaseURL(base)
+	}
+}
+
+// WithObserver sets an observer to be notified as data is loaded and merged, see Conflate.Observer.
+func WithObserver(o Observer) Option {
+	return func(c *Conflate) {
+		c.Observer(o)
+	}
+}
+
+// WithMaxDepth limits how deeply includes can be nested, see Conflate.MaxIncludeDepth.
+func WithMaxDepth(n int) Option {
+	return func(c *Conflate) {
+		c.MaxIncludeDepth(n)
+	}
+}
+
+// WithMergeStrategy sets how the data from each source is merged together, see Conflate.MergeOptions.
+func WithMergeStrategy(opts MergeOptions) Option {
+	return func(c *Conflate) {
+		c.MergeOptions(opts)
+	}
+}
+
+// WithContext sets the context used to load data by the methods that do not take one, e.g. AddFiles,
+// so that remote fetches are aborted when it is done.
+func WithContext(ctx gocontext.Context) Option {
+	return func(c *Conflate) {
+		c.ctx = ctx
+	}
+}
+
+// WithFS sets a file system to read files from in place of the operating system's, see Conflate.FS.
+func WithFS(fsys fs.FS) Option {
+	return func(c *Conflate) {
+		c.FS(fsys)
+	}
+}
+
+// WithSchema sets the schema used by Validate and ApplyDefaults when they are given a nil schema.
+func WithSchema(s *Schema) Option {
+	return func(c *Conflate) {
+		c.schema = s
+	}
+}
+
+// WithEmbeddedSchema takes a schema embedded in the data under the top level key, see Conflate.EmbeddedSchema.
+func WithEmbeddedSchema(key string, precedence SchemaPrecedence) Option {
+	return func(c *Conflate) {
+		c.EmbeddedSchema(key, precedence)
+	}
+}
@@ -0,0 +1,66 @@
+package conflate
+
+import (
+	gocontext "context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_Options(t *testing.T) {
+	client := &http.Client{}
+	s, err := NewSchemaData([]byte(`{"type": "object", "properties": {"x": {"type": "integer", "default": 1}}}`))
+	assert.Nil(t, err)
+
+	c := New(
+		WithHTTPClient(client),
+		WithMaxDepth(3),
+		WithMergeStrategy(MergeOptions{ArrayStrategy: ArrayReplace}),
+		WithSchema(s),
+	)
+
+	assert.Equal(t, client, c.loader.httpClient)
+	assert.Equal(t, 3, c.loader.maxDepth)
+	assert.Equal(t, ArrayReplace, c.mergeOpts.ArrayStrategy)
+
+	err = c.AddData([]byte(`{"list": [1]}`), []byte(`{"list": [2]}`))
+	assert.Nil(t, err)
+
+	err = c.ApplyDefaults(nil)
+	assert.Nil(t, err)
+
+	err = c.Validate(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"list": []interface{}{2.0}, "x": 1.0}, c.Data())
+
+	c = New(WithSchema(s))
+
+	err = c.AddData([]byte(`{"x": "not an integer"}`))
+	assert.Nil(t, err)
+
+	err = c.Validate(nil)
+	assert.ErrorIs(t, err, errInvalidPerSchema)
+
+	err = New().Validate(nil)
+	assert.ErrorIs(t, err, errNotSetSchema)
+}
+
+func TestNew_WithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"x": 1}`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	cancel()
+
+	c := New(WithContext(ctx))
+
+	err := c.AddFiles(server.URL + "/data.json")
+	assert.ErrorIs(t, err, gocontext.Canceled)
+
+	err = New().AddFiles(server.URL + "/data.json")
+	assert.Nil(t, err)
+}
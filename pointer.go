@@ -0,0 +1,156 @@
+package conflate
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errInvalidPointer = errors.New("invalid JSON pointer")
+
+// lookupPointer returns the value at the RFC 6901 JSON pointer in the data, and whether there is one.
+// A pointer that is not valid, e.g. one not starting with '/', has no value.
+func lookupPointer(data interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return data, data != nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+
+	for _, token := range strings.Split(pointer[1:], "/") {
+		name, ok := unescapePointerToken(token)
+		if !ok {
+			return nil, false
+		}
+
+		data, ok = pointerChild(data, name)
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return data, true
+}
+
+// validPointer reports whether the pointer is blank, or starts with '/' and uses '~' only in ~0 and ~1.
+func validPointer(pointer string) bool {
+	if pointer == "" {
+		return true
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return false
+	}
+
+	for _, token := range strings.Split(pointer[1:], "/") {
+		if _, ok := unescapePointerToken(token); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func pointerChild(data interface{}, name string) (interface{}, bool) {
+	switch node := data.(type) {
+	case map[string]interface{}:
+		val, ok := node[name]
+
+		return val, ok
+	case []interface{}:
+		i, ok := arrayIndex(name)
+		if !ok || i >= len(node) {
+			return nil, false
+		}
+
+		return node[i], true
+	default:
+		return nil, false
+	}
+}
+
+// setPointer replaces the value at the JSON pointer, reporting whether there was a value to replace.
+func setPointer(data interface{}, pointer string, val interface{}) bool {
+	i := strings.LastIndexByte(pointer, '/')
+	if i < 0 {
+		return false
+	}
+
+	parent, ok := lookupPointer(data, pointer[:i])
+	if !ok {
+		return false
+	}
+
+	name, ok := unescapePointerToken(pointer[i+1:])
+	if !ok {
+		return false
+	}
+
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := node[name]; ok {
+			node[name] = val
+
+			return true
+		}
+	case []interface{}:
+		if n, ok := arrayIndex(name); ok && n < len(node) {
+			node[n] = val
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// arrayIndex parses an array index, which has no leading zeros or sign.
+func arrayIndex(token string) (int, bool) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, false
+	}
+
+	i, err := strconv.Atoi(token)
+
+	return i, err == nil
+}
+
+// escapePointerToken replaces '~' with ~0 and '/' with ~1, so that the name can be used in a JSON pointer.
+func escapePointerToken(name string) string {
+	return pointerEscaper.Replace(name)
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// unescapePointerToken replaces ~1 with '/' and ~0 with '~', any other use of '~' is not valid.
+func unescapePointerToken(token string) (string, bool) {
+	if !strings.Contains(token, "~") {
+		return token, true
+	}
+
+	var sb strings.Builder
+
+	for i := 0; i < len(token); i++ {
+		if token[i] != '~' {
+			sb.WriteByte(token[i])
+
+			continue
+		}
+
+		if i+1 == len(token) || (token[i+1] != '0' && token[i+1] != '1') {
+			return "", false
+		}
+
+		if token[i+1] == '0' {
+			sb.WriteByte('~')
+		} else {
+			sb.WriteByte('/')
+		}
+
+		i++
+	}
+
+	return sb.String(), true
+}
@@ -0,0 +1,114 @@
+package conflate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupPointer(t *testing.T) {
+	var data interface{}
+
+	err := JSONUnmarshal([]byte(`{
+		"db": {"pool": {"size": 10}},
+		"hosts": [{"name": "a"}, {"name": "b"}],
+		"a/b": 1,
+		"m~n": 2,
+		"": 3,
+		"nil": null
+	}`), &data)
+	assert.Nil(t, err)
+
+	tests := []struct {
+		pointer string
+		value   interface{}
+		ok      bool
+	}{
+		{pointer: "", value: data, ok: true},
+		{pointer: "/db/pool/size", value: 10.0, ok: true},
+		{pointer: "/db/pool", value: map[string]interface{}{"size": 10.0}, ok: true},
+		{pointer: "/hosts/1/name", value: "b", ok: true},
+		{pointer: "/a~1b", value: 1.0, ok: true},
+		{pointer: "/m~0n", value: 2.0, ok: true},
+		{pointer: "/", value: 3.0, ok: true},
+		{pointer: "/nil", value: nil, ok: true},
+		{pointer: "/missing"},
+		{pointer: "/db/pool/size/more"},
+		{pointer: "/hosts/2"},
+		{pointer: "/hosts/01"},
+		{pointer: "/hosts/-"},
+		{pointer: "/hosts/-1"},
+		{pointer: "/hosts/name"},
+		{pointer: "/m~2n"},
+		{pointer: "/m~"},
+		{pointer: "db"},
+	}
+
+	for _, test := range tests {
+		value, ok := lookupPointer(data, test.pointer)
+		assert.Equal(t, test.ok, ok, test.pointer)
+		assert.Equal(t, test.value, value, test.pointer)
+	}
+
+	_, ok := lookupPointer(nil, "")
+	assert.False(t, ok)
+}
+
+func TestConflate_GetPointer(t *testing.T) {
+	c, err := FromData([]byte(`{"db": {"hosts": ["a", "b"]}}`))
+	assert.Nil(t, err)
+
+	value, ok := c.GetPointer("/db/hosts/1")
+	assert.True(t, ok)
+	assert.Equal(t, "b", value)
+
+	value, ok = c.GetPointer("/db/hosts")
+	assert.True(t, ok)
+	value.([]interface{})[0] = "changed"
+
+	value, ok = c.GetPointer("/db/hosts/0")
+	assert.True(t, ok)
+	assert.Equal(t, "a", value)
+
+	_, ok = c.GetPointer("/db/port")
+	assert.False(t, ok)
+
+	_, ok = New().GetPointer("")
+	assert.False(t, ok)
+}
+
+func TestConflate_UnmarshalPointer(t *testing.T) {
+	c, err := FromData([]byte(`{"db": {"host": "db", "port": 5432}, "name": "app"}`))
+	assert.Nil(t, err)
+
+	type database struct {
+		Host string `json:"host"`
+		Port int    `json:"port"`
+	}
+
+	var cfg struct {
+		Name     string
+		Database database
+		Cache    database
+	}
+
+	err = c.UnmarshalPointer(&cfg.Database, "/db")
+	assert.Nil(t, err)
+	assert.Equal(t, database{Host: "db", Port: 5432}, cfg.Database)
+	assert.Equal(t, "", cfg.Name)
+
+	err = c.UnmarshalPointer(&cfg.Cache, "/cache")
+	assert.Nil(t, err)
+	assert.Equal(t, database{}, cfg.Cache)
+
+	var port int
+	err = c.UnmarshalPointer(&port, "/db/port")
+	assert.Nil(t, err)
+	assert.Equal(t, 5432, port)
+
+	err = c.UnmarshalPointer(&cfg.Cache, "db")
+	assert.ErrorIs(t, err, errInvalidPointer)
+
+	err = c.UnmarshalPointer(&cfg.Cache, "/db/~2")
+	assert.ErrorIs(t, err, errInvalidPointer)
+}
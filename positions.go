@@ -0,0 +1,168 @@
+package conflate
+
+import (
+	"bytes"
+	"encoding/json"
+	pkgurl "net/url"
+	"strconv"
+
+	yaml3 "gopkg.in/yaml.v3"
+)
+
+// Position is where a merged value was set, as reported by Positions.
+type Position struct {
+	// URL is the url of the source, which is nil for data that was not loaded from a url.
+	URL *pkgurl.URL
+	// Line and Col give the position of the value within the source, starting from 1, for JSON and YAML sources,
+	// and are 0 otherwise.
+	Line int
+	Col  int
+}
+
+// positions returns the position of each value in the data, keyed by JSON pointer. Only JSON and YAML are scanned,
+// so the values of other formats, or data that cannot be scanned, have no position.
+func (fd *filedata) positions() map[string]Position {
+	ext := fd.format()
+	if _, ok := Unmarshallers[ext]; !ok || ext == "" {
+		ext = sniffExtension(fd.data)
+	}
+
+	positions := map[string]Position{}
+
+	switch ext {
+	case ".json", ".jsn":
+		jsonPositions(fd.data, positions)
+	case ".yaml", ".yml":
+		yamlPositions(fd.data, positions)
+	}
+
+	for pointer, pos := range positions {
+		pos.URL = fd.source()
+		positions[pointer] = pos
+	}
+
+	return positions
+}
+
+// jsonPositions adds the position of each value in the JSON data, leaving those found before any syntax error.
+func jsonPositions(data []byte, positions map[string]Position) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	_ = jsonPositionValue(dec, data, "", positions)
+}
+
+// jsonPositionValue scans the next value from the decoder, which starts at or after the offset of the decoder.
+func jsonPositionValue(dec *json.Decoder, data []byte, pointer string, positions map[string]Position) error {
+	// the offset is before any separator and whitespace preceding the value
+	offset := dec.InputOffset()
+	for offset < int64(len(data)) && bytes.IndexByte([]byte(" \t\r\n,:"), data[offset]) >= 0 {
+		offset++
+	}
+
+	line, col := offsetPosition(data, offset)
+	positions[pointer] = Position{Line: line, Col: col}
+
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			tok, err = dec.Token()
+			if err != nil {
+				return err
+			}
+
+			key, _ := tok.(string)
+
+			err = jsonPositionValue(dec, data, pointer+"/"+escapePointerToken(key), positions)
+			if err != nil {
+				return err
+			}
+		}
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			err = jsonPositionValue(dec, data, pointer+"/"+strconv.Itoa(i), positions)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+
+	_, err = dec.Token()
+
+	return err
+}
+
+// yamlPositions adds the position of each value in each document of the YAML stream in turn, so that the values of
+// later documents take the place of earlier ones, as they do when the documents are merged.
+func yamlPositions(data []byte, positions map[string]Position) {
+	dec := yaml3.NewDecoder(bytes.NewReader(data))
+
+	for {
+		var doc yaml3.Node
+
+		err := dec.Decode(&doc)
+		if err != nil {
+			return
+		}
+
+		for _, child := range doc.Content {
+			yamlPositionNode(child, "", positions)
+		}
+	}
+}
+
+func yamlPositionNode(node *yaml3.Node, pointer string, positions map[string]Position) {
+	positions[pointer] = Position{Line: node.Line, Col: node.Column}
+
+	if node.Kind == yaml3.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	//nolint:exhaustive // scalars have no values within them
+	switch node.Kind {
+	case yaml3.SequenceNode:
+		for i, child := range node.Content {
+			yamlPositionNode(child, pointer+"/"+strconv.Itoa(i), positions)
+		}
+	case yaml3.MappingNode:
+		// the values of merge keys are taken first, as the keys of the mapping itself take precedence over them
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "<<" {
+				yamlPositionMerge(node.Content[i+1], pointer, positions)
+			}
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i]; key.Value != "<<" {
+				yamlPositionNode(node.Content[i+1], pointer+"/"+escapePointerToken(key.Value), positions)
+			}
+		}
+	}
+}
+
+// yamlPositionMerge adds the positions of the values of a merge key, which is a mapping or a sequence of mappings,
+// where the earlier mappings take precedence.
+func yamlPositionMerge(node *yaml3.Node, pointer string, positions map[string]Position) {
+	if node.Kind == yaml3.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+
+	if node.Kind != yaml3.SequenceNode {
+		pos := positions[pointer]
+		yamlPositionNode(node, pointer, positions)
+		positions[pointer] = pos
+
+		return
+	}
+
+	for i := len(node.Content) - 1; i >= 0; i-- {
+		yamlPositionMerge(node.Content[i], pointer, positions)
+	}
+}
@@ -0,0 +1,99 @@
+package conflate
+
+import (
+	"net/url"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONPositions(t *testing.T) {
+	positions := map[string]Position{}
+
+	jsonPositions([]byte(`{
+  "a": 1,
+  "b": {"c": "x", "d": [true, {"e": null}]},
+  "f~g":   2
+}`), positions)
+
+	assert.Equal(t, map[string]Position{
+		"":         {Line: 1, Col: 1},
+		"/a":       {Line: 2, Col: 8},
+		"/b":       {Line: 3, Col: 8},
+		"/b/c":     {Line: 3, Col: 14},
+		"/b/d":     {Line: 3, Col: 24},
+		"/b/d/0":   {Line: 3, Col: 25},
+		"/b/d/1":   {Line: 3, Col: 31},
+		"/b/d/1/e": {Line: 3, Col: 37},
+		"/f~0g":    {Line: 4, Col: 12},
+	}, positions)
+
+	// the positions before a syntax error are kept
+	positions = map[string]Position{}
+	jsonPositions([]byte(`{"a": 1, "b": }`), positions)
+	assert.Equal(t, map[string]Position{"": {Line: 1, Col: 1}, "/a": {Line: 1, Col: 7}, "/b": {Line: 1, Col: 15}}, positions)
+}
+
+func TestYAMLPositions(t *testing.T) {
+	positions := map[string]Position{}
+
+	yamlPositions([]byte(`base: &base
+  x: 1
+  y: 2
+app:
+  <<: *base
+  y: 3
+  list:
+    - a
+---
+app:
+  z: 4
+`), positions)
+
+	assert.Equal(t, map[string]Position{
+		"":            {Line: 10, Col: 1},
+		"/base":       {Line: 1, Col: 7},
+		"/base/x":     {Line: 2, Col: 6},
+		"/base/y":     {Line: 3, Col: 6},
+		"/app":        {Line: 11, Col: 3},
+		"/app/x":      {Line: 2, Col: 6},
+		"/app/y":      {Line: 6, Col: 6},
+		"/app/list":   {Line: 8, Col: 5},
+		"/app/list/0": {Line: 8, Col: 7},
+		"/app/z":      {Line: 11, Col: 6},
+	}, positions)
+}
+
+func TestFiledata_Positions(t *testing.T) {
+	fd, err := testFiledataNew(t, []byte("a = 1\n"), "file.toml")
+	assert.Nil(t, err)
+	assert.Empty(t, fd.positions())
+
+	fd, err = testFiledataNew(t, []byte("a: 1\n"), "file")
+	assert.Nil(t, err)
+	assert.Equal(t, Position{URL: fd.url, Line: 1, Col: 4}, fd.positions()["/a"])
+}
+
+func TestConflate_PositionsEscaped(t *testing.T) {
+	c := New(WithFS(fstest.MapFS{
+		"a.json": {Data: []byte(`{"a/b": {"x": 1}, "c~d": 2, "e": {"f": 3}}`)},
+	}))
+	c.TrackProvenance(true)
+
+	err := c.AddFiles("a.json")
+	assert.Nil(t, err)
+
+	u, _ := url.Parse("file:///a.json")
+
+	assert.Equal(t, map[string]Position{
+		"/a~1b/x": {URL: u, Line: 1, Col: 15},
+		"/c~0d":   {URL: u, Line: 1, Col: 26},
+		"/e/f":    {URL: u, Line: 1, Col: 40},
+	}, c.Positions())
+
+	for pointer := range c.Positions() {
+		_, ok := c.GetPointer(pointer)
+		assert.True(t, ok, pointer)
+	}
+}
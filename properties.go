@@ -0,0 +1,160 @@
+package conflate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var errPropertiesUnicode = errors.New("invalid unicode escape")
+
+var scalarNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// PropertiesUnmarshal unmarshals the data as Java style .properties, with dotted keys such as db.pool.size=10
+// expanded into nested objects. Values that are JSON numbers or booleans, e.g. 10 or true, are decoded as such,
+// so that they merge with the same values of other formats, and all other values are strings.
+func PropertiesUnmarshal(data []byte, out interface{}) error {
+	obj, err := propertiesParse(data)
+	if err != nil {
+		return fmt.Errorf("the data could not be unmarshalled as properties: %w", err)
+	}
+
+	return jsonMarshalUnmarshal(obj, out)
+}
+
+func propertiesParse(data []byte) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimLeft(scanner.Text(), " \t\f")
+		if text == "" || text[0] == '#' || text[0] == '!' {
+			continue
+		}
+
+		start := line
+
+		for propertiesContinues(text) && scanner.Scan() {
+			line++
+			text = text[:len(text)-1] + strings.TrimLeft(scanner.Text(), " \t\f")
+		}
+
+		rawKey, rawValue := propertiesSplit(text)
+
+		key, err := propertiesUnescape(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w : line %v", err, start)
+		}
+
+		value, err := propertiesUnescape(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("%w : line %v", err, start)
+		}
+
+		err = setNested(obj, strings.Split(key, "."), inferScalar(value))
+		if err != nil {
+			return nil, fmt.Errorf("%w : line %v : %v", err, start, key)
+		}
+	}
+
+	return obj, scanner.Err()
+}
+
+// propertiesContinues reports whether the line ends with an unescaped backslash.
+func propertiesContinues(text string) bool {
+	count := 0
+	for i := len(text) - 1; i >= 0 && text[i] == '\\'; i-- {
+		count++
+	}
+
+	return count%2 == 1
+}
+
+// propertiesSplit splits the line at the first unescaped '=', ':' or whitespace.
+func propertiesSplit(text string) (string, string) {
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '=', ':':
+			return text[:i], strings.TrimLeft(text[i+1:], " \t\f")
+		case ' ', '\t', '\f':
+			value := strings.TrimLeft(text[i:], " \t\f")
+			if value != "" && (value[0] == '=' || value[0] == ':') {
+				value = strings.TrimLeft(value[1:], " \t\f")
+			}
+
+			return text[:i], value
+		}
+	}
+
+	return text, ""
+}
+
+func propertiesUnescape(text string) (string, error) {
+	if !strings.Contains(text, "\\") {
+		return text, nil
+	}
+
+	var sb strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if c != '\\' || i+1 == len(text) {
+			sb.WriteByte(c)
+
+			continue
+		}
+
+		i++
+
+		switch c = text[i]; c {
+		case 't':
+			sb.WriteByte('\t')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'u':
+			if i+5 > len(text) {
+				return "", errPropertiesUnicode
+			}
+
+			r, err := strconv.ParseUint(text[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", errPropertiesUnicode
+			}
+
+			sb.WriteRune(rune(r))
+
+			i += 4
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// inferScalar returns the value as the number or boolean it is in JSON, e.g. 10 or true, or as a string otherwise.
+// Numbers are decoded as for JSON data, so as json.Number when UseNumber is set.
+func inferScalar(value string) interface{} {
+	if value != "true" && value != "false" && !scalarNumber.MatchString(value) {
+		return value
+	}
+
+	var scalar interface{}
+
+	err := JSONUnmarshal([]byte(value), &scalar)
+	if err != nil {
+		return value
+	}
+
+	return scalar
+}
@@ -0,0 +1,83 @@
+package conflate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropertiesUnmarshal(t *testing.T) {
+	var out interface{}
+
+	err := PropertiesUnmarshal([]byte(`
+# comment
+! also a comment
+db.pool.size=10
+db.pool.ratio=-1.5e3
+db.pool.enabled=true
+db.host : example.com
+version=010
+yes=True
+db.user   admin
+name = a \
+       long \
+       value
+path=c:\\dir
+tab=a\tb
+unicode=caf\u00e9
+escaped\=key=x
+empty=
+`), &out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"db": map[string]interface{}{
+			"pool": map[string]interface{}{"size": 10.0, "ratio": -1500.0, "enabled": true},
+			"host": "example.com",
+			"user": "admin",
+		},
+		"name":        "a long value",
+		"path":        `c:\dir`,
+		"tab":         "a\tb",
+		"unicode":     "café",
+		"escaped=key": "x",
+		"version":     "010",
+		"yes":         "True",
+		"empty":       "",
+	}, out)
+}
+
+func TestConflate_PropertiesOverJSON(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.json":        {Data: []byte(`{"db": {"pool": {"size": 5, "enabled": false}, "host": "db"}}`)},
+		"local.properties": {Data: []byte("db.pool.size=10\ndb.pool.enabled=true\ndb.host=localhost\n")},
+	}
+
+	c := New(WithFS(fsys))
+	assert.Nil(t, c.AddFiles("base.json", "local.properties"))
+	assert.Equal(t, map[string]interface{}{
+		"db": map[string]interface{}{
+			"pool": map[string]interface{}{"size": 10.0, "enabled": true},
+			"host": "localhost",
+		},
+	}, c.Data())
+}
+
+func TestPropertiesUnmarshal_Errors(t *testing.T) {
+	tests := []struct {
+		data, msg string
+	}{
+		{"a=1\na.b=2", "key conflicts with another key : line 2 : a.b"},
+		{"a=\\u00", "invalid unicode escape : line 1"},
+		{"a=\\uzzzz", "invalid unicode escape : line 1"},
+	}
+
+	for _, tt := range tests {
+		var out interface{}
+
+		err := PropertiesUnmarshal([]byte(tt.data), &out)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "could not be unmarshalled as properties")
+		assert.Contains(t, err.Error(), tt.msg)
+	}
+}
@@ -0,0 +1,58 @@
+package conflate
+
+import (
+	gocontext "context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy configures how loading a remote url is retried after a connection error or a 5xx response.
+// The zero value makes a single attempt.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func (p RetryPolicy) attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+
+	return p.MaxAttempts
+}
+
+// delay returns the exponential backoff before the given retry, with jitter applied to its upper half.
+func (p RetryPolicy) delay(retry int) time.Duration {
+	const maxShift = 30
+
+	shift := retry - 1
+	if shift > maxShift {
+		shift = maxShift
+	}
+
+	d := p.BaseDelay << shift
+	if p.MaxDelay > 0 && (d > p.MaxDelay || d < 0) {
+		d = p.MaxDelay
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	half := d / 2
+
+	return half + time.Duration(rand.Int63n(int64(d-half)+1)) //nolint:gosec // jitter does not need a secure source
+}
+
+func (p RetryPolicy) wait(ctx gocontext.Context, retry int) error {
+	timer := time.NewTimer(p.delay(retry))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
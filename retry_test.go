@@ -0,0 +1,37 @@
+package conflate
+
+import (
+	gocontext "context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Attempts(t *testing.T) {
+	assert.Equal(t, 1, RetryPolicy{}.attempts())
+	assert.Equal(t, 1, RetryPolicy{MaxAttempts: -1}.attempts())
+	assert.Equal(t, 3, RetryPolicy{MaxAttempts: 3}.attempts())
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for retry, max := range map[int]time.Duration{1: 100, 2: 200, 3: 400, 4: 800, 5: 1000, 100: 1000} {
+		d := p.delay(retry)
+		assert.LessOrEqual(t, d, max*time.Millisecond)
+		assert.GreaterOrEqual(t, d, max*time.Millisecond/2)
+	}
+}
+
+func TestRetryPolicy_DelayZero(t *testing.T) {
+	assert.Equal(t, time.Duration(0), RetryPolicy{}.delay(1))
+}
+
+func TestRetryPolicy_WaitCancelled(t *testing.T) {
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	cancel()
+
+	err := RetryPolicy{BaseDelay: time.Hour}.wait(ctx, 1)
+	assert.ErrorIs(t, err, gocontext.Canceled)
+}
@@ -1,13 +1,16 @@
 package conflate
 
 import (
+	gocontext "context"
 	"errors"
 	"fmt"
 	"math"
 	"net/url"
 	"reflect"
 	"strings"
+	"sync"
 
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"github.com/xeipuuv/gojsonreference"
 	"github.com/xeipuuv/gojsonschema"
 )
@@ -17,17 +20,120 @@ const (
 	draft04   = "http://json-schema.org/draft-04/schema#"
 	draft06   = "http://json-schema.org/draft-06/schema#"
 	draft07   = "http://json-schema.org/draft-07/schema#"
+	draft2019 = "https://json-schema.org/draft/2019-09/schema"
+	draft2020 = "https://json-schema.org/draft/2020-12/schema"
 )
 
+// SchemaDraft is a version of the JSON schema specification.
+type SchemaDraft int
+
+// The JSON schema drafts that can be chosen for validation.
+// DraftAuto detects the draft from the schema's $schema, as Validate does, using draft 4 when there is none.
+const (
+	DraftAuto SchemaDraft = iota
+	Draft4
+	Draft6
+	Draft7
+	Draft2019
+	Draft2020
+)
+
+// schemaDrafts holds the url of each draft, with its version for gojsonschema, or for jsonschema when it is modern.
+var schemaDrafts = map[SchemaDraft]struct {
+	url     string
+	version gojsonschema.Draft
+	modern  *jsonschema.Draft
+}{
+	Draft4:    {url: draft04, version: gojsonschema.Draft4},
+	Draft6:    {url: draft06, version: gojsonschema.Draft6},
+	Draft7:    {url: draft07, version: gojsonschema.Draft7},
+	Draft2019: {url: draft2019, modern: jsonschema.Draft2019},
+	Draft2020: {url: draft2020, modern: jsonschema.Draft2020},
+}
+
+// ValidationError is returned when data is not valid against a schema, and holds every violation that was found.
+type ValidationError struct {
+	errs []*SchemaError
+}
+
+// Errors returns each way in which the data is not valid against the schema.
+func (e *ValidationError) Errors() []*SchemaError {
+	return e.errs
+}
+
+func (e *ValidationError) Error() string {
+	msg := errInvalidPerSchema.Error()
+	for _, err := range e.errs {
+		msg = fmt.Sprintf("%v: %v", msg, err.Error())
+	}
+
+	return msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return errInvalidPerSchema
+}
+
+// SchemaError describes a single schema violation.
+type SchemaError struct {
+	// Pointer is the JSON pointer of the invalid value, which is blank for the root.
+	Pointer string
+	// Constraint is the type of check that failed, e.g. "required" or "number_gte", or the keyword that failed
+	// for drafts 2019-09 and 2020-12, e.g. "minimum".
+	Constraint  string
+	Value       interface{}
+	Description string
+	context     context
+	// cause is the reason a custom format check failed, if any
+	cause error
+}
+
+func (e *SchemaError) Error() string {
+	msg := errWithContext{msg: e.Description, context: e.context}.Error()
+	if e.cause != nil {
+		msg = fmt.Sprintf("%v: %v", msg, e.cause.Error())
+	}
+
+	return msg
+}
+
+func (e *SchemaError) Unwrap() error {
+	return e.cause
+}
+
 var (
 	errInvalidPerSchema       = errors.New("the document is not valid against the schema")
 	errNotSetSchema           = errors.New("schema is not set")
 	errInvalidSchemaStructure = errors.New("invalid schema structure")
+	errUnsupportedDraft       = errors.New("the schema draft is not supported")
 )
 
 // Schema contains a JSON v4 schema.
+// The schema is compiled the first time it is used to validate data as each draft, and the compiled schema is reused
+// by later validations, so a Schema can be shared by goroutines that validate data concurrently.
 type Schema struct {
 	s interface{}
+	// refs holds the documents loaded for the schema's references, keyed by their url
+	refs map[string]interface{}
+	// compiled holds the schema compiled for each draft it has been validated as
+	compiled map[SchemaDraft]compiledSchema
+	mu       sync.Mutex
+}
+
+// CompileSchema loads a JSON v4 schema from the given data and compiles it, so that it is ready to validate data
+// without compiling it again, see Schema.
+func CompileSchema(data []byte) (*Schema, error) {
+	s, err := NewSchemaData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.compile(DraftAuto)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
 }
 
 // NewSchemaFile loads a JSON v4 schema from the given path.
@@ -41,13 +147,13 @@ func NewSchemaFile(path string) (*Schema, error) {
 }
 
 // NewSchemaURL loads a JSON v4 schema from the given URL.
+// References to other documents are resolved relative to the URL, and loaded in the same way as the schema.
 func NewSchemaURL(u *url.URL) (*Schema, error) {
-	data, err := loadURL(u)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load schema url %v: %w", u, err)
-	}
+	var l loader
+
+	defer l.close()
 
-	return NewSchemaData(data)
+	return l.loadSchema(gocontext.Background(), u)
 }
 
 // NewSchemaData loads a JSON v4 schema from the given data.
@@ -64,13 +170,107 @@ func NewSchemaData(data []byte) (*Schema, error) {
 
 // NewSchemaGo creates a Schema instance from a schema represented as a golang object.
 func NewSchemaGo(s interface{}) (*Schema, error) {
+	return newSchema(s, nil)
+}
+
+func newSchema(s interface{}, refs map[string]interface{}) (*Schema, error) {
+	// gojsonschema cannot check a schema of a later draft, which is checked by compiling it
+	if d := declaredDraft(s); d.isModern() {
+		_, err := compileModern(s, refs, d)
+		if err != nil {
+			return nil, fmt.Errorf("the schema is not valid against the meta-schema %v: %w", schemaDrafts[d].url, err)
+		}
+
+		return &Schema{s: s, refs: refs}, nil
+	}
+
 	// validate if the schema is properly constructed by its specified draft
-	draft, err := validateSchema(s)
+	draft, err := validateSchema(s, refs)
 	if err != nil {
 		return nil, fmt.Errorf("the schema is not valid against the meta-schema %v: %w", draft, err)
 	}
 
-	return &Schema{s: s}, nil
+	return &Schema{s: s, refs: refs}, nil
+}
+
+func (l *loader) loadSchema(ctx gocontext.Context, u *url.URL) (*Schema, error) {
+	refs := map[string]interface{}{}
+
+	s, err := l.loadSchemaRecursive(ctx, u, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSchema(s, refs)
+}
+
+// loadSchemaRecursive loads the schema document at the url into refs, followed by the documents it references.
+func (l *loader) loadSchemaRecursive(ctx gocontext.Context, u *url.URL, refs map[string]interface{}) (interface{}, error) {
+	// mark the document as seen before loading, so that circular references are only loaded once
+	refs[u.String()] = nil
+
+	data, err := l.observeLoad(ctx, u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema url %v: %w", redact(u), err)
+	}
+
+	var s interface{}
+
+	err = JSONUnmarshal(data, &s)
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid json: %w", err)
+	}
+
+	refs[u.String()] = s
+
+	for _, ref := range resolveRefs(u, s) {
+		if _, ok := refs[ref.String()]; ok {
+			continue
+		}
+
+		_, err = l.loadSchemaRecursive(ctx, ref, refs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// resolveRefs makes each reference in the schema to another document absolute, relative to the base url,
+// and returns the urls of the documents referenced.
+func resolveRefs(base *url.URL, schema interface{}) []*url.URL {
+	var docs []*url.URL
+
+	switch node := schema.(type) {
+	case map[string]interface{}:
+		for key, val := range node {
+			ref, ok := val.(string)
+			if key != "$ref" || !ok || strings.HasPrefix(ref, "#") {
+				docs = append(docs, resolveRefs(base, val)...)
+
+				continue
+			}
+
+			abs, err := base.Parse(ref)
+			if err != nil {
+				continue
+			}
+
+			node[key] = abs.String()
+
+			doc := *abs
+			doc.Fragment = ""
+			doc.RawFragment = ""
+			docs = append(docs, &doc)
+		}
+	case []interface{}:
+		for _, item := range node {
+			docs = append(docs, resolveRefs(base, item)...)
+		}
+	}
+
+	return docs
 }
 
 // Validate checks the given golang data against the schema.
@@ -79,7 +279,45 @@ func (s *Schema) Validate(data interface{}) error {
 		return errNotSetSchema
 	}
 
-	return validate(data, s.s)
+	return s.ValidateDraft(data, DraftAuto)
+}
+
+// ValidateDraft checks the given golang data against the schema, interpreting the schema as the given draft
+// rather than the one declared by its $schema. Conflate.ValidateDraft adds a warning when the two differ.
+func (s *Schema) ValidateDraft(data interface{}, draft SchemaDraft) error {
+	if s == nil {
+		return errNotSetSchema
+	}
+
+	compiled, err := s.compile(draft)
+	if err != nil {
+		return err
+	}
+
+	return validateCompiled(data, compiled, s.s, s.refs)
+}
+
+// compile returns the schema compiled as the draft, compiling it the first time.
+func (s *Schema) compile(draft SchemaDraft) (compiledSchema, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if compiled, ok := s.compiled[draft]; ok {
+		return compiled, nil
+	}
+
+	compiled, err := compileSchema(s.s, s.refs, draft)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.compiled == nil {
+		s.compiled = map[SchemaDraft]compiledSchema{}
+	}
+
+	s.compiled[draft] = compiled
+
+	return compiled, nil
 }
 
 // ApplyDefaults adds default values defined in the schema to the data pointed to by pData.
@@ -88,7 +326,7 @@ func (s *Schema) ApplyDefaults(pData interface{}) error {
 		return errNotSetSchema
 	}
 
-	return applyDefaults(pData, s.s)
+	return applyDefaults(pData, s.s, s.refs)
 }
 
 var metaSchema interface{}
@@ -118,13 +356,17 @@ func updateMetaSchema(s interface{}) (draft string, err error) {
 	return draft, nil
 }
 
-func validateSchema(schema interface{}) (string, error) {
+func validateSchema(schema interface{}, refs map[string]interface{}) (string, error) {
 	schemaLoader := gojsonschema.NewGoLoader(schema)
 	sl := gojsonschema.NewSchemaLoader()
 	sl.AutoDetect = true
 	sl.Validate = true
 
-	err := sl.AddSchemas(schemaLoader)
+	err := addRefs(sl, refs)
+	if err == nil {
+		err = sl.AddSchemas(schemaLoader)
+	}
+
 	if err != nil {
 		draft := fmt.Sprintf("Draft0%v", sl.Draft)
 		if sl.Draft == math.MaxInt32 {
@@ -143,53 +385,177 @@ func validateSchema(schema interface{}) (string, error) {
 }
 
 func validate(data, schema interface{}) error {
-	dataLoader := gojsonschema.NewGoLoader(data)
+	return validateWithRefs(data, schema, nil, DraftAuto)
+}
+
+func validateWithRefs(data, schema interface{}, refs map[string]interface{}, draft SchemaDraft) error {
+	compiled, err := compileSchema(schema, refs, draft)
+	if err != nil {
+		return err
+	}
+
+	return validateCompiled(data, compiled, schema, refs)
+}
+
+func compileSchema(schema interface{}, refs map[string]interface{}, draft SchemaDraft) (compiledSchema, error) {
+	if draft == DraftAuto && declaredDraft(schema).isModern() {
+		draft = declaredDraft(schema)
+	}
+
+	if draft.isModern() {
+		compiled, err := compileModern(schema, refs, draft)
+		if err != nil {
+			return nil, fmt.Errorf("an error occurred during validation: %w", err)
+		}
+
+		return &modernCompiled{s: compiled}, nil
+	}
+
 	schemaLoader := gojsonschema.NewGoLoader(schema)
+	sl := gojsonschema.NewSchemaLoader()
 
-	formatErrs.clear()
+	err := draft.configure(sl)
+	if err == nil {
+		err = addRefs(sl, refs)
+	}
 
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
 	if err != nil {
-		return fmt.Errorf("an error occurred during validation: %w", err)
+		return nil, fmt.Errorf("an error occurred during validation: %w", err)
 	}
 
-	err = processResult(result)
+	compiled, err := sl.Compile(schemaLoader)
 	if err != nil {
+		return nil, fmt.Errorf("an error occurred during validation: %w", err)
+	}
+
+	return &legacyCompiled{s: compiled}, nil
+}
+
+// validateCompiled checks the data against the compiled schema, where the schema and refs it was compiled from
+// are checked for unknown formats when FailUnknownFormats is set.
+func validateCompiled(data interface{}, compiled compiledSchema, schema interface{}, refs map[string]interface{}) error {
+	if FailUnknownFormats {
+		err := checkSchemaFormatsKnown(schema, refs)
+		if err != nil {
+			return fmt.Errorf("an error occurred during validation: %w", err)
+		}
+	}
+
+	err := compiled.validate(data, schema, refs)
+
+	var verr *ValidationError
+	if errors.As(err, &verr) {
 		return fmt.Errorf("schema validation failed: %w", err)
 	}
 
+	return err
+}
+
+// configure makes the loader interpret schemas as the draft, unless it is DraftAuto.
+func (d SchemaDraft) configure(sl *gojsonschema.SchemaLoader) error {
+	if d == DraftAuto {
+		return nil
+	}
+
+	draft, ok := schemaDrafts[d]
+	if !ok || draft.version == 0 {
+		return fmt.Errorf("%w : %v", errUnsupportedDraft, d)
+	}
+
+	sl.AutoDetect = false
+	sl.Draft = draft.version
+
 	return nil
 }
 
-func processResult(result *gojsonschema.Result) error {
-	if !result.Valid() {
-		err := errInvalidPerSchema
+func (d SchemaDraft) String() string {
+	switch d {
+	case DraftAuto:
+		return "DraftAuto"
+	case Draft4:
+		return "Draft4"
+	case Draft6:
+		return "Draft6"
+	case Draft7:
+		return "Draft7"
+	case Draft2019:
+		return "Draft2019"
+	case Draft2020:
+		return "Draft2020"
+	default:
+		return fmt.Sprintf("SchemaDraft(%d)", int(d))
+	}
+}
 
-		for _, rerr := range result.Errors() {
-			ctx := convertJSONContext(rerr.Context().String())
-			ctxErr := &errWithContext{msg: rerr.Description(), context: ctx}
+func checkSchemaFormatsKnown(schema interface{}, refs map[string]interface{}) error {
+	err := checkFormatsKnown(schema)
 
-			err = fmt.Errorf("%w: %v", err, ctxErr)
-			ferr := formatErrs.get(rerr.Details()["format"], rerr.Value())
-			if ferr != nil {
-				err = fmt.Errorf("%w: %v", err, ferr.Error())
-			}
+	for _, doc := range refs {
+		if err != nil {
+			break
 		}
 
-		return err
+		err = checkFormatsKnown(doc)
+	}
+
+	return err
+}
+
+// addRefs adds the referenced documents to the loader, so that references to them resolve without fetching them.
+func addRefs(sl *gojsonschema.SchemaLoader, refs map[string]interface{}) error {
+	for u, doc := range refs {
+		err := sl.AddSchema(u, gojsonschema.NewGoLoader(doc))
+		if err != nil {
+			return fmt.Errorf("could not add referenced schema: %w", err)
+		}
 	}
 
 	return nil
 }
 
-func convertJSONContext(jsonCtx string) context {
-	parts := strings.Split(jsonCtx, ".")
+func processResult(result *gojsonschema.Result) error {
+	if result.Valid() {
+		return nil
+	}
+
+	verr := &ValidationError{}
+
+	for _, rerr := range result.Errors() {
+		ctx := convertJSONContext(rerr.Context())
+
+		// the validator decodes numbers as json.Number, so convert the value back to match the data
+		var value interface{}
+		if err := jsonMarshalUnmarshal(rerr.Value(), &value); err != nil {
+			value = rerr.Value()
+		}
+
+		serr := &SchemaError{
+			Pointer:     ctx.pointer(),
+			Constraint:  rerr.Type(),
+			Value:       value,
+			Description: rerr.Description(),
+			context:     ctx,
+			cause:       formatErrs.get(rerr.Details()["format"], rerr.Value()),
+		}
+
+		verr.errs = append(verr.errs, serr)
+	}
+
+	return verr
+}
+
+// convertJSONContext converts the context of an error from gojsonschema, which is split on a NUL rather than its
+// default '.', as a name in the data can contain a '.'.
+func convertJSONContext(jsonCtx *gojsonschema.JsonContext) context {
+	parts := strings.Split(jsonCtx.String("\x00"), "\x00")
 
 	return rootContext().add(parts[1:]...)
 }
 
-func applyDefaults(pData, schema interface{}) error {
-	err := applyDefaultsRecursive(rootContext(), schema, pData, schema)
+// applyDefaults adds the defaults of the schema to the data, where refs holds the documents referenced by the schema
+// by their urls.
+func applyDefaults(pData, schema interface{}, refs map[string]interface{}) error {
+	err := applyDefaultsRecursive(rootContext(), refs, schema, pData, schema)
 	if err != nil {
 		return fmt.Errorf("the defaults could not be applied: %w", err)
 	}
@@ -197,7 +563,7 @@ func applyDefaults(pData, schema interface{}) error {
 	return nil
 }
 
-func applyDefaultsRecursive(ctx context, rootSchema, pData, schema interface{}) error {
+func applyDefaultsRecursive(ctx context, refs map[string]interface{}, rootSchema, pData, schema interface{}) error {
 	if pData == nil {
 		return &errWithContext{context: ctx, msg: "destination value must not be nil"}
 	}
@@ -227,12 +593,20 @@ func applyDefaultsRecursive(ctx context, rootSchema, pData, schema interface{})
 			return &errWithContext{context: ctx, msg: fmt.Sprintf("invalid reference '%v': %v", ref, err.Error())}
 		}
 
+		// a reference to another document was made absolute as the schema was loaded, see resolveRefs
+		if doc := refDocument(jref); doc != "" {
+			rootSchema, ok = refs[doc]
+			if !ok {
+				return &errWithContext{context: ctx, msg: fmt.Sprintf("cannot find the document of reference '%v'", ref)}
+			}
+		}
+
 		subSchema, _, err := jref.GetPointer().Get(rootSchema)
 		if subSchema == nil || err != nil {
 			return &errWithContext{context: ctx, msg: fmt.Sprintf("cannot find reference '%v': %v", ref, err.Error())}
 		}
 
-		return applyDefaultsRecursive(ctx.add(ref), rootSchema, pData, subSchema)
+		return applyDefaultsRecursive(ctx, refs, rootSchema, pData, subSchema)
 	}
 
 	schemaType, ok := schemaNode["type"]
@@ -246,7 +620,7 @@ func applyDefaultsRecursive(ctx context, rootSchema, pData, schema interface{})
 	}
 
 	if value, ok := schemaNode["default"]; ok && data == nil {
-		defaultVal := reflect.ValueOf(value)
+		defaultVal := reflect.ValueOf(copyValue(value, true))
 		dataVal.Set(defaultVal)
 		data = dataVal.Interface()
 	}
@@ -255,14 +629,27 @@ func applyDefaultsRecursive(ctx context, rootSchema, pData, schema interface{})
 
 	switch schemaType {
 	case "object":
-		err = applyObjectDefaults(ctx, rootSchema, data, schemaNode)
+		err = applyObjectDefaults(ctx, refs, rootSchema, data, schemaNode)
 	case "array":
-		err = applyArrayDefaults(ctx, rootSchema, data, schemaNode)
+		err = applyArrayDefaults(ctx, refs, rootSchema, data, schemaNode)
 	}
 
 	return err
 }
 
+// refDocument returns the url of the document a reference refers to, or blank for a reference within the document.
+func refDocument(jref gojsonreference.JsonReference) string {
+	if jref.GetUrl() == nil {
+		return ""
+	}
+
+	doc := *jref.GetUrl()
+	doc.Fragment = ""
+	doc.RawFragment = ""
+
+	return doc.String()
+}
+
 func hasKey(m map[string]interface{}, keys ...string) bool {
 	for _, key := range keys {
 		if _, ok := m[key]; ok {
@@ -273,7 +660,7 @@ func hasKey(m map[string]interface{}, keys ...string) bool {
 	return false
 }
 
-func applyObjectDefaults(ctx context, rootSchema, data interface{}, schemaNode map[string]interface{}) error {
+func applyObjectDefaults(ctx context, refs map[string]interface{}, rootSchema, data interface{}, schemaNode map[string]interface{}) error {
 	if data == nil {
 		return nil
 	}
@@ -293,7 +680,7 @@ func applyObjectDefaults(ctx context, rootSchema, data interface{}, schemaNode m
 		for name, schemaProp := range schemaProps {
 			dataProp := dataProps[name]
 
-			err := applyDefaultsRecursive(ctx.add(name), rootSchema, &dataProp, schemaProp)
+			err := applyDefaultsRecursive(ctx.add(name), refs, rootSchema, &dataProp, schemaProp)
 			if err != nil {
 				return fmt.Errorf("failed to apply defaults to object property: %w", err)
 			}
@@ -309,7 +696,7 @@ func applyObjectDefaults(ctx context, rootSchema, data interface{}, schemaNode m
 		if addProps, ok = addProps.(map[string]interface{}); ok {
 			for name, dataProp := range dataProps {
 				if schemaProps == nil || schemaProps[name] == nil {
-					err := applyDefaultsRecursive(ctx.add(name), rootSchema, &dataProp, addProps) //nolint:gosec,scopelint // to be refactored carefully
+					err := applyDefaultsRecursive(ctx.add(name), refs, rootSchema, &dataProp, addProps) //nolint:gosec,scopelint // to be refactored carefully
 					if err != nil {
 						return fmt.Errorf("failed to apply defaults to additional object property: %w", err)
 					}
@@ -325,7 +712,7 @@ func applyObjectDefaults(ctx context, rootSchema, data interface{}, schemaNode m
 	return nil
 }
 
-func applyArrayDefaults(ctx context, rootSchema, data interface{}, schemaNode map[string]interface{}) error {
+func applyArrayDefaults(ctx context, refs map[string]interface{}, rootSchema, data interface{}, schemaNode map[string]interface{}) error {
 	if data == nil {
 		return nil
 	}
@@ -335,18 +722,34 @@ func applyArrayDefaults(ctx context, rootSchema, data interface{}, schemaNode ma
 		return &errWithContext{context: ctx, msg: "node should be an 'array'"}
 	}
 
-	if items, ok := schemaNode["items"]; ok {
-		schemaItem := items.(map[string]interface{})
+	for i, dataItem := range dataItems {
+		schemaItem := arrayItemSchema(schemaNode["items"], i)
+		if schemaItem == nil {
+			continue
+		}
 
-		for i, dataItem := range dataItems {
-			err := applyDefaultsRecursive(ctx.addInt(i), rootSchema, &dataItem, schemaItem) //nolint:gosec,scopelint // to be refactored carefully
-			if err != nil {
-				return fmt.Errorf("failed to apply defaults to array item: %w", err)
-			}
+		err := applyDefaultsRecursive(ctx.addInt(i), refs, rootSchema, &dataItem, schemaItem) //nolint:gosec,scopelint // to be refactored carefully
+		if err != nil {
+			return fmt.Errorf("failed to apply defaults to array item: %w", err)
+		}
 
-			if dataItem != nil {
-				dataItems[i] = dataItem
-			}
+		if dataItem != nil {
+			dataItems[i] = dataItem
+		}
+	}
+
+	return nil
+}
+
+// arrayItemSchema returns the schema for the array item at index i, where items is either a schema for all items,
+// or an array of schemas for the items at each position.
+func arrayItemSchema(items interface{}, i int) interface{} {
+	switch items := items.(type) {
+	case map[string]interface{}:
+		return items
+	case []interface{}:
+		if i < len(items) {
+			return items[i]
 		}
 	}
 
@@ -1,6 +1,12 @@
 package conflate
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -142,6 +148,39 @@ func TestNewSchemaGo_ValidateInvalidSchema(t *testing.T) {
 	assert.Contains(t, err.Error(), "schema validation failed")
 }
 
+func TestResolveRefs(t *testing.T) {
+	base, err := url.Parse("gs://bucket/schemas/main.json")
+	assert.Nil(t, err)
+
+	schema := map[string]interface{}{
+		"properties": map[string]interface{}{
+			"a": map[string]interface{}{"$ref": "#/definitions/a"},
+			"b": map[string]interface{}{"$ref": "defs.json#/definitions/b"},
+		},
+		"anyOf": []interface{}{
+			map[string]interface{}{"$ref": "../other.json"},
+		},
+	}
+
+	docs := resolveRefs(base, schema)
+
+	var urls []string
+	for _, doc := range docs {
+		urls = append(urls, doc.String())
+	}
+
+	assert.ElementsMatch(t, []string{"gs://bucket/schemas/defs.json", "gs://bucket/other.json"}, urls)
+	assert.Equal(t, map[string]interface{}{
+		"properties": map[string]interface{}{
+			"a": map[string]interface{}{"$ref": "#/definitions/a"},
+			"b": map[string]interface{}{"$ref": "gs://bucket/schemas/defs.json#/definitions/b"},
+		},
+		"anyOf": []interface{}{
+			map[string]interface{}{"$ref": "gs://bucket/other.json"},
+		},
+	}, schema)
+}
+
 func TestValidate(t *testing.T) {
 	var data, schema interface{}
 
@@ -155,6 +194,194 @@ func TestValidate(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestSchema_ValidateDraft(t *testing.T) {
+	// const is not a keyword in draft 4, so it is ignored
+	s, err := NewSchemaData([]byte(`{"properties": {"n": {"const": 1}}}`))
+	assert.Nil(t, err)
+
+	data := map[string]interface{}{"n": 2.0}
+
+	err = s.ValidateDraft(data, Draft4)
+	assert.Nil(t, err)
+
+	err = s.ValidateDraft(data, Draft7)
+	assert.ErrorIs(t, err, errInvalidPerSchema)
+
+	err = s.ValidateDraft(data, Draft2020)
+	assert.ErrorIs(t, err, errInvalidPerSchema)
+
+	err = s.ValidateDraft(data, SchemaDraft(99))
+	assert.ErrorIs(t, err, errUnsupportedDraft)
+	assert.Contains(t, err.Error(), "SchemaDraft(99)")
+
+	s = nil
+	err = s.ValidateDraft(data, Draft7)
+	assert.ErrorIs(t, err, errNotSetSchema)
+}
+
+func TestCompileSchema(t *testing.T) {
+	s, err := CompileSchema([]byte(`{"properties": {"n": {"type": "integer"}}}`))
+	assert.Nil(t, err)
+	assert.Len(t, s.compiled, 1)
+
+	compiled := s.compiled[DraftAuto]
+
+	assert.Nil(t, s.Validate(map[string]interface{}{"n": 1.0}))
+	assert.ErrorIs(t, s.Validate(map[string]interface{}{"n": "a"}), errInvalidPerSchema)
+	assert.Same(t, compiled, s.compiled[DraftAuto])
+
+	// a draft that cannot be compiled is not cached
+	assert.ErrorIs(t, s.ValidateDraft(map[string]interface{}{}, SchemaDraft(99)), errUnsupportedDraft)
+	assert.Nil(t, s.ValidateDraft(map[string]interface{}{}, Draft7))
+	assert.Len(t, s.compiled, 2)
+
+	_, err = CompileSchema([]byte(`{"type": 1}`))
+	assert.NotNil(t, err)
+
+	_, err = CompileSchema([]byte(`{`))
+	assert.Contains(t, err.Error(), "schema is not valid json")
+}
+
+func TestSchema_ValidateConcurrent(t *testing.T) {
+	s, err := NewSchemaData([]byte(`{"properties": {"x": {"type": "string", "format": "xml"}}}`))
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			err := s.Validate(map[string]interface{}{"x": fmt.Sprintf("<a>%d</a>", i)})
+			assert.Nil(t, err)
+
+			err = s.Validate(map[string]interface{}{"x": fmt.Sprintf("<a>%d", i)})
+			assert.ErrorIs(t, err, errInvalidPerSchema)
+			assert.Contains(t, err.Error(), "XML syntax error")
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func BenchmarkSchema_Validate(b *testing.B) {
+	var data interface{}
+
+	err := JSONUnmarshal(testSchemaData, &data)
+	assert.Nil(b, err)
+
+	s, err := CompileSchema(testSchema)
+	assert.Nil(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := s.Validate(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestConflate_ValidateDraftMismatch(t *testing.T) {
+	s, err := NewSchemaData([]byte(`{"$schema": "http://json-schema.org/draft-07/schema#", "type": "object"}`))
+	assert.Nil(t, err)
+
+	c, err := FromData([]byte(`{}`))
+	assert.Nil(t, err)
+
+	err = c.ValidateDraft(s, Draft7)
+	assert.Nil(t, err)
+	assert.Empty(t, c.Result().Warnings)
+
+	err = c.ValidateDraft(s, Draft6)
+	assert.Nil(t, err)
+	assert.Equal(t, []Warning{{
+		Code:    WarnSchemaDraftMismatch,
+		Message: "the schema declared as http://json-schema.org/draft-07/schema# is validated as Draft6",
+	}}, c.Result().Warnings)
+}
+
+func TestSchema_ValidatePointers(t *testing.T) {
+	for _, schema := range []string{
+		`{"properties": {"a/b": {"properties": {"c.d": {"type": "integer"}}}}}`,
+		`{"$schema": "https://json-schema.org/draft/2020-12/schema",
+			"properties": {"a/b": {"properties": {"c.d": {"type": "integer"}}}}}`,
+	} {
+		s, err := NewSchemaData([]byte(schema))
+		assert.Nil(t, err)
+
+		data := map[string]interface{}{"a/b": map[string]interface{}{"c.d": "x"}}
+
+		var verr *ValidationError
+
+		err = s.Validate(data)
+		assert.ErrorAs(t, err, &verr)
+		assert.Equal(t, "/a~1b/c.d", verr.Errors()[0].Pointer)
+		assert.Contains(t, err.Error(), "(#/a~1b/c.d)")
+
+		value, ok := lookupPointer(data, verr.Errors()[0].Pointer)
+		assert.True(t, ok)
+		assert.Equal(t, "x", value)
+	}
+}
+
+func TestSchema_Draft2020(t *testing.T) {
+	initFormatCheckers()
+
+	s, err := NewSchemaData([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$defs": {"port": {"type": "integer", "minimum": 1}},
+		"properties": {
+			"port": {"$ref": "#/$defs/port"},
+			"pair": {"prefixItems": [{"type": "string"}, {"type": "integer"}], "items": false},
+			"doc": {"type": "string", "format": "xml"}
+		}
+	}`))
+	assert.Nil(t, err)
+
+	err = s.Validate(map[string]interface{}{"port": 80, "pair": []interface{}{"a", 1}, "doc": "<a/>"})
+	assert.Nil(t, err)
+
+	err = s.Validate(map[string]interface{}{"port": 0, "pair": []interface{}{1, 1, 1}, "doc": "<a>"})
+	assert.ErrorIs(t, err, errInvalidPerSchema)
+
+	var verr *ValidationError
+
+	assert.ErrorAs(t, err, &verr)
+
+	byPointer := map[string]*SchemaError{}
+	for _, serr := range verr.Errors() {
+		byPointer[serr.Pointer] = serr
+	}
+
+	assert.Equal(t, "minimum", byPointer["/port"].Constraint)
+	assert.Equal(t, 0.0, byPointer["/port"].Value)
+	assert.Equal(t, "type", byPointer["/pair/0"].Constraint)
+	assert.Equal(t, "items", byPointer["/pair/2"].Constraint)
+	assert.Equal(t, "format", byPointer["/doc"].Constraint)
+	assert.Contains(t, byPointer["/doc"].Error(), "XML syntax error")
+
+	// the schema is checked against the meta-schema of its draft
+	_, err = NewSchemaData([]byte(`{"$schema": "https://json-schema.org/draft/2020-12/schema", "prefixItems": {}}`))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "draft/2020-12")
+}
+
+func TestSchema_ValidateDraft2019(t *testing.T) {
+	// dependentRequired is only a keyword from draft 2019-09
+	s, err := NewSchemaData([]byte(`{"dependentRequired": {"a": ["b"]}}`))
+	assert.Nil(t, err)
+
+	data := map[string]interface{}{"a": 1.0}
+
+	assert.Nil(t, s.Validate(data))
+	assert.ErrorIs(t, s.ValidateDraft(data, Draft2019), errInvalidPerSchema)
+	assert.ErrorIs(t, s.ValidateDraft(data, Draft2020), errInvalidPerSchema)
+}
+
 func TestValidate_ValidateSchemaError(t *testing.T) {
 	var data, schema interface{}
 
@@ -188,6 +415,50 @@ func TestValidate_NotValid(t *testing.T) {
 	assert.Contains(t, err.Error(), "(#/obj/str)")
 }
 
+func TestValidate_AllErrors(t *testing.T) {
+	s, err := NewSchemaData([]byte(`{
+		"type": "object",
+		"required": ["name"],
+		"properties": {
+			"port": { "type": "integer", "minimum": 1 },
+			"tags": { "type": "array", "items": { "type": "string" } }
+		}
+	}`))
+	assert.Nil(t, err)
+
+	var data interface{}
+
+	err = JSONUnmarshal([]byte(`{"port": 0, "tags": ["a", 1]}`), &data)
+	assert.Nil(t, err)
+
+	err = s.Validate(data)
+	assert.ErrorIs(t, err, errInvalidPerSchema)
+
+	var verr *ValidationError
+
+	assert.True(t, errors.As(err, &verr))
+
+	type failure struct {
+		pointer, constraint string
+		value               interface{}
+	}
+
+	var failures []failure
+	for _, serr := range verr.Errors() {
+		failures = append(failures, failure{serr.Pointer, serr.Constraint, serr.Value})
+	}
+
+	assert.ElementsMatch(t, []failure{
+		{"", "required", map[string]interface{}{"port": 0.0, "tags": []interface{}{"a", 1.0}}},
+		{"/port", "number_gte", 0.0},
+		{"/tags/1", "invalid_type", 1.0},
+	}, failures)
+
+	for _, serr := range verr.Errors() {
+		assert.Contains(t, err.Error(), serr.Error())
+	}
+}
+
 func TestValidate_CustomFormatError(t *testing.T) {
 	var (
 		data   interface{}
@@ -213,9 +484,48 @@ func TestValidate_CustomFormatError(t *testing.T) {
 
 // -----------
 
+func TestSchema_ApplyDefaultsExternalRef(t *testing.T) {
+	dir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{
+		"type": "object",
+		"definitions": {"x": {"type": "string", "default": "ax"}},
+		"properties": {
+			"x": {"$ref": "b.json#/definitions/x"},
+			"y": {"$ref": "b.json#/definitions/y"}
+		}
+	}`), 0o600)
+	assert.Nil(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "b.json"), []byte(`{
+		"definitions": {
+			"x": {"type": "string", "default": "bx"},
+			"y": {"$ref": "#/definitions/z"},
+			"z": {"type": "integer", "default": 7}
+		}
+	}`), 0o600)
+	assert.Nil(t, err)
+
+	s, err := NewSchemaFile(filepath.Join(dir, "a.json"))
+	assert.Nil(t, err)
+
+	data := map[string]interface{}{}
+	assert.Nil(t, s.Validate(data))
+
+	var out interface{} = data
+
+	err = s.ApplyDefaults(&out)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": "bx", "y": 7.0}, out)
+
+	err = applyDefaults(&out, map[string]interface{}{"$ref": "file:///missing.json#/x"}, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "cannot find the document of reference")
+}
+
 func TestApplyDefaults_DataNil(t *testing.T) {
 	schema := map[string]interface{}{}
-	err := applyDefaults(nil, schema)
+	err := applyDefaults(nil, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "destination value must not be nil")
 }
@@ -224,7 +534,7 @@ func TestApplyDefaults_DataNotPtr(t *testing.T) {
 	data := 1
 
 	schema := map[string]interface{}{}
-	err := applyDefaults(data, schema)
+	err := applyDefaults(data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "destination value must be a pointer")
 }
@@ -232,15 +542,35 @@ func TestApplyDefaults_DataNotPtr(t *testing.T) {
 func TestApplyDefaults_SchemaNotMap(t *testing.T) {
 	data := 1
 	schema := map[string]interface{}{"anyOf": nil}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, data)
 }
 
+func TestApplyDefaults_DoesNotAliasSchema(t *testing.T) {
+	s, err := NewSchemaData([]byte(`{"type": "object", "properties": {"obj": {"type": "object", "default": {"x": 1}}}}`))
+	assert.Nil(t, err)
+
+	var first, second map[string]interface{}
+
+	err = JSONUnmarshal([]byte(`{}`), &first)
+	assert.Nil(t, err)
+	err = s.ApplyDefaults(&first)
+	assert.Nil(t, err)
+
+	first["obj"].(map[string]interface{})["x"] = 2
+
+	err = JSONUnmarshal([]byte(`{}`), &second)
+	assert.Nil(t, err)
+	err = s.ApplyDefaults(&second)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"x": 1.0}, second["obj"])
+}
+
 func TestApplyDefaults_SchemaNoType(t *testing.T) {
 	data := 1
 	schema := map[string]interface{}{}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "Schema section does not have a valid 'type' attribute")
 	assert.Equal(t, 1, data)
@@ -251,7 +581,7 @@ func TestApplyDefaults_SchemaNoType(t *testing.T) {
 func TestApplyDefaults_NodeNotObject(t *testing.T) {
 	data := 1
 	schema := map[string]interface{}{"type": "object"}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "node should be an 'object'")
 }
@@ -265,7 +595,7 @@ func TestApplyDefaults_ObjectDefault(t *testing.T) {
 			"val": 1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, map[string]interface{}{"val": 1}, data)
 }
@@ -278,7 +608,7 @@ func TestApplyDefaults_ObjectDefaultNotApplied(t *testing.T) {
 			"val": 1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, map[string]interface{}{"other": 1}, data)
 }
@@ -296,7 +626,7 @@ func TestApplyDefaults_ObjectPropertyDefault(t *testing.T) {
 			},
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, map[string]interface{}{"val": 1}, data)
 }
@@ -313,7 +643,7 @@ func TestApplyDefaults_ObjectPropertyNilMap(t *testing.T) {
 			},
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, map[string]interface{}(nil), data)
 }
@@ -329,7 +659,7 @@ func TestApplyDefaults_ObjectPropertyEmptyMap(t *testing.T) {
 			},
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, map[string]interface{}{"val": 1}, data)
 }
@@ -346,7 +676,7 @@ func TestApplyDefaults_ObjectPropertyDefaultNotApplied(t *testing.T) {
 			},
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, map[string]interface{}{"val": 1, "other": 1}, data)
 }
@@ -363,7 +693,7 @@ func TestApplyDefaults_ObjectPropertyDefaultNoParentDefault(t *testing.T) {
 			},
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, nil, data)
 }
@@ -376,7 +706,7 @@ func TestApplyDefaults_ObjectPropertyFailed(t *testing.T) {
 			"val": nil,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to apply defaults to object property")
 	assert.Contains(t, err.Error(), "schema section is not a map (#/val)")
@@ -391,7 +721,7 @@ func TestApplyDefaults_ObjectAdditionalPropertyDefault(t *testing.T) {
 			"default": 1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, 1, data["val"])
 }
@@ -402,7 +732,7 @@ func TestApplyDefaults_ObjectAdditionalPropertyFailed(t *testing.T) {
 		"type":                 "object",
 		"additionalProperties": map[string]interface{}{},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to apply defaults to additional object property")
 	assert.Contains(t, err.Error(), "Schema section does not have a valid 'type' attribute")
@@ -414,7 +744,7 @@ func TestApplyDefaults_ObjectAdditionalPropertyBool(t *testing.T) {
 		"type":                 "object",
 		"additionalProperties": false,
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 }
 
@@ -428,7 +758,7 @@ func TestApplyDefaults_ArrayNoDefault(t *testing.T) {
 			"default": 1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, nil, data)
 }
@@ -444,7 +774,7 @@ func TestApplyDefaults_ArrayDefault(t *testing.T) {
 			"default": 1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, []interface{}{}, data)
 }
@@ -459,7 +789,7 @@ func TestApplyDefaults_ArrayElementDefaultNil(t *testing.T) {
 			"default": 1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, []interface{}(nil), data)
 }
@@ -473,7 +803,7 @@ func TestApplyDefaults_ArrayElementDefault(t *testing.T) {
 			"default": 1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, []interface{}{1}, data)
 }
@@ -483,7 +813,7 @@ func TestApplyDefaults_ArrayElementDefault(t *testing.T) {
 func TestApplyDefaults_NodeNotSlice(t *testing.T) {
 	data := 1
 	schema := map[string]interface{}{"type": "array"}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "node should be an 'array'", err.Error())
 }
@@ -497,7 +827,7 @@ func TestApplyDefaults_SliceDefault(t *testing.T) {
 			1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, []interface{}{1}, data)
 }
@@ -515,7 +845,7 @@ func TestApplyDefaults_SliceDefaultWithElementDefault(t *testing.T) {
 			"default": 1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, []interface{}{1}, data)
 }
@@ -529,21 +859,63 @@ func TestApplyDefaults_SliceElementDefault(t *testing.T) {
 			"default": 1,
 		},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, []interface{}{1}, data)
 }
 
+func TestApplyDefaults_TupleItemDefaults(t *testing.T) {
+	var data, schema interface{}
+
+	err := JSONUnmarshal([]byte(`[{}, {}, {}]`), &data)
+	assert.Nil(t, err)
+
+	err = JSONUnmarshal([]byte(`{
+		"type": "array",
+		"items": [
+			{ "type": "object", "properties": { "a": { "type": "integer", "default": 1 } } },
+			{ "type": "object", "properties": { "b": { "type": "integer", "default": 2 } } }
+		]
+	}`), &schema)
+	assert.Nil(t, err)
+
+	err = applyDefaults(&data, schema, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"a": 1.0},
+		map[string]interface{}{"b": 2.0},
+		map[string]interface{}{},
+	}, data)
+}
+
+func TestApplyDefaults_ConflictError(t *testing.T) {
+	var data, schema interface{}
+
+	err := JSONUnmarshal([]byte(`{"obj": [1]}`), &data)
+	assert.Nil(t, err)
+
+	err = JSONUnmarshal([]byte(`{
+		"type": "object",
+		"properties": { "obj": { "type": "object", "properties": { "x": { "type": "integer", "default": 1 } } } }
+	}`), &schema)
+	assert.Nil(t, err)
+
+	err = applyDefaults(&data, schema, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "node should be an 'object'")
+	assert.Contains(t, err.Error(), "#/obj")
+}
+
 func TestApplyDefaults_SliceFailed(t *testing.T) {
 	data := []interface{}{1}
 	schema := map[string]interface{}{
 		"type":  "array",
 		"items": map[string]interface{}{},
 	}
-	err := applyDefaults(&data, schema)
+	err := applyDefaults(&data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "failed to apply defaults to array item")
-	assert.Contains(t, err.Error(), "Schema section does not have a valid 'type' attribute (#[0])")
+	assert.Contains(t, err.Error(), "Schema section does not have a valid 'type' attribute (#/0)")
 }
 
 func TestApplyDefaults_Empty(t *testing.T) {
@@ -555,7 +927,7 @@ func TestApplyDefaults_Empty(t *testing.T) {
 	err = JSONUnmarshal(testSchema, &schema)
 	assert.Nil(t, err)
 
-	err = applyDefaults(&data, schema)
+	err = applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, defaults, data)
 }
@@ -572,7 +944,7 @@ func TestApplyDefaults_NoDefaults(t *testing.T) {
 	err = JSONUnmarshal(testSchema, &schema)
 	assert.Nil(t, err)
 
-	err = applyDefaults(&data, schema)
+	err = applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, dataExpected, data)
 }
@@ -598,7 +970,7 @@ func TestApplyDefaults_MissingIntFields(t *testing.T) {
 	arrObj := arr[0].(map[string]interface{})
 	delete(arrObj, "int")
 
-	err = applyDefaults(&data, schema)
+	err = applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, 1.0, data["int"])
 	assert.Equal(t, []interface{}{1.0}, data["array_of_int"])
@@ -630,7 +1002,7 @@ func TestApplyDefaults_Ref(t *testing.T) {
 	err = JSONUnmarshal(schemaData, &schema)
 	assert.Nil(t, err)
 
-	err = applyDefaults(&data, schema)
+	err = applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 	assert.Equal(t, map[string]interface{}{"int": 1.0, "obj": map[string]interface{}{"int": 1.0}}, data)
 }
@@ -655,7 +1027,7 @@ func TestApplyDefaults_RefNotStringError(t *testing.T) {
 	err = JSONUnmarshal(schemaData, &schema)
 	assert.Nil(t, err)
 
-	err = applyDefaults(&data, schema)
+	err = applyDefaults(&data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "reference is not a string")
 }
@@ -680,7 +1052,7 @@ func TestApplyDefaults_RefInvalidError(t *testing.T) {
 	err = JSONUnmarshal(schemaData, &schema)
 	assert.Nil(t, err)
 
-	err = applyDefaults(&data, schema)
+	err = applyDefaults(&data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "invalid reference")
 }
@@ -705,7 +1077,7 @@ func TestApplyDefaults_RefPointerError(t *testing.T) {
 	err = JSONUnmarshal(schemaData, &schema)
 	assert.Nil(t, err)
 
-	err = applyDefaults(&data, schema)
+	err = applyDefaults(&data, schema, nil)
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "cannot find reference")
 }
@@ -747,7 +1119,7 @@ func TestApplyDefaults_OneOfWithValidType(t *testing.T) {
 	err = JSONUnmarshal(schemaData, &schema)
 	assert.Nil(t, err)
 
-	err = applyDefaults(&data, schema)
+	err = applyDefaults(&data, schema, nil)
 	assert.Nil(t, err)
 
 	outData, err := jsonMarshal(data)
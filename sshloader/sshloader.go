@@ -0,0 +1,282 @@
+// Package sshloader loads conflate data from ssh and scp urls, e.g. ssh://deploy@bastion/etc/app/config.yaml,
+// by copying the file over an SSH connection. It is a separate package so that programs that do not need it do not
+// depend on the SSH client.
+package sshloader
+
+import (
+	"bufio"
+	gocontext "context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	pkgurl "net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/diurnalist/conflate"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Schemes are the url schemes registered by Register.
+var Schemes = []string{"ssh", "scp"}
+
+var (
+	errNoAgent  = errors.New("no ssh agent is running, as SSH_AUTH_SOCK is not set")
+	errNoPath   = errors.New("the url has no path to copy")
+	errProtocol = errors.New("unexpected response from scp")
+	errRemote   = errors.New("the file could not be copied")
+)
+
+// Register adds a loader for the ssh and scp schemes to conflate.SchemeLoaders, which connects with the config.
+func Register(config *ssh.ClientConfig) {
+	load := New(config)
+
+	for _, scheme := range Schemes {
+		conflate.SchemeLoaders[scheme] = load
+	}
+}
+
+// New returns a loader that copies the file at the path of the url from its host, on port 22 unless the url has
+// a port. The user of the url, when set, is used in place of the user of the config. The path is absolute, unless
+// it starts with /~/ when it is relative to the home directory of the user. The config must have a HostKeyCallback,
+// e.g. from golang.org/x/crypto/ssh/knownhosts, along with the Auth methods to try, such as AgentAuth or KeyAuth.
+func New(config *ssh.ClientConfig) conflate.SchemeLoader {
+	return func(ctx gocontext.Context, url *pkgurl.URL) ([]byte, error) {
+		return load(ctx, config, url)
+	}
+}
+
+// AgentAuth returns an auth method that uses the keys of the ssh agent listening at SSH_AUTH_SOCK.
+// The connection to the agent stays open for the life of the program.
+func AgentAuth() (ssh.AuthMethod, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errNoAgent
+	}
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the ssh agent: %w", err)
+	}
+
+	return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), nil
+}
+
+// KeyAuth returns an auth method that uses the PEM encoded private key, decrypted by the passphrase
+// unless it is empty.
+func KeyAuth(pem, passphrase []byte) (ssh.AuthMethod, error) {
+	var (
+		signer ssh.Signer
+		err    error
+	)
+
+	if len(passphrase) == 0 {
+		signer, err = ssh.ParsePrivateKey(pem)
+	} else {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pem, passphrase)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("the private key could not be parsed: %w", err)
+	}
+
+	return ssh.PublicKeys(signer), nil
+}
+
+func load(ctx gocontext.Context, config *ssh.ClientConfig, url *pkgurl.URL) ([]byte, error) {
+	path := remotePath(url)
+	if path == "" {
+		return nil, fmt.Errorf("%w : %v", errNoPath, url.Redacted())
+	}
+
+	client, err := dial(ctx, config, url)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	// the connection is closed to abort the copy when the context is done
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			client.Close()
+		case <-stop:
+		}
+	}()
+
+	data, err := copyFile(client, path)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w : %v", err, url.Redacted())
+	}
+
+	return data, nil
+}
+
+func dial(ctx gocontext.Context, config *ssh.ClientConfig, url *pkgurl.URL) (*ssh.Client, error) {
+	cfg := *config
+	if url.User != nil && url.User.Username() != "" {
+		cfg.User = url.User.Username()
+	}
+
+	addr := url.Host
+	if url.Port() == "" {
+		addr = net.JoinHostPort(url.Hostname(), "22")
+	}
+
+	var dialer net.Dialer
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %v: %w", addr, err)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &cfg)
+	if err != nil {
+		conn.Close()
+
+		return nil, fmt.Errorf("could not connect to %v: %w", addr, err)
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// remotePath returns the path of the file on the host, quoted for the remote shell.
+func remotePath(url *pkgurl.URL) string {
+	path := url.Path
+	if strings.HasPrefix(path, "/~/") {
+		path = path[len("/~/"):]
+	}
+
+	if path == "" || strings.HasSuffix(path, "/") {
+		return ""
+	}
+
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
+
+// copyFile copies the file from the host with the sink side of the scp protocol, where each message from the source
+// is acknowledged with a zero byte.
+func copyFile(client *ssh.Client, path string) ([]byte, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	w, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = session.Start("scp -f -- " + path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(out)
+
+	data, err := receive(r, w)
+	if err != nil {
+		return nil, err
+	}
+
+	w.Close()
+
+	return data, session.Wait()
+}
+
+func receive(r *bufio.Reader, w io.Writer) ([]byte, error) {
+	if _, err := w.Write([]byte{0}); err != nil {
+		return nil, err
+	}
+
+	line, err := readMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the file is announced as "C<mode> <size> <name>"
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "C") {
+		return nil, fmt.Errorf("%w : %q", errProtocol, line)
+	}
+
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%w : %q", errProtocol, line)
+	}
+
+	if _, err = w.Write([]byte{0}); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if _, err = io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	if err = readStatus(r); err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write([]byte{0})
+
+	return data, err
+}
+
+// readMessage reads a line from the source, or the error it reports in its place.
+func readMessage(r *bufio.Reader) (string, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return "", fmt.Errorf("%w : %v", errProtocol, err)
+	}
+
+	if b[0] == 1 || b[0] == 2 {
+		return "", readStatus(r)
+	}
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("%w : %v", errProtocol, err)
+	}
+
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// readStatus reads the status byte following a message, with the message of a warning (1) or an error (2).
+// A missing file is reported as fs.ErrNotExist, so that the url can be optional.
+func readStatus(r *bufio.Reader) error {
+	status, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("%w : %v", errProtocol, err)
+	}
+
+	if status == 0 {
+		return nil
+	}
+
+	msg, _ := r.ReadString('\n')
+	msg = strings.TrimSpace(msg)
+
+	if strings.Contains(msg, "No such file or directory") {
+		return fmt.Errorf("%w : %v", fs.ErrNotExist, msg)
+	}
+
+	return fmt.Errorf("%w : %v", errRemote, msg)
+}
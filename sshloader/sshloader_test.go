@@ -0,0 +1,237 @@
+package sshloader
+
+import (
+	"bytes"
+	gocontext "context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	pkgurl "net/url"
+	"strings"
+	"testing"
+
+	"github.com/diurnalist/conflate"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+var errTestUnknownKey = errors.New("unknown key")
+
+// testServer serves the files over scp to the client key, returning its address and the host key.
+func testServer(t *testing.T, files map[string]string, clientKey ssh.PublicKey) (string, ssh.PublicKey) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	assert.Nil(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if meta.User() == "deploy" && bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+
+			return nil, errTestUnknownKey
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go testServeConn(conn, config, files)
+		}
+	}()
+
+	return listener.Addr().String(), hostKey.PublicKey()
+}
+
+func testServeConn(conn net.Conn, config *ssh.ServerConfig, files map[string]string) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			defer channel.Close()
+
+			for req := range requests {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+
+					continue
+				}
+
+				_ = req.Reply(true, nil)
+
+				status := testServeSCP(channel, string(req.Payload[4:]), files)
+				_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+
+				return
+			}
+		}()
+	}
+}
+
+// testServeSCP is the source side of scp for a single file.
+func testServeSCP(channel ssh.Channel, cmd string, files map[string]string) uint32 {
+	path := strings.TrimPrefix(cmd, "scp -f -- ")
+	path = strings.ReplaceAll(strings.Trim(path, "'"), `'\''`, "'")
+
+	ack := make([]byte, 1)
+	if _, err := io.ReadFull(channel, ack); err != nil {
+		return 1
+	}
+
+	data, ok := files[path]
+	if !ok {
+		fmt.Fprintf(channel, "\x01scp: %v: No such file or directory\n", path)
+
+		return 1
+	}
+
+	fmt.Fprintf(channel, "C0644 %v %v\n", len(data), path)
+
+	if _, err := io.ReadFull(channel, ack); err != nil {
+		return 1
+	}
+
+	fmt.Fprint(channel, data+"\x00")
+
+	if _, err := io.ReadFull(channel, ack); err != nil {
+		return 1
+	}
+
+	return 0
+}
+
+func testClientKey(t *testing.T) ([]byte, ssh.PublicKey) {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	assert.Nil(t, err)
+
+	signer, err := ssh.NewSignerFromKey(priv)
+	assert.Nil(t, err)
+
+	return pem.EncodeToMemory(block), signer.PublicKey()
+}
+
+func testConfig(t *testing.T, key []byte, hostKey ssh.PublicKey) *ssh.ClientConfig {
+	t.Helper()
+
+	auth, err := KeyAuth(key, nil)
+	assert.Nil(t, err)
+
+	return &ssh.ClientConfig{
+		User:            "nobody",
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+	}
+}
+
+func TestRegister(t *testing.T) {
+	key, pub := testClientKey(t)
+	addr, hostKey := testServer(t, map[string]string{
+		"/etc/app/config.json": `{"includes": ["db.json", "?missing.json"], "name": "app"}`,
+		"/etc/app/db.json":     `{"db": {"host": "db"}}`,
+		"config.yaml":          "home: true\n",
+	}, pub)
+
+	Register(testConfig(t, key, hostKey))
+
+	defer func() {
+		for _, scheme := range Schemes {
+			delete(conflate.SchemeLoaders, scheme)
+		}
+	}()
+
+	c, err := conflate.FromFiles("ssh://deploy@"+addr+"/etc/app/config.json", "scp://deploy@"+addr+"/~/config.yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"name": "app",
+		"db":   map[string]interface{}{"host": "db"},
+		"home": true,
+	}, c.Data())
+}
+
+func TestNew_Errors(t *testing.T) {
+	key, pub := testClientKey(t)
+	addr, hostKey := testServer(t, map[string]string{}, pub)
+	load := New(testConfig(t, key, hostKey))
+
+	url, err := pkgurl.Parse("ssh://deploy@" + addr + "/missing.json")
+	assert.Nil(t, err)
+
+	_, err = load(gocontext.Background(), url)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.Contains(t, err.Error(), "/missing.json")
+
+	url.Path = "/dir/"
+	_, err = load(gocontext.Background(), url)
+	assert.ErrorIs(t, err, errNoPath)
+
+	// the user of the config is used when the url has none, and is not allowed by the server
+	url, err = pkgurl.Parse("ssh://" + addr + "/config.json")
+	assert.Nil(t, err)
+
+	_, err = load(gocontext.Background(), url)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "unable to authenticate")
+
+	ctx, cancel := gocontext.WithCancel(gocontext.Background())
+	cancel()
+
+	_, err = load(ctx, url)
+	assert.ErrorIs(t, err, gocontext.Canceled)
+}
+
+func TestKeyAuth(t *testing.T) {
+	_, err := KeyAuth([]byte("not a key"), nil)
+	assert.NotNil(t, err)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	assert.Nil(t, err)
+
+	_, err = KeyAuth(pem.EncodeToMemory(block), []byte("secret"))
+	assert.Nil(t, err)
+
+	_, err = KeyAuth(pem.EncodeToMemory(block), []byte("wrong"))
+	assert.NotNil(t, err)
+}
+
+func TestAgentAuth(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	_, err := AgentAuth()
+	assert.ErrorIs(t, err, errNoAgent)
+}
@@ -0,0 +1,122 @@
+package conflate
+
+import (
+	"fmt"
+	pkgurl "net/url"
+	"sort"
+)
+
+// WarningCode identifies the kind of problem a Warning reports.
+type WarningCode string
+
+// The codes of the warnings that can be reported.
+const (
+	// WarnOptionalIncludeMissing is reported for an optional include that is skipped as there is nothing at its url.
+	WarnOptionalIncludeMissing WarningCode = "optional-include-missing"
+	// WarnUnknownFileSkipped is reported for a file skipped by AddDir as it is not of a known format.
+	WarnUnknownFileSkipped WarningCode = "unknown-file-skipped"
+	// WarnUnknownSchemaFormat is reported for a format in a schema that has no checker, so any value matches it.
+	WarnUnknownSchemaFormat WarningCode = "unknown-schema-format"
+	// WarnDeprecatedSchemaFormat is reported for a format in a schema that has been replaced by another.
+	WarnDeprecatedSchemaFormat WarningCode = "deprecated-schema-format"
+	// WarnSchemaDraftMismatch is reported by ValidateDraft when the schema declares a draft other than the one chosen.
+	WarnSchemaDraftMismatch WarningCode = "schema-draft-mismatch"
+	// WarnSuspectIncludesKey is reported by CheckIncludes for a top level key that looks like a misspelling of
+	// the includes key, so is merged as data rather than loaded.
+	WarnSuspectIncludesKey WarningCode = "suspect-includes-key"
+	// WarnEmptyInclude is reported by CheckIncludes for an include that matches no files, or adds no data.
+	WarnEmptyInclude WarningCode = "empty-include"
+	// WarnOverriddenInclude is reported by CheckIncludes for an include whose values are all replaced by the data
+	// merged after it, so that loading it has no effect.
+	WarnOverriddenInclude WarningCode = "overridden-include"
+)
+
+// deprecatedFormats maps each deprecated schema format to the format replacing it.
+var deprecatedFormats = map[string]string{
+	"pkcs8-public-key": "pkix-public-key",
+}
+
+// Warning is a problem found when loading or validating data that did not stop it.
+type Warning struct {
+	Code WarningCode
+	// Source is the url of the file or schema that the warning is about, which is nil when it was not loaded from a url.
+	Source  *pkgurl.URL
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Source == nil {
+		return fmt.Sprintf("%v: %v", w.Code, w.Message)
+	}
+
+	return fmt.Sprintf("%v: %v (%v)", w.Code, w.Message, redact(w.Source))
+}
+
+// Result holds a copy of the merged data, along with the warnings from loading and validating it.
+type Result struct {
+	Data     map[string]interface{}
+	Warnings []Warning
+}
+
+// warn adds a warning, unless the same warning has already been added.
+func (l *loader) warn(code WarningCode, source *pkgurl.URL, format string, args ...interface{}) {
+	w := Warning{Code: code, Source: source, Message: fmt.Sprintf(format, args...)}
+
+	for _, existing := range l.warnings {
+		if existing.Code == w.Code && existing.Message == w.Message && sameSource(existing.Source, w.Source) {
+			return
+		}
+	}
+
+	l.warnings = append(l.warnings, w)
+}
+
+func sameSource(a, b *pkgurl.URL) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+
+	return containsURL(a, []*pkgurl.URL{b})
+}
+
+// warnSchemaFormats adds a warning for each format in the schema, or the documents it references, that has no checker
+// or is deprecated.
+func (l *loader) warnSchemaFormats(s *Schema) {
+	if s == nil {
+		return
+	}
+
+	if len(s.refs) == 0 {
+		l.warnFormats(nil, s.s)
+
+		return
+	}
+
+	urls := make([]string, 0, len(s.refs))
+	for u := range s.refs {
+		urls = append(urls, u)
+	}
+
+	sort.Strings(urls)
+
+	for _, u := range urls {
+		source, err := pkgurl.Parse(u)
+		if err != nil {
+			source = nil
+		}
+
+		l.warnFormats(source, s.refs[u])
+	}
+}
+
+func (l *loader) warnFormats(source *pkgurl.URL, schema interface{}) {
+	for _, name := range schemaFormats(schema) {
+		if replacement, ok := deprecatedFormats[name]; ok {
+			l.warn(WarnDeprecatedSchemaFormat, source, "the format %v is deprecated, use %v", name, replacement)
+		}
+
+		if !FailUnknownFormats && !hasFormatChecker(name) {
+			l.warn(WarnUnknownSchemaFormat, source, "the format %v has no registered checker", name)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package conflate
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errUnknownOutputFormat = errors.New("the data cannot be marshalled to the format")
+
+// Format is a format the data can be written in by WriteFile.
+type Format string
+
+// The formats the data can be written in. FormatAuto picks the format from the extension of the file.
+const (
+	FormatAuto Format = ""
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+	FormatTOML Format = "toml"
+)
+
+// FileOptions configures how WriteFile creates files.
+type FileOptions struct {
+	// Perm is the permissions of the file, 0644 when it is 0.
+	Perm fs.FileMode
+	// MkdirAll creates the directory of the file, and any parents, when they do not exist, with the permissions DirPerm,
+	// or 0755 when it is 0.
+	MkdirAll bool
+	DirPerm  fs.FileMode
+}
+
+func (o FileOptions) withDefaults() FileOptions {
+	if o.Perm == 0 {
+		o.Perm = 0o644
+	}
+
+	if o.DirPerm == 0 {
+		o.DirPerm = 0o755
+	}
+
+	return o
+}
+
+// formatOf returns the format, or the format given by the extension of the path when it is FormatAuto.
+func formatOf(path string, format Format) (Format, error) {
+	if format == FormatAuto {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json":
+			format = FormatJSON
+		case ".yaml", ".yml":
+			format = FormatYAML
+		case ".toml":
+			format = FormatTOML
+		}
+	}
+
+	switch format {
+	case FormatJSON, FormatYAML, FormatTOML:
+		return format, nil
+	case FormatAuto:
+		return format, fmt.Errorf("%w : no format for the extension of %v", errUnknownOutputFormat, path)
+	default:
+		return format, fmt.Errorf("%w : %v", errUnknownOutputFormat, format)
+	}
+}
+
+func (c *Conflate) marshal(format Format) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return c.MarshalYAML()
+	case FormatTOML:
+		return c.MarshalTOML()
+	default:
+		return c.MarshalJSON()
+	}
+}
+
+// writeFileAtomic writes the data to a temporary file in the same directory as the path, which then replaces
+// any file at the path, so that the file at the path is never partly written.
+func writeFileAtomic(path string, data []byte, opts FileOptions) (err error) {
+	opts = opts.withDefaults()
+	dir := filepath.Dir(path)
+
+	if opts.MkdirAll {
+		if err := os.MkdirAll(dir, opts.DirPerm); err != nil {
+			return err
+		}
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+
+	if err = tmp.Chmod(opts.Perm); err != nil {
+		return err
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,115 @@
+package conflate
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflate_WriteFile(t *testing.T) {
+	c, err := FromData([]byte(`{"a": {"b": 1}}`))
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+
+	for path, want := range map[string]string{
+		"out.json": "{\n  \"a\": {\n    \"b\": 1\n  }\n}\n",
+		"out.yml":  "a:\n  b: 1\n",
+		"out.TOML": "[a]\n  b = 1.0\n",
+	} {
+		path = filepath.Join(dir, path)
+
+		err = c.WriteFile(path, FormatAuto)
+		assert.Nil(t, err, path)
+
+		data, err := os.ReadFile(path)
+		assert.Nil(t, err, path)
+		assert.Equal(t, want, string(data), path)
+	}
+
+	// the format overrides the extension, and an existing file is replaced
+	path := filepath.Join(dir, "out.json")
+
+	err = c.WriteFile(path, FormatYAML)
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "a:\n  b: 1\n", string(data))
+
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestConflate_WriteFileFormatError(t *testing.T) {
+	c := New()
+	path := filepath.Join(t.TempDir(), "out.txt")
+
+	err := c.WriteFile(path, FormatAuto)
+	assert.ErrorIs(t, err, errUnknownOutputFormat)
+	assert.Contains(t, err.Error(), "out.txt")
+
+	err = c.WriteFile(path, "ini")
+	assert.ErrorIs(t, err, errUnknownOutputFormat)
+	assert.Contains(t, err.Error(), "ini")
+
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestConflate_WriteFileOptions(t *testing.T) {
+	c, err := FromData([]byte(`{"a": 1}`))
+	assert.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "conf", "d", "out.json")
+
+	err = c.WriteFile(path, FormatJSON)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "could not write file")
+
+	c.FileOptions(FileOptions{Perm: 0o600, MkdirAll: true, DirPerm: 0o700})
+
+	err = c.WriteFile(path, FormatJSON)
+	assert.Nil(t, err)
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	info, err = os.Stat(filepath.Dir(path))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	// the defaults apply when the permissions are not set
+	c.FileOptions(FileOptions{})
+
+	path = filepath.Join(filepath.Dir(path), "default.json")
+
+	err = c.WriteFile(path, FormatJSON)
+	assert.Nil(t, err)
+
+	info, err = os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+}
+
+func TestWriteFileAtomic_Error(t *testing.T) {
+	dir := t.TempDir()
+
+	// the path is a directory, so it cannot be replaced by the file
+	err := writeFileAtomic(dir, []byte("data"), FileOptions{})
+	assert.NotNil(t, err)
+
+	entries, err := os.ReadDir(filepath.Dir(dir))
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}